@@ -0,0 +1,107 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"hash"
+	"os"
+	"time"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// auditLog, set by -log, receives a record of each file scrubbed.
+var auditLog *os.File
+
+// An auditRecord records the scrubbing of a file. It is written to the
+// audit log as one line of JSON.
+type auditRecord struct {
+	Time      string         `json:"time"`
+	File      string         `json:"file"`
+	Removed   []auditSegment `json:"removed"`
+	BytesIn   int64          `json:"bytes_in"`
+	BytesOut  int64          `json:"bytes_out"`
+	SHA256In  string         `json:"sha256_in"`
+	SHA256Out string         `json:"sha256_out"`
+
+	in, out digest
+}
+
+// An auditSegment records a segment that was removed or rewritten or,
+// in a file of another format, an item such as a chunk or tag, whose
+// format is given as its marker.
+type auditSegment struct {
+	Marker   string `json:"marker"`
+	Contents string `json:"contents,omitempty"`
+	Offset   int64  `json:"offset"`           // -1 for the items of other formats, which are not located.
+	Length   int64  `json:"length"`           // Bytes in the input, with any data following.
+	Written  int64  `json:"written"`          // Bytes in the output.
+	Edited   bool   `json:"edited,omitempty"` // Whether what was kept was changed.
+}
+
+func newAuditRecord(name string) *auditRecord {
+	return &auditRecord{
+		File: name,
+		in:   digest{h: sha256.New()},
+		out:  digest{h: sha256.New()},
+	}
+}
+
+// add records the segment if it was removed or changed, even in place.
+func (r *auditRecord) add(seg scrub.Segment) {
+	in := int64(seg.Length) + seg.Data
+	if seg.Written == in && !seg.Edited {
+		return
+	}
+	r.Removed = append(r.Removed, auditSegment{
+		Marker:   scrub.MarkerName(seg.Marker),
+		Contents: describe(seg),
+		Offset:   seg.Offset,
+		Length:   in,
+		Written:  seg.Written,
+		Edited:   seg.Edited,
+	})
+}
+
+// addRemoval records an item removed or rewritten in a file of the
+// format, which is not JPEG.
+func (r *auditRecord) addRemoval(format string, rm scrub.Removal) {
+	r.Removed = append(r.Removed, auditSegment{
+		Marker:   format,
+		Contents: rm.What,
+		Offset:   -1,
+		Length:   rm.Length,
+		Written:  rm.Written,
+		Edited:   rm.Written != 0,
+	})
+}
+
+// write appends the record to the audit log. The record is written
+// with a single call, so records from concurrent writers do not mix.
+func (r *auditRecord) write() error {
+	r.Time = time.Now().UTC().Format(time.RFC3339)
+	r.BytesIn, r.SHA256In = r.in.n, hex.EncodeToString(r.in.h.Sum(nil))
+	r.BytesOut, r.SHA256Out = r.out.n, hex.EncodeToString(r.out.h.Sum(nil))
+	b, err := json.Marshal(r)
+	if err != nil {
+		return err
+	}
+	_, err = auditLog.Write(append(b, '\n'))
+	return err
+}
+
+// A digest hashes and counts the bytes written to it.
+type digest struct {
+	h hash.Hash
+	n int64
+}
+
+func (d *digest) Write(p []byte) (int, error) {
+	d.n += int64(len(p))
+	return d.h.Write(p)
+}
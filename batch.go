@@ -0,0 +1,310 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"sync"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// stats accumulates the results of processing files, so that one bad
+// file does not stop the rest and the run can end with a summary.
+// Its methods may be called concurrently.
+type stats struct {
+	mu      sync.Mutex
+	batch   bool    // Whether several files are named.
+	files   int     // Files processed.
+	skipped int     // Files skipped because they are not in a format handled.
+	changed int     // Files with metadata removed.
+	removed int64   // Bytes removed, in total.
+	errors  int     // Files that could not be processed.
+	ioErrs  int     // Files that could not be processed because of I/O errors.
+	failed  []error // The errors, each naming its file, in the order they happened.
+}
+
+// run applies process to the named file, or to standard input if the
+// name is empty, and records the result. Errors are reported as they
+// happen. In a batch, files in formats that are not handled are skipped
+// with a note, as are symbolic links if -symlinks=skip is set.
+func (st *stats) run(name string, process func(*os.File) (result, error)) {
+	if prog != nil {
+		// Measure the file before -i replaces it.
+		var size int64
+		if info, err := os.Stat(name); err == nil {
+			size = info.Size()
+		}
+		defer prog.fileDone(size)
+	}
+	if name != "" && *symlinksFlag == "skip" {
+		if info, err := os.Lstat(name); err == nil && info.Mode()&fs.ModeSymlink != 0 {
+			st.skip(name, "symbolic link")
+			return
+		}
+	}
+	f := os.Stdin
+	if name != "" {
+		var err error
+		f, err = os.Open(name)
+		if err != nil {
+			st.add(result{}, err)
+			return
+		}
+		defer f.Close()
+	}
+	if why := unhandled(f); why != "" {
+		if st.batch {
+			st.skip(name, why)
+		} else {
+			st.add(result{}, fmt.Errorf("%s: %s", f.Name(), why))
+		}
+		return
+	}
+	res, err := process(f)
+	if err != nil {
+		err = fmt.Errorf("%s: %w", f.Name(), err)
+	}
+	st.add(res, err)
+}
+
+// runAll runs the named files, up to n at a time.
+func (st *stats) runAll(names []string, n int, process func(*os.File) (result, error)) {
+	sem := make(chan bool, n)
+	var wg sync.WaitGroup
+	for _, name := range names {
+		sem <- true
+		wg.Add(1)
+		go func(name string) {
+			defer wg.Done()
+			st.run(name, process)
+			<-sem
+		}(name)
+	}
+	wg.Wait()
+}
+
+// skip records that the named file was skipped, and why.
+func (st *stats) skip(name, why string) {
+	warn("%s: skipped: %s", name, why)
+	st.mu.Lock()
+	st.files++
+	st.skipped++
+	st.mu.Unlock()
+}
+
+// A result is what processing a file did, or would do.
+type result struct {
+	removed int64 // Bytes of metadata removed.
+	changed bool  // Whether the file was changed, perhaps without changing length.
+}
+
+func (st *stats) add(res result, err error) {
+	st.mu.Lock()
+	defer st.mu.Unlock()
+	st.files++
+	switch {
+	case err != nil:
+		st.errors++
+		st.failed = append(st.failed, err)
+		if isIOError(err) {
+			st.ioErrs++
+		}
+		log.Print(err)
+	case res.changed:
+		st.changed++
+		st.removed += res.removed
+	}
+}
+
+func (st *stats) String() string {
+	return fmt.Sprintf("%d files, %d changed, %d skipped, %d bytes removed, %d errors", st.files, st.changed, st.skipped, st.removed, st.errors)
+}
+
+// jpegOnly is set when the flags select a mode that examines only
+// JPEG files.
+var jpegOnly bool
+
+// unhandled returns why the file is to be skipped, or the empty string
+// if it is not: it is not in a format recognized or, if jpegOnly is set,
+// it is not a JPEG.
+func unhandled(f *os.File) string {
+	magic := make([]byte, scrub.MagicLen)
+	n, err := f.ReadAt(magic, 0)
+	if n == 0 && err != io.EOF {
+		return "" // Let process report the error.
+	}
+	kind := scrub.Detect(magic[:n])
+	switch {
+	case kind == nil:
+		return "unrecognized file format"
+	case jpegOnly && kind.Name != "JPEG":
+		return "holds " + kind.Name + ", not JPEG"
+	}
+	return ""
+}
+
+// summarize reports the totals for a run over several files and, since
+// their errors may have scrolled out of sight, lists the files that
+// failed.
+func (st *stats) summarize() {
+	warn("%s", st)
+	for _, err := range st.failed {
+		log.Printf("failed: %v", err)
+	}
+}
+
+// status returns the exit status that reports the results.
+func (st *stats) status() int {
+	switch {
+	case st.ioErrs > 0:
+		return exitIO
+	case st.errors > 0:
+		return exitError
+	case st.changed > 0:
+		return exitChanged
+	}
+	return exitClean
+}
+
+// isIOError reports whether the error arose in reading or writing a
+// file, rather than in parsing its contents.
+func isIOError(err error) bool {
+	var pe *fs.PathError
+	var le *os.LinkError
+	var se *os.SyscallError
+	return errors.As(err, &pe) || errors.As(err, &le) || errors.As(err, &se)
+}
+
+// expand returns the arguments with glob patterns replaced by the names
+// of the files they match, in order. An argument that names an existing
+// file, or whose pattern matches nothing, is kept as it is, so the error
+// is reported when it is opened.
+func expand(args []string) ([]string, error) {
+	var names []string
+	for _, arg := range args {
+		if !strings.ContainsAny(arg, "*?[") {
+			names = append(names, arg)
+			continue
+		}
+		if _, err := os.Lstat(arg); err == nil {
+			names = append(names, arg)
+			continue
+		}
+		matches, err := filepath.Glob(arg)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %v", arg, err)
+		}
+		if len(matches) == 0 {
+			matches = []string{arg}
+		}
+		names = append(names, matches...)
+	}
+	return names, nil
+}
+
+// readNames returns the names of files listed in the named file, or on
+// standard input if the name is -. The names are separated by newlines
+// or, if nul is set, NUL bytes. Empty names are ignored.
+func readNames(file string, nul bool) ([]string, error) {
+	var data []byte
+	var err error
+	if file == "-" {
+		data, err = ioutil.ReadAll(os.Stdin)
+	} else {
+		data, err = ioutil.ReadFile(file)
+	}
+	if err != nil {
+		return nil, err
+	}
+	sep := "\n"
+	if nul {
+		sep = "\x00"
+	}
+	var names []string
+	for _, name := range strings.Split(string(data), sep) {
+		if !nul {
+			name = strings.TrimSuffix(name, "\r")
+		}
+		if name != "" {
+			names = append(names, name)
+		}
+	}
+	return names, nil
+}
+
+// A pathPatterns is a list of patterns for the names of files, set from
+// a flag holding a comma-separated list. As in path.Match, * matches
+// any part of a name but not a slash, while ** matches any number of
+// directories. A pattern without a slash matches the last element of a
+// name; one with a slash matches the name, or any trailing part of it
+// that begins with a directory.
+type pathPatterns []string
+
+func (p *pathPatterns) String() string {
+	return strings.Join(*p, ",")
+}
+
+func (p *pathPatterns) Set(s string) error {
+	for _, pat := range strings.Split(s, ",") {
+		if _, err := path.Match(pat, ""); err != nil || pat == "" {
+			return fmt.Errorf("bad pattern %q", pat)
+		}
+		*p = append(*p, pat)
+	}
+	return nil
+}
+
+// match reports whether the file name matches one of the patterns.
+func (p pathPatterns) match(name string) bool {
+	elems := strings.Split(filepath.ToSlash(filepath.Clean(name)), "/")
+	for _, pat := range p {
+		if !strings.Contains(pat, "/") {
+			if ok, _ := path.Match(pat, elems[len(elems)-1]); ok {
+				return true
+			}
+			continue
+		}
+		pats := strings.Split(pat, "/")
+		for i := range elems {
+			if matchElems(pats, elems[i:]) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// matchElems reports whether the elements of a name match those of
+// a pattern, in which ** matches any number of elements.
+func matchElems(pats, elems []string) bool {
+	for len(pats) > 0 {
+		if pats[0] == "**" {
+			for i := 0; i <= len(elems); i++ {
+				if matchElems(pats[1:], elems[i:]) {
+					return true
+				}
+			}
+			return false
+		}
+		if len(elems) == 0 {
+			return false
+		}
+		if ok, _ := path.Match(pats[0], elems[0]); !ok {
+			return false
+		}
+		pats, elems = pats[1:], elems[1:]
+	}
+	return len(elems) == 0
+}
@@ -0,0 +1,181 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"syscall"
+	"testing"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+func TestExpand(t *testing.T) {
+	dir := t.TempDir()
+	for _, name := range []string{"a.jpg", "b.jpg", "c.png", "[x].jpg"} {
+		if err := ioutil.WriteFile(filepath.Join(dir, name), nil, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	join := func(names ...string) []string {
+		for i, name := range names {
+			names[i] = filepath.Join(dir, name)
+		}
+		return names
+	}
+	tests := []struct {
+		args []string
+		want []string
+	}{
+		{join("a.jpg", "c.png"), join("a.jpg", "c.png")},
+		{join("*.jpg"), join("[x].jpg", "a.jpg", "b.jpg")},
+		{join("?.*", "c.png"), join("a.jpg", "b.jpg", "c.png", "c.png")},
+		{join("[x].jpg"), join("[x].jpg")}, // An existing file, not a pattern.
+		{join("*.gif"), join("*.gif")},     // No match.
+		{join("missing.jpg"), join("missing.jpg")},
+	}
+	for _, test := range tests {
+		names, err := expand(test.args)
+		if err != nil {
+			t.Errorf("%q: %v", test.args, err)
+			continue
+		}
+		if strings.Join(names, "\x00") != strings.Join(test.want, "\x00") {
+			t.Errorf("%q: got %q; want %q", test.args, names, test.want)
+		}
+	}
+	if _, err := expand([]string{"[x"}); err == nil {
+		t.Errorf("bad pattern: no error")
+	}
+}
+
+func TestReadNames(t *testing.T) {
+	tests := []struct {
+		data string
+		nul  bool
+		want []string
+	}{
+		{"a.jpg\nb c.jpg\n", false, []string{"a.jpg", "b c.jpg"}},
+		{"a.jpg\r\n\r\nb.jpg", false, []string{"a.jpg", "b.jpg"}},
+		{"a.jpg\x00new\nline.jpg\x00\x00", true, []string{"a.jpg", "new\nline.jpg"}},
+		{"", false, nil},
+	}
+	file := filepath.Join(t.TempDir(), "names")
+	for _, test := range tests {
+		if err := ioutil.WriteFile(file, []byte(test.data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names, err := readNames(file, test.nul)
+		if err != nil {
+			t.Errorf("%q: %v", test.data, err)
+			continue
+		}
+		if strings.Join(names, "\x00") != strings.Join(test.want, "\x00") || len(names) != len(test.want) {
+			t.Errorf("%q: got %q; want %q", test.data, names, test.want)
+		}
+	}
+	if _, err := readNames(filepath.Join(t.TempDir(), "missing"), false); err == nil {
+		t.Errorf("missing file: no error")
+	}
+}
+
+func TestPathPatterns(t *testing.T) {
+	tests := []struct {
+		patterns string
+		name     string
+		match    bool
+	}{
+		{"*.png", "a.png", true},
+		{"*.png", "dir/a.png", true},
+		{"*.png", "a.jpg", false},
+		{"*.png,*.gif", "dir/a.gif", true},
+		{"raw/*", "photos/raw/a.jpg", true},
+		{"raw/*", "raw/sub/a.jpg", false},
+		{"raw/*", "photos/rawer/a.jpg", false},
+		{"raw/**", "photos/raw/sub/a.jpg", true},
+		{"**/thumbs/*.jpg", "a/b/thumbs/c.jpg", true},
+		{"a/**/c.jpg", "a/c.jpg", true},
+		{"a/**/c.jpg", "a/b/b/c.jpg", true},
+		{"a/**/c.jpg", "a/b/d.jpg", false},
+		{"*.jpg", "./dir/../a.jpg", true},
+	}
+	for _, test := range tests {
+		var p pathPatterns
+		if err := p.Set(test.patterns); err != nil {
+			t.Errorf("%s: %v", test.patterns, err)
+			continue
+		}
+		if p.match(test.name) != test.match {
+			t.Errorf("%s matching %s: got %t; want %t", test.patterns, test.name, !test.match, test.match)
+		}
+	}
+	for _, bad := range []string{"[a", "*.jpg,", ""} {
+		var p pathPatterns
+		if err := p.Set(bad); err == nil {
+			t.Errorf("%q: no error", bad)
+		}
+	}
+}
+
+func TestIsIOError(t *testing.T) {
+	dir := t.TempDir()
+	_, openErr := os.Open(filepath.Join(dir, "missing"))
+	renameErr := os.Rename(filepath.Join(dir, "missing"), filepath.Join(dir, "new"))
+	tests := []struct {
+		err  error
+		want bool
+	}{
+		{openErr, true},
+		{renameErr, true},
+		{fmt.Errorf("replacing: %w", renameErr), true},
+		{os.NewSyscallError("fsync", syscall.EIO), true},
+		{errors.New("not a JPEG file"), false},
+		{scrub.ErrTruncated, false},
+	}
+	for _, test := range tests {
+		if got := isIOError(test.err); got != test.want {
+			t.Errorf("%v: got %t; want %t", test.err, got, test.want)
+		}
+	}
+}
+
+// Progress counts every file and the bytes in it, whatever is done
+// with the file.
+func TestProgressCounts(t *testing.T) {
+	defer func(p *progress) { prog = p }(prog)
+	prog = &progress{}
+	dir := t.TempDir()
+	files := map[string]string{
+		"a.jpg":   jpegFile(),
+		"b.txt":   "not an image",
+		"bad.jpg": "\xFF\xD8\xFF\xE1\x00\x01",
+	}
+	var names []string
+	var size int64
+	for name, data := range files {
+		name = filepath.Join(dir, name)
+		if err := ioutil.WriteFile(name, []byte(data), 0644); err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+		size += int64(len(data))
+	}
+	names = append(names, filepath.Join(dir, "missing.jpg"))
+	st := stats{batch: true}
+	for _, name := range names {
+		st.run(name, func(f *os.File) (result, error) {
+			_, _, err := clean(ioutil.Discard, f, nil, nil)
+			return result{}, err
+		})
+	}
+	if prog.files != int64(len(names)) || prog.bytes != size {
+		t.Errorf("got %d files, %d bytes; want %d, %d", prog.files, prog.bytes, len(names), size)
+	}
+}
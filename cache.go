@@ -0,0 +1,84 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io"
+	"os"
+	"sync"
+)
+
+// cache, set by -cache, records the files scrubbed.
+var cache *hashCache
+
+// A hashCache holds the SHA-256 hashes of the files scrubbed, as read
+// and as written, in this run and earlier ones, so a file whose contents
+// hash to one of them need not be scrubbed again. The hashes are kept in
+// a file, one per line in hexadecimal, to which new ones are appended.
+// Its methods may be called concurrently.
+type hashCache struct {
+	mu   sync.Mutex
+	f    *os.File
+	sums map[[sha256.Size]byte]bool
+}
+
+// openCache opens the named cache file, creating it if need be.
+func openCache(name string) (*hashCache, error) {
+	f, err := os.OpenFile(name, os.O_RDWR|os.O_APPEND|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+	c := &hashCache{f: f, sums: make(map[[sha256.Size]byte]bool)}
+	s := bufio.NewScanner(f)
+	for line := 1; s.Scan(); line++ {
+		var sum [sha256.Size]byte
+		if n, err := hex.Decode(sum[:], s.Bytes()); err != nil || n != len(sum) {
+			f.Close()
+			return nil, fmt.Errorf("%s:%d: bad hash", name, line)
+		}
+		c.sums[sum] = true
+	}
+	if err := s.Err(); err != nil {
+		f.Close()
+		return nil, err
+	}
+	return c, nil
+}
+
+// has reports whether the hash is in the cache.
+func (c *hashCache) has(sum [sha256.Size]byte) bool {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return c.sums[sum]
+}
+
+// add adds the hash to the cache.
+func (c *hashCache) add(sum [sha256.Size]byte) error {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.sums[sum] {
+		return nil
+	}
+	c.sums[sum] = true
+	_, err := fmt.Fprintf(c.f, "%x\n", sum)
+	return err
+}
+
+// hashFile returns the hash of the contents of f, leaving it positioned
+// at the start.
+func hashFile(f *os.File) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	if _, err := io.Copy(h, f); err != nil {
+		return sum, err
+	}
+	copy(sum[:], h.Sum(nil))
+	_, err := f.Seek(0, io.SeekStart)
+	return sum, err
+}
@@ -0,0 +1,93 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"crypto/sha256"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestHashCache(t *testing.T) {
+	name := filepath.Join(t.TempDir(), "cache")
+	c, err := openCache(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a, b := sha256.Sum256([]byte("a")), sha256.Sum256([]byte("b"))
+	if c.has(a) {
+		t.Errorf("new cache has a hash")
+	}
+	for i := 0; i < 2; i++ {
+		if err := c.add(a); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if !c.has(a) || c.has(b) {
+		t.Errorf("got %t, %t; want true, false", c.has(a), c.has(b))
+	}
+	c.f.Close()
+	// The hashes persist, once each.
+	if c, err = openCache(name); err != nil {
+		t.Fatal(err)
+	}
+	c.f.Close()
+	if !c.has(a) || c.has(b) {
+		t.Errorf("reopened: got %t, %t; want true, false", c.has(a), c.has(b))
+	}
+	if data, err := ioutil.ReadFile(name); err != nil || len(data) != 2*sha256.Size+1 {
+		t.Errorf("cache file holds %q (error %v)", data, err)
+	}
+	if err := ioutil.WriteFile(name, []byte("bad\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := openCache(name); err == nil {
+		t.Errorf("bad cache file: no error")
+	}
+}
+
+// With -d, the input is left as it is, so its hash, not the output's,
+// identifies it next time.
+func TestCacheSkipsInput(t *testing.T) {
+	dir := t.TempDir()
+	defer func(d string) { *dirFlag = d }(*dirFlag)
+	*dirFlag = filepath.Join(dir, "out")
+	if err := os.Mkdir(*dirFlag, 0777); err != nil {
+		t.Fatal(err)
+	}
+	c, err := openCache(filepath.Join(dir, "cache"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer c.f.Close()
+	cache = c
+	defer func() { cache = nil }()
+	in := filepath.Join(dir, "in.jpg")
+	out := filepath.Join(*dirFlag, "in.jpg")
+	if err := ioutil.WriteFile(in, []byte(jpegFile(segment(app1, "Exif\x00\x00secret"))), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for run := 1; run <= 2; run++ {
+		f, err := os.Open(in)
+		if err != nil {
+			t.Fatal(err)
+		}
+		res, err := scrubFile(f)
+		f.Close()
+		if err != nil {
+			t.Fatalf("run %d: %v", run, err)
+		}
+		_, err = os.Stat(out)
+		switch {
+		case run == 1 && (err != nil || !res.changed):
+			t.Errorf("run %d: not scrubbed (error %v)", run, err)
+		case run == 2 && (err == nil || res.changed):
+			t.Errorf("run %d: scrubbed again", run)
+		}
+		os.Remove(out)
+	}
+}
@@ -0,0 +1,95 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// riskyTags lists the EXIF tags that identify a person or a camera.
+var riskyTags = map[string]string{
+	"SerialNumber":     "camera serial number",
+	"LensSerialNumber": "lens serial number",
+	"OwnerName":        "owner name",
+	"Artist":           "artist name",
+	"XPAuthor":         "author name",
+}
+
+// riskyXMP lists the XMP properties that identify a person, a camera,
+// or a place.
+var riskyXMP = map[string]string{
+	"exif:GPSLatitude":        "XMP GPS latitude",
+	"exif:GPSLongitude":       "XMP GPS longitude",
+	"aux:SerialNumber":        "XMP camera serial number",
+	"exifEX:BodySerialNumber": "XMP camera serial number",
+	"exifEX:LensSerialNumber": "XMP lens serial number",
+	"aux:OwnerName":           "XMP owner name",
+	"exifEX:CameraOwnerName":  "XMP owner name",
+}
+
+// detect reads the JPEG stream from r and returns a description of each
+// piece of privacy-sensitive metadata it holds: locations, serial numbers,
+// names, and embedded thumbnails.
+func detect(r io.Reader) ([]string, error) {
+	var findings []string
+	s := newScanner(r, nil)
+	for s.Scan() {
+		seg := s.Segment()
+		p := seg.Payload
+		switch m := seg.Marker; {
+		case m == app0 && scrub.IsJFIF(p):
+			if p[12] != 0 && p[13] != 0 {
+				findings = append(findings, fmt.Sprintf("JFIF thumbnail, %dx%d", p[12], p[13]))
+			}
+		case m == app1 && scrub.IsEXIF(p):
+			fields, _ := scrub.DecodeEXIF(p)
+			findings = append(findings, detectEXIF(fields)...)
+		case m == app1 && scrub.IsXMP(p):
+			props, _ := scrub.DecodeXMP(p)
+			for _, prop := range props {
+				if what, ok := riskyXMP[prop.Name]; ok {
+					findings = append(findings, fmt.Sprintf("%s %q", what, prop.Value))
+				}
+			}
+			if scrub.IsMotionPhoto(p) {
+				findings = append(findings, "motion photo; a video, with its own metadata, follows the main image")
+			}
+		case m == app2 && scrub.IsMPF(p):
+			findings = append(findings, "multi-picture index; hidden images may follow the main one")
+		}
+	}
+	return findings, s.Err()
+}
+
+// detectEXIF returns the findings in the EXIF fields.
+func detectEXIF(fields []scrub.Field) []string {
+	var findings []string
+	gps := make(map[string]interface{})
+	for _, f := range fields {
+		name := f.Tag.String()
+		switch {
+		case f.Tag.IFD == scrub.GPSIFD:
+			gps[name] = f.Value
+		case f.Tag == scrub.MakerNote:
+			findings = append(findings, "maker note, which may hold serial numbers")
+		case f.Tag.IFD == scrub.IFD1 && name == "ThumbnailLength":
+			findings = append(findings, "EXIF thumbnail, "+format(f)+" bytes")
+		case riskyTags[name] != "" && f.Tag.IFD != scrub.IFD1:
+			findings = append(findings, fmt.Sprintf("%s %q", riskyTags[name], format(f)))
+		}
+	}
+	lat, ok1 := degrees(gps["GPSLatitude"], gps["GPSLatitudeRef"])
+	lon, ok2 := degrees(gps["GPSLongitude"], gps["GPSLongitudeRef"])
+	switch {
+	case ok1 && ok2:
+		findings = append(findings, fmt.Sprintf("GPS position %.6f, %.6f", lat, lon))
+	case len(gps) > 0:
+		findings = append(findings, "GPS data")
+	}
+	return findings
+}
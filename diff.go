@@ -0,0 +1,143 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"io"
+	"os"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// A summary is what diff compares of a file: a hash of the image itself,
+// the size of any data after it, and the metadata segments.
+type summary struct {
+	image    [sha256.Size]byte
+	trailing int64
+	segs     []metaSegment
+}
+
+// A metaSegment is a metadata segment. Its key identifies its kind.
+type metaSegment struct {
+	key     string
+	seg     scrub.Segment
+	payload []byte
+}
+
+// summarize reads the named file and returns its summary.
+func summarize(name string) (*summary, error) {
+	f, err := os.Open(name)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	h := sha256.New()
+	s := newScanner(f, h)
+	s.DropTrailer(false)
+	sum := new(summary)
+	for s.Scan() {
+		seg := s.Segment()
+		switch {
+		case seg.Marker == scrub.EOI:
+			sum.trailing = seg.Data
+		case !scrub.KeepImage(seg.Marker, seg.Payload):
+			key := scrub.MarkerName(seg.Marker)
+			if seg.Marker != scrub.COM {
+				key += " " + describe(seg)
+			}
+			sum.segs = append(sum.segs, metaSegment{key, seg, append([]byte(nil), seg.Payload...)})
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	h.Sum(sum.image[:0])
+	return sum, nil
+}
+
+// diff prints to w how the JPEG files a and b differ, in their image
+// data and their metadata, and reports whether they do. Metadata segments
+// of the same kind are paired in order; lines beginning - describe what
+// is only in a, + what is only in b, and ~ what is in both but differs.
+func diff(w io.Writer, a, b string) (bool, error) {
+	sa, err := summarize(a)
+	if err != nil {
+		return false, err
+	}
+	sb, err := summarize(b)
+	if err != nil {
+		return false, err
+	}
+	differ := false
+	if sa.image != sb.image {
+		fmt.Fprintf(w, "~ image data differs\n")
+		differ = true
+	}
+	if sa.trailing != sb.trailing {
+		fmt.Fprintf(w, "~ trailing data: %d bytes, %d bytes\n", sa.trailing, sb.trailing)
+		differ = true
+	}
+	used := make([]bool, len(sb.segs))
+	for _, ma := range sa.segs {
+		j := -1
+		for k, mb := range sb.segs {
+			if !used[k] && mb.key == ma.key {
+				j = k
+				break
+			}
+		}
+		if j < 0 {
+			fmt.Fprintf(w, "- %s at 0x%x, %d bytes\n", ma.key, ma.seg.Offset, ma.seg.Length)
+			differ = true
+			continue
+		}
+		used[j] = true
+		mb := sb.segs[j]
+		if bytes.Equal(ma.payload, mb.payload) {
+			continue
+		}
+		fmt.Fprintf(w, "~ %s: %d bytes, %d bytes\n", ma.key, ma.seg.Length, mb.seg.Length)
+		if ma.seg.Marker == app1 && scrub.IsEXIF(ma.payload) {
+			diffEXIF(w, ma.payload, mb.payload)
+		}
+		differ = true
+	}
+	for k, mb := range sb.segs {
+		if !used[k] {
+			fmt.Fprintf(w, "+ %s at 0x%x, %d bytes\n", mb.key, mb.seg.Offset, mb.seg.Length)
+			differ = true
+		}
+	}
+	return differ, nil
+}
+
+// diffEXIF prints, indented, how the tags of two EXIF payloads differ.
+func diffEXIF(w io.Writer, a, b []byte) {
+	fa, _ := scrub.DecodeEXIF(a)
+	fb, _ := scrub.DecodeEXIF(b)
+	inB := make(map[scrub.Tag]scrub.Field)
+	for _, f := range fb {
+		inB[f.Tag] = f
+	}
+	inA := make(map[scrub.Tag]bool)
+	for _, f := range fa {
+		inA[f.Tag] = true
+		g, ok := inB[f.Tag]
+		switch {
+		case !ok:
+			fmt.Fprintf(w, "\t- %s: %s\n", f.Tag, format(f))
+		case format(f) != format(g):
+			fmt.Fprintf(w, "\t~ %s: %s, %s\n", f.Tag, format(f), format(g))
+		}
+	}
+	for _, f := range fb {
+		if !inA[f.Tag] {
+			fmt.Fprintf(w, "\t+ %s: %s\n", f.Tag, format(f))
+		}
+	}
+}
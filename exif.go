@@ -0,0 +1,141 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"text/tabwriter"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// printEXIF prints to w the EXIF tags of the JPEG stream read from r,
+// one per line, with their values in human-readable form.
+func printEXIF(w io.Writer, r io.Reader) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	s := newScanner(r, nil)
+	for s.Scan() {
+		seg := s.Segment()
+		if seg.Marker != app1 || !scrub.IsEXIF(seg.Payload) {
+			continue
+		}
+		fields, err := scrub.DecodeEXIF(seg.Payload)
+		printFields(tw, fields)
+		if err != nil {
+			tw.Flush()
+			return err
+		}
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return s.Err()
+}
+
+// printFields prints the fields. The GPS coordinates, if both are
+// complete, are combined into one position in decimal degrees, and the
+// thumbnail's tags, which share their names with the image's, are
+// marked as such.
+func printFields(w io.Writer, fields []scrub.Field) {
+	value := make(map[string]interface{})
+	for _, f := range fields {
+		if f.Tag.IFD == scrub.GPSIFD {
+			value[f.Tag.String()] = f.Value
+		}
+	}
+	lat, ok1 := degrees(value["GPSLatitude"], value["GPSLatitudeRef"])
+	lon, ok2 := degrees(value["GPSLongitude"], value["GPSLongitudeRef"])
+	position := ok1 && ok2
+	for _, f := range fields {
+		name := f.Tag.String()
+		switch name {
+		case "GPSLatitudeRef", "GPSLongitudeRef", "GPSLongitude":
+			if position {
+				continue
+			}
+		case "GPSLatitude":
+			if position {
+				fmt.Fprintf(w, "GPSPosition\t%.6f, %.6f\n", lat, lon)
+				continue
+			}
+		}
+		if f.Tag.IFD == scrub.IFD1 {
+			name = "Thumbnail " + name
+		}
+		fmt.Fprintf(w, "%s\t%s\n", name, format(f))
+	}
+}
+
+// degrees returns the coordinate, given as degrees, minutes, and seconds,
+// in signed decimal degrees; ref gives the hemisphere.
+func degrees(v, ref interface{}) (float64, bool) {
+	dms, ok := v.([]scrub.Rational)
+	if !ok || len(dms) != 3 {
+		return 0, false
+	}
+	d := dms[0].Float() + dms[1].Float()/60 + dms[2].Float()/3600
+	if ref == "S" || ref == "W" {
+		d = -d
+	}
+	return d, true
+}
+
+// format returns the value of the field in human-readable form.
+func format(f scrub.Field) string {
+	if r, ok := f.Value.([]scrub.Rational); ok && len(r) == 1 && r[0].Den != 0 {
+		switch f.Tag.String() {
+		case "ExposureTime":
+			if r[0].Num != 0 && r[0].Den%r[0].Num == 0 {
+				return fmt.Sprintf("1/%d s", r[0].Den/r[0].Num)
+			}
+			return fmt.Sprintf("%g s", r[0].Float())
+		case "FNumber":
+			return fmt.Sprintf("f/%.1f", r[0].Float())
+		case "FocalLength":
+			return fmt.Sprintf("%g mm", r[0].Float())
+		case "GPSAltitude":
+			return fmt.Sprintf("%g m", r[0].Float())
+		}
+	}
+	if f.Tag == scrub.Orientation {
+		if u, ok := f.Value.([]uint32); ok && len(u) == 1 && 1 <= u[0] && int(u[0]) < len(orientations) {
+			return orientations[u[0]]
+		}
+	}
+	switch v := f.Value.(type) {
+	case string:
+		return v
+	case []byte:
+		if printable(v) {
+			return string(v)
+		}
+		return fmt.Sprintf("(%d bytes)", len(v))
+	case []scrub.Rational:
+		s := make([]string, len(v))
+		for i, r := range v {
+			if r.Den == 1 {
+				s[i] = fmt.Sprint(r.Num)
+			} else {
+				s[i] = fmt.Sprintf("%g", r.Float())
+			}
+		}
+		return strings.Join(s, " ")
+	}
+	return strings.Trim(fmt.Sprint(f.Value), "[]")
+}
+
+// orientations describes the values of the Orientation tag.
+var orientations = [...]string{
+	1: "normal",
+	2: "mirrored",
+	3: "rotated 180°",
+	4: "mirrored and rotated 180°",
+	5: "mirrored and rotated 90° counterclockwise",
+	6: "rotated 90° clockwise",
+	7: "mirrored and rotated 90° clockwise",
+	8: "rotated 90° counterclockwise",
+}
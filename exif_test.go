@@ -0,0 +1,39 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"testing"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+func TestPrintFields(t *testing.T) {
+	gps := func(id uint16, v interface{}) scrub.Field {
+		return scrub.Field{Tag: scrub.Tag{IFD: scrub.GPSIFD, ID: id}, Value: v}
+	}
+	dms := func(d, m, s int64) []scrub.Rational {
+		return []scrub.Rational{{Num: d, Den: 1}, {Num: m, Den: 1}, {Num: s, Den: 1}}
+	}
+	latRef, lat := gps(0x0001, "S"), gps(0x0002, dms(33, 51, 36))
+	lonRef, lon := gps(0x0003, "E"), gps(0x0004, dms(151, 12, 36))
+	tests := []struct {
+		name   string
+		fields []scrub.Field
+		out    string
+	}{
+		{"position", []scrub.Field{latRef, lat, lonRef, lon}, "GPSPosition\t-33.860000, 151.210000\n"},
+		{"latitude only", []scrub.Field{latRef, lat}, "GPSLatitudeRef\tS\nGPSLatitude\t33 51 36\n"},
+		{"longitude only", []scrub.Field{lonRef, lon}, "GPSLongitudeRef\tE\nGPSLongitude\t151 12 36\n"},
+	}
+	for _, test := range tests {
+		var b bytes.Buffer
+		printFields(&b, test.fields)
+		if b.String() != test.out {
+			t.Errorf("%s: got %q; want %q", test.name, b.String(), test.out)
+		}
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"hash"
+	"io"
+	"io/ioutil"
+	"path/filepath"
+	"strings"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// fileFormat returns the format of the file read by br, or an error if
+// it is not recognized.
+func fileFormat(br *bufio.Reader) (*scrub.Format, error) {
+	magic, err := br.Peek(scrub.MagicLen)
+	if len(magic) == 0 && err != nil && err != io.EOF {
+		return nil, err
+	}
+	if f := scrub.Detect(magic); f != nil {
+		return f, nil
+	}
+	return nil, errors.New("unrecognized file format")
+}
+
+// formatExts maps the extensions of file names to the formats whose
+// files usually have them.
+var formatExts = map[string]string{
+	".jpg": "JPEG", ".jpeg": "JPEG", ".jpe": "JPEG", ".jfif": "JPEG",
+	".png": "PNG",
+	".tif": "TIFF", ".tiff": "TIFF", ".dng": "TIFF",
+	".webp": "WebP",
+	".heic": "HEIF", ".heif": "HEIF", ".avif": "HEIF",
+	".gif": "GIF",
+	".exr": "EXR",
+	".pdf": "PDF",
+	".jxl": "JXL",
+	".mp4": "MP4", ".mov": "MP4", ".m4v": "MP4", ".m4a": "MP4", ".3gp": "MP4",
+	".psd":  "PSD",
+	".svg":  "SVG",
+	".flac": "FLAC",
+	".ogg":  "Ogg", ".oga": "Ogg", ".opus": "Ogg",
+	".wav": "WAV",
+	".aif": "AIFF", ".aiff": "AIFF",
+	".docx": "Office", ".xlsx": "Office", ".pptx": "Office",
+	".epub": "EPUB",
+	".zip":  "ZIP",
+	".tar":  "tar", ".tgz": "tar",
+	".mkv": "MKV", ".webm": "MKV",
+	".mp3": "MP3",
+	".ico": "ICO", ".cur": "ICO",
+}
+
+// misnamed returns a description of the mismatch if the extension of
+// the file's name belongs to a format other than that of its contents,
+// as with a PNG image renamed photo.jpg, or the empty string if not.
+func misnamed(name string, kind *scrub.Format) string {
+	want, ok := formatExts[strings.ToLower(filepath.Ext(name))]
+	if !ok || want == kind.Name {
+		return ""
+	}
+	return fmt.Sprintf("named as %s but holds %s", want, kind.Name)
+}
+
+// scrubOther scrubs the file read by r, which is in the format, writing
+// the result to w. If the format reports what it removes, each item
+// removed or rewritten is passed to removed. It returns the number of
+// bytes removed and whether the file was changed, which it may be with
+// none removed. Anything after the end of the file's data is removed
+// too.
+func scrubOther(kind *scrub.Format, w io.Writer, r io.Reader, removed func(scrub.Removal)) (int64, bool, error) {
+	in := &counter{r: r, h: sha256.New()}
+	out := &counter{w: w, h: sha256.New()}
+	var err error
+	if kind.Report != nil {
+		err = kind.Report(in, out, removed)
+	} else {
+		err = kind.Scrub(in, out)
+	}
+	if err == nil {
+		_, err = io.Copy(ioutil.Discard, in)
+	}
+	changed := in.n != out.n || !bytes.Equal(in.h.Sum(nil), out.h.Sum(nil))
+	return in.n - out.n, changed, err
+}
+
+// A counter counts and hashes the bytes read or written through it.
+type counter struct {
+	r io.Reader
+	w io.Writer
+	h hash.Hash
+	n int64
+}
+
+func (c *counter) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	c.h.Write(p[:n])
+	return n, err
+}
+
+func (c *counter) Write(p []byte) (int, error) {
+	n, err := c.w.Write(p)
+	c.n += int64(n)
+	c.h.Write(p[:n])
+	return n, err
+}
@@ -0,0 +1,176 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"math"
+	"unicode"
+	"unicode/utf8"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// jsonMetadata is the JSON description of the metadata in a file.
+type jsonMetadata struct {
+	Segments []jsonSegment `json:"segments"`
+	Trailing int64         `json:"trailing,omitempty"` // Bytes after EOI.
+	// TrailingKind says what the trailing data appears to be.
+	TrailingKind string `json:"trailing_kind,omitempty"`
+}
+
+// jsonSegment is the JSON description of a metadata segment.
+// The contents are decoded where possible.
+type jsonSegment struct {
+	Offset   int64                             `json:"offset"`
+	Marker   string                            `json:"marker"`
+	Length   int                               `json:"length"`
+	Contents string                            `json:"contents,omitempty"`
+	EXIF     map[string]map[string]interface{} `json:"exif,omitempty"`
+	XMP      map[string]interface{}            `json:"xmp,omitempty"`
+	IPTC     map[string]interface{}            `json:"iptc,omitempty"`
+	Comment  string                            `json:"comment,omitempty"`
+	Error    string                            `json:"error,omitempty"`
+}
+
+// printJSON writes to w a JSON description of the metadata segments of
+// the JPEG stream read from r.
+func printJSON(w io.Writer, r io.Reader) error {
+	var f jsonMetadata
+	s := newScanner(r, nil)
+	for s.Scan() {
+		seg := s.Segment()
+		if seg.Marker == scrub.EOI {
+			f.Trailing = seg.Data
+			f.TrailingKind = s.Trailer()
+		}
+		if seg.Marker < scrub.APPn {
+			continue
+		}
+		j := jsonSegment{
+			Offset:   seg.Offset,
+			Marker:   scrub.MarkerName(seg.Marker),
+			Length:   seg.Length,
+			Contents: describe(seg),
+		}
+		if err := decode(&j, seg); err != nil {
+			j.Error = err.Error()
+		}
+		f.Segments = append(f.Segments, j)
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	b, err := json.MarshalIndent(f, "", "\t")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintf(w, "%s\n", b)
+	return err
+}
+
+// decode fills in the decoded contents of the segment.
+func decode(j *jsonSegment, seg scrub.Segment) error {
+	p := seg.Payload
+	switch m := seg.Marker; {
+	case m == scrub.COM:
+		j.Comment = string(p)
+		j.Contents = "comment"
+	case m == app1 && scrub.IsEXIF(p):
+		fields, err := scrub.DecodeEXIF(p)
+		j.EXIF = make(map[string]map[string]interface{})
+		for _, f := range fields {
+			ifd := f.Tag.IFD.String()
+			if j.EXIF[ifd] == nil {
+				j.EXIF[ifd] = make(map[string]interface{})
+			}
+			j.EXIF[ifd][f.Tag.String()] = jsonValue(f.Value)
+		}
+		return err
+	case m == app1 && scrub.IsXMP(p):
+		props, err := scrub.DecodeXMP(p)
+		j.XMP = make(map[string]interface{})
+		for _, p := range props {
+			add(j.XMP, p.Name, p.Value)
+		}
+		return err
+	case m == app13 && scrub.IsPhotoshop(p):
+		fields, err := scrub.DecodeIPTC(p)
+		j.IPTC = make(map[string]interface{})
+		for _, f := range fields {
+			add(j.IPTC, f.Name(), jsonValue(f.Value))
+		}
+		return err
+	}
+	return nil
+}
+
+// add adds the value to the map, making a list if the key repeats.
+func add(m map[string]interface{}, key string, v interface{}) {
+	switch old := m[key].(type) {
+	case nil:
+		m[key] = v
+	case []interface{}:
+		m[key] = append(old, v)
+	default:
+		m[key] = []interface{}{old, v}
+	}
+}
+
+// jsonValue returns a form of the decoded value suited to JSON.
+// Single values are not wrapped in a list, rationals are written
+// as fractions, bytes as text if they are printable, and NaN and the
+// infinities, which JSON cannot hold, as strings.
+func jsonValue(v interface{}) interface{} {
+	var list []interface{}
+	switch v := v.(type) {
+	case []byte:
+		if printable(v) {
+			return string(v)
+		}
+		return fmt.Sprintf("% x", v)
+	case []uint32:
+		for _, x := range v {
+			list = append(list, x)
+		}
+	case []int32:
+		for _, x := range v {
+			list = append(list, x)
+		}
+	case []float64:
+		for _, x := range v {
+			if math.IsNaN(x) || math.IsInf(x, 0) {
+				list = append(list, fmt.Sprint(x))
+				continue
+			}
+			list = append(list, x)
+		}
+	case []scrub.Rational:
+		for _, x := range v {
+			list = append(list, x.String())
+		}
+	default:
+		return v
+	}
+	if len(list) == 1 {
+		return list[0]
+	}
+	return list
+}
+
+// printable reports whether b is text that can be shown as is.
+func printable(b []byte) bool {
+	if !utf8.Valid(b) {
+		return false
+	}
+	for _, r := range string(b) {
+		if !unicode.IsPrint(r) && !unicode.IsSpace(r) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,39 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/json"
+	"math"
+	"testing"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+func TestJSONValue(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		json  string
+	}{
+		{"text", `"text"`},
+		{[]byte("text"), `"text"`},
+		{[]byte{0, 1}, `"00 01"`},
+		{[]uint32{7}, `7`},
+		{[]int32{-1, 2}, `[-1,2]`},
+		{[]scrub.Rational{{Num: 1, Den: 250}}, `"1/250"`},
+		{[]float64{1.5}, `1.5`},
+		{[]float64{math.NaN(), math.Inf(1), math.Inf(-1)}, `["NaN","+Inf","-Inf"]`},
+	}
+	for _, test := range tests {
+		b, err := json.Marshal(jsonValue(test.value))
+		if err != nil {
+			t.Errorf("%v: %v", test.value, err)
+			continue
+		}
+		if string(b) != test.json {
+			t.Errorf("%v: got %s; want %s", test.value, b, test.json)
+		}
+	}
+}
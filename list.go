@@ -0,0 +1,71 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+	"text/tabwriter"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// list prints to w a table of the segments of the JPEG stream read from r.
+func list(w io.Writer, r io.Reader) error {
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', 0)
+	fmt.Fprintf(tw, "offset\tmarker\tlength\tcontents\n")
+	s := newScanner(r, nil)
+	for s.Scan() {
+		seg := s.Segment()
+		what := describe(seg)
+		if seg.Marker == scrub.EOI && seg.Data > 0 {
+			what += ": " + s.Trailer()
+		}
+		fmt.Fprintf(tw, "0x%x\t%s\t%d\t%s\n", seg.Offset, scrub.MarkerName(seg.Marker), seg.Length, what)
+	}
+	if err := tw.Flush(); err != nil {
+		return err
+	}
+	return s.Err()
+}
+
+// describe returns a short description of what the segment holds.
+func describe(seg scrub.Segment) string {
+	p := seg.Payload
+	switch m := seg.Marker; {
+	case scrub.IsSOF(m) && len(p) >= 5 && p[1] == 0 && p[2] == 0:
+		return fmt.Sprintf("%d-wide image, height given by DNL", int(p[3])<<8|int(p[4]))
+	case scrub.IsSOF(m) && len(p) >= 5:
+		return fmt.Sprintf("%dx%d image", int(p[3])<<8|int(p[4]), int(p[1])<<8|int(p[2]))
+	case m == scrub.DNL && len(p) >= 2:
+		return fmt.Sprintf("%d lines", int(p[0])<<8|int(p[1]))
+	case m == scrub.SOS:
+		return fmt.Sprintf("%d bytes of image data", seg.Data)
+	case m == scrub.EOI && seg.Data > 0:
+		return fmt.Sprintf("%d bytes of trailing data", seg.Data)
+	case m == app0 && scrub.IsJFIF(p):
+		return "JFIF header"
+	case m == app1 && scrub.IsEXIF(p):
+		return "EXIF data"
+	case m == app1 && scrub.IsXMP(p):
+		return "XMP packet"
+	case m == app1 && scrub.IsExtendedXMP(p):
+		return "extended XMP"
+	case m == app2 && scrub.IsICC(p):
+		return "ICC profile"
+	case m == app2 && scrub.IsMPF(p):
+		return "MPF index"
+	case m == app13 && scrub.IsPhotoshop(p):
+		return "Photoshop IPTC resources"
+	case m == app14 && scrub.IsAdobe(p):
+		return "Adobe color transform"
+	case m == scrub.COM:
+		if len(p) > 40 {
+			return fmt.Sprintf("comment %q...", p[:40])
+		}
+		return fmt.Sprintf("comment %q", p)
+	}
+	return ""
+}
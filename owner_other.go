@@ -0,0 +1,15 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build !unix
+
+package main
+
+import "os"
+
+// keepOwner gives the named file the permissions recorded in info.
+// Owners are not kept on this system.
+func keepOwner(name string, info os.FileInfo) error {
+	return os.Chmod(name, info.Mode().Perm())
+}
@@ -0,0 +1,23 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+//go:build unix
+
+package main
+
+import (
+	"os"
+	"syscall"
+)
+
+// keepOwner gives the named file the permissions, owner, and group
+// recorded in info. Only the superuser may give a file away, so
+// failing to set the owner and group is not an error. The mode is set
+// last, since changing the owner clears the setuid and setgid bits.
+func keepOwner(name string, info os.FileInfo) error {
+	if st, ok := info.Sys().(*syscall.Stat_t); ok {
+		os.Chown(name, int(st.Uid), int(st.Gid))
+	}
+	return os.Chmod(name, info.Mode()&(os.ModePerm|os.ModeSetuid|os.ModeSetgid|os.ModeSticky))
+}
@@ -0,0 +1,386 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"math/rand"
+	"path"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// The flags decide which segments survive, and how.
+
+const (
+	app0  = scrub.APPn
+	app1  = scrub.APPn + 1
+	app2  = scrub.APPn + 2
+	app13 = scrub.APPn + 13
+	app14 = scrub.APPn + 14
+)
+
+// keep reports whether to keep the segment.
+func keep(marker byte, payload []byte) bool {
+	if scrub.KeepImage(marker, payload) {
+		return true
+	}
+	if selective() {
+		return !only[kind(marker, payload)] && !removeApp[app(marker)] && !tooBig(marker, payload)
+	}
+	if keepApp[app(marker)] {
+		return true
+	}
+	switch {
+	case marker == app0 && scrub.IsJFIF(payload):
+		return *keepJFIF
+	case marker == app1 && scrub.IsEXIF(payload):
+		return keepsTags()
+	case marker == app2 && scrub.IsICC(payload):
+		return *keepICC
+	case marker == app14 && scrub.IsAdobe(payload):
+		switch *adobeFlag {
+		case "keep":
+			return true
+		case "auto":
+			return scrub.AdobeTransform(payload) != scrub.AdobeYCbCr
+		}
+	}
+	return false
+}
+
+// A parts records, for the image being scrubbed, whether to keep the
+// metadata split across several segments: an ICC profile, and an XMP
+// packet with the extended XMP data that continues it. Each part shares
+// the fate of the first, so no fragment is left without the rest and
+// no packet points to extended data that is gone.
+type parts map[string]bool
+
+// keep is keep applied to the segments of one image in turn.
+func (p parts) keep(marker byte, payload []byte) bool {
+	k := keep(marker, payload)
+	var set string
+	switch {
+	case marker == scrub.SOI:
+		for s := range p {
+			delete(p, s) // A new image.
+		}
+		return k
+	case marker == app2 && scrub.IsICC(payload):
+		if len(payload) > 12 && payload[12] <= 1 {
+			delete(p, "icc") // The first part of a new profile.
+		}
+		set = "icc"
+	case marker == app1 && scrub.IsXMP(payload):
+		p["xmp"] = k
+		delete(p, "extended xmp")
+		return k
+	case marker == app1 && scrub.IsExtendedXMP(payload):
+		if packet, ok := p["xmp"]; ok {
+			return packet
+		}
+		set = "extended xmp" // Data without a packet.
+	default:
+		return k
+	}
+	if first, ok := p[set]; ok {
+		return first
+	}
+	p[set] = k
+	return k
+}
+
+// rng is the source of decoy values for -fake. Since files may be
+// scrubbed concurrently, it is used only through fakeEXIF.
+var (
+	rng   = rand.New(rand.NewSource(time.Now().UnixNano()))
+	rngMu sync.Mutex
+)
+
+// fakeEXIF returns the payload of an EXIF segment holding decoy values
+// in place of the original payload, which may be nil.
+func fakeEXIF(payload []byte) []byte {
+	rngMu.Lock()
+	defer rngMu.Unlock()
+	return scrub.FakeEXIF(rng, payload)
+}
+
+// filter returns the filter for s. Besides applying keep, through p,
+// it drops the images trailing the main one when the MPF index that
+// locates them is removed, and likewise the video of a motion photo
+// when the XMP data that marks it is removed, unless motion holds the
+// video scrubbed for -motion=scrub, in which case new XMP data locates
+// it. It also adds any new EXIF segment: that requested by
+// -synthetic-exif, whose payload is synthetic, or by -fake. The new
+// segment goes after SOI and any JFIF header. With -fake, an EXIF
+// segment already there is kept for edit to replace. Otherwise, for
+// -add-jfif, a JFIF header goes before the first segment kept after
+// SOI unless that is a JFIF header or EXIF segment.
+func filter(s *scrub.Scanner, p parts, synthetic []byte, motion *motionVideo) func(marker byte, payload []byte) bool {
+	placing := synthetic != nil || *fake
+	jfif := false
+	return func(marker byte, payload []byte) bool {
+		k := p.keep(marker, payload)
+		if marker == scrub.SOI {
+			jfif = *addJFIF && !placing
+		}
+		if marker == app2 && scrub.IsMPF(payload) && !k {
+			s.DropTrailer(*zero)
+		}
+		replace := false
+		if marker == app1 && scrub.IsMotionPhoto(payload) && !k {
+			s.DropTrailer(*zero)
+			replace = motion != nil
+		}
+		if *zero {
+			return true // zeroEdit does the rest.
+		}
+		if jfif && marker != scrub.SOI && k {
+			jfif = false
+			if !(marker == app0 && scrub.IsJFIF(payload)) && !(marker == app1 && scrub.IsEXIF(payload)) {
+				if err := s.Insert(app0, scrub.MinimalJFIF()); err != nil {
+					fatal(exitError, err)
+				}
+			}
+		}
+		if placing && marker != scrub.SOI && !(k && marker == app0 && scrub.IsJFIF(payload)) {
+			placing = false
+			if *fake && marker == app1 && scrub.IsEXIF(payload) {
+				return true
+			}
+			exif := synthetic
+			if *fake {
+				exif = fakeEXIF(nil)
+			}
+			if err := s.Insert(app1, exif); err != nil {
+				fatal(exitError, err)
+			}
+		}
+		if replace {
+			if err := s.Insert(app1, scrub.MotionPhotoXMP(len(motion.scrubbed))); err != nil {
+				fatal(exitError, err)
+			}
+			motion.replaced = true
+		}
+		return k
+	}
+}
+
+// A motionVideo is the video of a motion photo, split from the image
+// for -motion=scrub.
+type motionVideo struct {
+	video    []byte // as read
+	scrubbed []byte // without its metadata
+	replaced bool   // whether the XMP data now locates the scrubbed video
+	after    int64  // bytes after the video, which are dropped
+}
+
+// edit returns the payload to write for a kept segment.
+func edit(marker byte, payload []byte) []byte {
+	switch {
+	case keepApp[app(marker)]:
+		// Untouched.
+	case marker == app0 && scrub.IsJFIF(payload) && !selective():
+		return scrub.JFIFHeader(payload)
+	case marker == app1 && scrub.IsEXIF(payload) && *fake:
+		return fakeEXIF(payload)
+	case marker == app1 && scrub.IsEXIF(payload):
+		exif, err := editEXIF(payload)
+		if err != nil {
+			warn("dropping EXIF: %v", err)
+			return nil
+		}
+		return exif
+	}
+	return payload
+}
+
+// zeroEdit returns the edit function for -zero, which applies keep
+// through p. Every segment is kept, but what the other flags would
+// remove is overwritten with zeros, and what they would shorten is
+// padded with zeros, so the segment keeps its length.
+func zeroEdit(p parts) func(marker byte, payload []byte) []byte {
+	return func(marker byte, payload []byte) []byte {
+		z := make([]byte, len(payload))
+		if p.keep(marker, payload) {
+			if e := edit(marker, payload); len(e) <= len(z) {
+				copy(z, e)
+			}
+		}
+		return z
+	}
+}
+
+// editEXIF returns the EXIF payload as the flags would have it.
+func editEXIF(payload []byte) ([]byte, error) {
+	if !selective() {
+		return scrub.ReduceEXIF(payload, keepTag)
+	}
+	var err error
+	if *gpsOnly || only["makernote"] || len(removeTags) > 0 {
+		payload, err = scrub.RemoveEXIF(payload, removeTag)
+	}
+	if err == nil && only["thumbnail"] {
+		payload, err = scrub.RemoveThumbnail(payload)
+	}
+	return payload, err
+}
+
+// keepsEXIF reports whether the flags keep any of the existing EXIF data.
+func keepsEXIF() bool {
+	return keepsTags() || keepApp[1] || selective() && !removeApp[1]
+}
+
+// only holds the kinds of metadata named by the -only flag.
+var only = make(map[string]bool)
+
+// onlyKinds lists the kinds of metadata that -only accepts.
+var onlyKinds = []string{"com", "xmp", "iptc", "mpf", "thumbnail", "makernote"}
+
+func parseOnly(list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, k := range strings.Split(list, ",") {
+		if !contains(onlyKinds, k) {
+			return fmt.Errorf("unknown kind %q for -only; known kinds are %s", k, strings.Join(onlyKinds, ","))
+		}
+		only[k] = true
+	}
+	return nil
+}
+
+func contains(list []string, s string) bool {
+	for _, t := range list {
+		if t == s {
+			return true
+		}
+	}
+	return false
+}
+
+// removeApp and keepApp hold the APPn numbers named by -app and -keep-app.
+var (
+	removeApp = make(map[int]bool)
+	keepApp   = make(map[int]bool)
+)
+
+func parseApps(apps map[int]bool, list string) error {
+	if list == "" {
+		return nil
+	}
+	for _, s := range strings.Split(list, ",") {
+		n, err := strconv.Atoi(s)
+		if err != nil || n < 0 || 15 < n {
+			return fmt.Errorf("bad APPn number %q", s)
+		}
+		apps[n] = true
+	}
+	return nil
+}
+
+// app returns the number n of an APPn marker, or -1 if the
+// marker is not an APPn marker.
+func app(marker byte) int {
+	if scrub.APPn <= marker && marker < scrub.JPGn {
+		return int(marker - scrub.APPn)
+	}
+	return -1
+}
+
+// tooBig reports whether the segment is an APPn or comment segment
+// larger than -max-app-size allows.
+func tooBig(marker byte, payload []byte) bool {
+	return *maxAppSize > 0 && (app(marker) >= 0 || marker == scrub.COM) && len(payload) > *maxAppSize
+}
+
+// selective reports whether the flags select particular metadata to remove,
+// leaving the rest untouched.
+func selective() bool {
+	return *gpsOnly || len(only) > 0 || len(removeApp) > 0 || len(removeTags) > 0 ||
+		*maxAppSize > 0
+}
+
+// kind returns the name, as used by -only, of the kind of metadata
+// in the segment.
+func kind(marker byte, payload []byte) string {
+	switch {
+	case marker == scrub.COM:
+		return "com"
+	case marker == app1 && (scrub.IsXMP(payload) || scrub.IsExtendedXMP(payload)):
+		return "xmp"
+	case marker == app13 && scrub.IsPhotoshop(payload):
+		return "iptc"
+	case marker == app2 && scrub.IsMPF(payload):
+		return "mpf"
+	}
+	return ""
+}
+
+// keepsTags reports whether the flags keep any EXIF tags.
+func keepsTags() bool {
+	return *keepOrientation || *keepAttribution || *keepDate || len(keepTags) > 0
+}
+
+// keepTag reports whether to keep the EXIF tag when reducing the EXIF data.
+func keepTag(tag scrub.Tag) bool {
+	return *keepOrientation && tag == scrub.Orientation ||
+		*keepAttribution && attribution.match(tag) ||
+		*keepDate && dates.match(tag) ||
+		keepTags.match(tag)
+}
+
+// attribution lists the tags kept by -keep-attribution.
+var attribution = tagPatterns{"Artist", "Copyright"}
+
+// dates lists the tags kept by -keep-date.
+var dates = tagPatterns{"DateTimeOriginal", "CreateDate"}
+
+// removeTag reports whether to remove the EXIF tag when editing
+// the EXIF data in place.
+func removeTag(tag scrub.Tag) bool {
+	return *gpsOnly && tag == scrub.GPSInfo ||
+		only["makernote"] && tag == scrub.MakerNote ||
+		removeTags.match(tag)
+}
+
+// A tagPatterns is a list of EXIF tag names, set from a flag of the form
+// tags=name,... The names may contain wildcards, as in GPS*.
+type tagPatterns []string
+
+func (p *tagPatterns) String() string {
+	return "tags=" + strings.Join(*p, ",")
+}
+
+func (p *tagPatterns) Set(s string) error {
+	if !strings.HasPrefix(s, "tags=") {
+		return fmt.Errorf("expected tags=name,...")
+	}
+	for _, name := range strings.Split(strings.TrimPrefix(s, "tags="), ",") {
+		if _, err := path.Match(name, ""); err != nil {
+			return fmt.Errorf("bad tag pattern %q", name)
+		}
+		if _, ok := scrub.LookupTag(name); !ok && !strings.ContainsAny(name, "*?[") {
+			return fmt.Errorf("unknown EXIF tag %q", name)
+		}
+		*p = append(*p, name)
+	}
+	return nil
+}
+
+// match reports whether the tag's name matches one of the patterns.
+func (p tagPatterns) match(tag scrub.Tag) bool {
+	name := tag.String()
+	for _, pat := range p {
+		if ok, _ := path.Match(pat, name); ok {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,303 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"strings"
+	"testing"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// setPolicy sets the flags, given as name=value, as main would, and
+// returns a function that restores their defaults.
+func setPolicy(t *testing.T, settings ...string) func() {
+	reset := func() {
+		for _, s := range settings {
+			if f := flag.Lookup(strings.SplitN(s, "=", 2)[0]); f != nil {
+				f.Value.Set(f.DefValue)
+			}
+		}
+		keepTags, removeTags = nil, nil
+		only = make(map[string]bool)
+		removeApp, keepApp = make(map[int]bool), make(map[int]bool)
+	}
+	for _, s := range settings {
+		nv := strings.SplitN(s, "=", 2)
+		var err error
+		switch nv[0] {
+		case "keep":
+			err = keepTags.Set(nv[1])
+		case "remove":
+			err = removeTags.Set(nv[1])
+		default:
+			err = flag.Set(nv[0], nv[1])
+		}
+		if err != nil {
+			reset()
+			t.Fatal(err)
+		}
+	}
+	if err := parseOnly(*onlyFlag); err != nil {
+		reset()
+		t.Fatal(err)
+	}
+	if err := parseApps(removeApp, *appFlag); err != nil {
+		reset()
+		t.Fatal(err)
+	}
+	if err := parseApps(keepApp, *keepAppFlag); err != nil {
+		reset()
+		t.Fatal(err)
+	}
+	return reset
+}
+
+// An exifField is a field of a directory built by exifDir.
+type exifField struct {
+	tag, typ, count int
+	value           string // If longer than 4 bytes, stored after the directory.
+}
+
+func le16(v int) string { return string([]byte{byte(v), byte(v >> 8)}) }
+func le32(v int) string { return le16(v) + le16(v>>16) }
+
+// exifDir returns a little-endian directory to be stored at off, whose
+// successor is at next.
+func exifDir(off, next int, fields ...exifField) string {
+	dir := le16(len(fields))
+	data := off + 2 + 12*len(fields) + 4
+	var extra string
+	for _, f := range fields {
+		v := f.value
+		if len(v) > 4 {
+			extra += v
+			v = le32(data)
+			data += len(f.value)
+		}
+		v += strings.Repeat("\x00", 4-len(v))
+		dir += le16(f.tag) + le16(f.typ) + le32(f.count) + v
+	}
+	return dir + le32(next) + extra
+}
+
+// exifPayload returns the payload of an EXIF segment holding an
+// orientation, an artist, a capture date, a maker note, a location,
+// and a thumbnail.
+func exifPayload() []byte {
+	ifd0 := func(off, exif, gps, ifd1 int) string {
+		return exifDir(off, ifd1,
+			exifField{0x0112, 3, 1, le16(6)},      // Orientation.
+			exifField{0x013B, 2, 7, "secret\x00"}, // Artist.
+			exifField{0x8769, 4, 1, le32(exif)},   // ExifIFD.
+			exifField{0x8825, 4, 1, le32(gps)})    // GPSInfo.
+	}
+	exif := func(off int) string {
+		return exifDir(off, 0,
+			exifField{0x9003, 2, 20, "2020:01:02 03:04:05\x00"}, // DateTimeOriginal.
+			exifField{0x927C, 7, 6, "secret"})                   // MakerNote.
+	}
+	gps := func(off int) string {
+		return exifDir(off, 0, exifField{0x0001, 2, 2, "N\x00"}) // GPSLatitudeRef.
+	}
+	ifd1 := func(off, thumb int) string {
+		return exifDir(off, 0,
+			exifField{0x0201, 4, 1, le32(thumb)}, // ThumbnailOffset.
+			exifField{0x0202, 4, 1, le32(4)})     // ThumbnailLength.
+	}
+	exifOff := 8 + len(ifd0(0, 0, 0, 0))
+	gpsOff := exifOff + len(exif(0))
+	ifd1Off := gpsOff + len(gps(0))
+	thumb := ifd1Off + len(ifd1(0, 0))
+	return []byte("Exif\x00\x00II*\x00" + le32(8) +
+		ifd0(8, exifOff, gpsOff, ifd1Off) + exif(exifOff) + gps(gpsOff) + ifd1(ifd1Off, thumb) +
+		"\xFF\xD8\xFF\xD9")
+}
+
+// tagNames returns the names of the tags in the EXIF payload.
+func tagNames(payload []byte) (string, error) {
+	fields, err := scrub.DecodeEXIF(payload)
+	var names []string
+	for _, f := range fields {
+		names = append(names, f.Tag.String())
+	}
+	return strings.Join(names, " "), err
+}
+
+func TestEXIFPolicy(t *testing.T) {
+	const all = "Orientation Artist DateTimeOriginal MakerNote GPSLatitudeRef ThumbnailOffset ThumbnailLength"
+	payload := exifPayload()
+	if names, err := tagNames(payload); err != nil || names != all {
+		t.Fatalf("bad EXIF data: %s (error %v)", names, err)
+	}
+	tests := []struct {
+		settings []string
+		tags     string // Those kept; empty if the segment is removed.
+	}{
+		{nil, ""},
+		{[]string{"keep-orientation=true"}, "Orientation"},
+		{[]string{"keep=tags=Artist"}, "Artist"},
+		{[]string{"keep=tags=Date*,Orientation"}, "Orientation DateTimeOriginal"},
+		{[]string{"keep-attribution=true"}, "Artist"},
+		{[]string{"keep-date=true"}, "DateTimeOriginal"},
+		{[]string{"gps-only=true"}, "Orientation Artist DateTimeOriginal MakerNote ThumbnailOffset ThumbnailLength"},
+		{[]string{"remove=tags=Artist"}, "Orientation DateTimeOriginal MakerNote GPSLatitudeRef ThumbnailOffset ThumbnailLength"},
+		{[]string{"only=makernote"}, "Orientation Artist DateTimeOriginal GPSLatitudeRef ThumbnailOffset ThumbnailLength"},
+		{[]string{"only=thumbnail"}, "Orientation Artist DateTimeOriginal MakerNote GPSLatitudeRef"},
+		{[]string{"remove=tags=GPS*"}, "Orientation Artist DateTimeOriginal MakerNote ThumbnailOffset ThumbnailLength"},
+	}
+	for _, test := range tests {
+		reset := setPolicy(t, test.settings...)
+		var tags string
+		if keep(app1, payload) {
+			exif, err := editEXIF(payload)
+			if err != nil {
+				t.Errorf("%q: %v", test.settings, err)
+			}
+			tags, err = tagNames(exif)
+			if err != nil {
+				t.Errorf("%q: %v", test.settings, err)
+			}
+		}
+		if tags != test.tags {
+			t.Errorf("%q: kept %q; want %q", test.settings, tags, test.tags)
+		}
+		reset()
+	}
+}
+
+func TestSegmentPolicy(t *testing.T) {
+	segments := []struct {
+		name    string
+		marker  byte
+		payload string
+	}{
+		{"JFIF", app0, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00"},
+		{"EXIF", app1, string(exifPayload())},
+		{"XMP", app1, "http://ns.adobe.com/xap/1.0/\x00<x:xmpmeta/>"},
+		{"ICC", app2, "ICC_PROFILE\x00\x01\x01profile"},
+		{"MPF", app2, "MPF\x00II*\x00"},
+		{"APP5", scrub.APPn + 5, "data"},
+		{"IPTC", app13, "Photoshop 3.0\x008BIM"},
+		{"Adobe", app14, "Adobe\x00\x64\x00\x00\x00\x00\x01"}, // YCbCr.
+		{"COM", scrub.COM, "comment"},
+	}
+	tests := []struct {
+		settings []string
+		kept     string
+	}{
+		{nil, ""},
+		{[]string{"keep-jfif=true"}, "JFIF"},
+		{[]string{"keep-icc=true"}, "ICC"},
+		{[]string{"adobe=keep"}, "Adobe"},
+		{[]string{"only=com"}, "JFIF EXIF XMP ICC MPF APP5 IPTC Adobe"},
+		{[]string{"only=xmp"}, "JFIF EXIF ICC MPF APP5 IPTC Adobe COM"},
+		{[]string{"only=iptc"}, "JFIF EXIF XMP ICC MPF APP5 Adobe COM"},
+		{[]string{"only=mpf"}, "JFIF EXIF XMP ICC APP5 IPTC Adobe COM"},
+		{[]string{"only=com,xmp"}, "JFIF EXIF ICC MPF APP5 IPTC Adobe"},
+		{[]string{"app=1,13"}, "JFIF ICC MPF APP5 Adobe COM"},
+		{[]string{"keep-app=1,5"}, "EXIF XMP APP5"},
+		{[]string{"max-app-size=24"}, "JFIF ICC MPF APP5 IPTC Adobe COM"},
+	}
+	for _, test := range tests {
+		reset := setPolicy(t, test.settings...)
+		var kept []string
+		for _, seg := range segments {
+			if keep(seg.marker, []byte(seg.payload)) {
+				kept = append(kept, seg.name)
+			}
+		}
+		if k := strings.Join(kept, " "); k != test.kept {
+			t.Errorf("%q: kept %q; want %q", test.settings, k, test.kept)
+		}
+		reset()
+	}
+}
+
+func TestSyntheticEXIF(t *testing.T) {
+	reset := setPolicy(t, "synthetic-exif=true", "keep-jfif=true")
+	defer reset()
+	jfif := segment(app0, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	synthetic := segment(app1, string(scrub.SyntheticEXIF(1, 1, "scrub")))
+	tests := []struct {
+		name, in, out string
+	}{
+		{"EXIF", jpegFile(segment(app1, string(exifPayload()))), jpegFile(synthetic)},
+		{"none", jpegFile(), jpegFile(synthetic)},
+		{"JFIF", jpegFile(jfif, segment(scrub.COM, "secret")), jpegFile(jfif, synthetic)},
+	}
+	for _, test := range tests {
+		out, _, _, err := cleanString(t, test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("%s: got %q; want %q", test.name, out, test.out)
+		}
+	}
+}
+
+// exifSegments returns the payloads of the EXIF segments in the JPEG data.
+func exifSegments(data string) ([][]byte, error) {
+	var exif [][]byte
+	s := scrub.NewScanner(strings.NewReader(data), ioutil.Discard)
+	s.Filter(func(marker byte, payload []byte) bool {
+		if marker == app1 && scrub.IsEXIF(payload) {
+			exif = append(exif, append([]byte(nil), payload...))
+		}
+		return true
+	})
+	for s.Scan() {
+	}
+	return exif, s.Err()
+}
+
+func TestFakeEXIF(t *testing.T) {
+	reset := setPolicy(t, "fake=true")
+	defer reset()
+	tests := []struct {
+		name        string
+		in          string
+		orientation string
+	}{
+		{"EXIF", jpegFile(segment(app1, string(exifPayload()))), "Orientation=[6]"},
+		{"none", jpegFile(), ""},
+	}
+	for _, test := range tests {
+		out, _, changed, err := cleanString(t, test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if !changed || strings.Contains(out, "secret") {
+			t.Errorf("%s: metadata left in output %q", test.name, out)
+		}
+		exif, err := exifSegments(out)
+		if err != nil || len(exif) != 1 {
+			t.Errorf("%s: found %d EXIF segments (error %v); want 1", test.name, len(exif), err)
+			continue
+		}
+		fields, err := scrub.DecodeEXIF(exif[0])
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+		}
+		var orientation string
+		for _, f := range fields {
+			if f.Tag == scrub.Orientation {
+				orientation = fmt.Sprintf("Orientation=%v", f.Value)
+			}
+			if f.Tag.IFD == scrub.GPSIFD {
+				t.Errorf("%s: decoy holds %s", test.name, f.Tag)
+			}
+		}
+		if orientation != test.orientation {
+			t.Errorf("%s: got %q; want %q", test.name, orientation, test.orientation)
+		}
+	}
+}
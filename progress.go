@@ -0,0 +1,110 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"log"
+	"os"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// prog, set by -progress, reports progress through the files.
+var prog *progress
+
+// A progress counts the files done and the bytes in them, and reports
+// them periodically on standard error. Its methods may be called
+// concurrently.
+type progress struct {
+	files int64 // Files done; accessed atomically.
+	bytes int64 // Bytes in them; accessed atomically.
+	total int
+	size  int64 // Bytes in all the files, or 0 if unknown.
+	tty   bool  // Whether standard error is a terminal, to draw a bar on.
+	start time.Time
+	quit  chan bool
+	done  chan bool
+}
+
+// startProgress starts reporting progress through total files, holding
+// size bytes in all, every interval.
+func startProgress(total int, size int64, interval time.Duration) *progress {
+	p := &progress{
+		total: total,
+		size:  size,
+		start: time.Now(),
+		quit:  make(chan bool),
+		done:  make(chan bool),
+	}
+	if info, err := os.Stderr.Stat(); err == nil && info.Mode()&os.ModeCharDevice != 0 {
+		p.tty = true
+	}
+	go func() {
+		t := time.NewTicker(interval)
+		defer t.Stop()
+		for {
+			select {
+			case <-t.C:
+				p.report()
+			case <-p.quit:
+				p.report()
+				if p.tty {
+					fmt.Fprintln(os.Stderr)
+				}
+				close(p.done)
+				return
+			}
+		}
+	}()
+	return p
+}
+
+// fileDone records that a file holding size bytes has been dealt with.
+// Files are counted whatever was done with them, so the count reaches
+// the total even if some are skipped or fail.
+func (p *progress) fileDone(size int64) {
+	atomic.AddInt64(&p.files, 1)
+	atomic.AddInt64(&p.bytes, size)
+}
+
+// stop stops the reports, after a final one.
+func (p *progress) stop() {
+	close(p.quit)
+	<-p.done
+}
+
+// barWidth is the width of the bar drawn on a terminal.
+const barWidth = 30
+
+func (p *progress) report() {
+	files := atomic.LoadInt64(&p.files)
+	bytes := atomic.LoadInt64(&p.bytes)
+	elapsed := time.Since(p.start)
+	mb := float64(bytes) / 1e6
+	msg := fmt.Sprintf("%d/%d files, %.1f MB, %.1f MB/s", files, p.total, mb, mb/elapsed.Seconds())
+	// The fraction done is measured in bytes if the size is known,
+	// as files vary greatly in size.
+	done := float64(files) / float64(p.total)
+	if p.size > 0 {
+		done = float64(bytes) / float64(p.size)
+		msg = fmt.Sprintf("%d/%d files, %.1f/%.1f MB, %.1f MB/s", files, p.total, mb, float64(p.size)/1e6, mb/elapsed.Seconds())
+	}
+	if done > 1 {
+		done = 1
+	}
+	if done > 0 && done < 1 {
+		left := time.Duration(float64(elapsed) * (1 - done) / done)
+		msg += fmt.Sprintf(", %s left", left.Round(time.Second))
+	}
+	if !p.tty {
+		log.Print(msg)
+		return
+	}
+	n := int(done * barWidth)
+	bar := strings.Repeat("=", n) + strings.Repeat(" ", barWidth-n)
+	fmt.Fprintf(os.Stderr, "\r[%s] %s\x1b[K", bar, msg)
+}
@@ -5,175 +5,1063 @@
 // Scrub reads a JPG file and copies it to standard output
 // after deleting any App, JPEG, or comment segment. That is,
 // it scrubs all metadata from the input and writes the result
-// to standard output.
+// to standard output. When it removes a Multi-Picture Format index,
+// as written by many phones, it also removes the secondary images the
+// index locates after the end of the main one, and when it removes the
+// XMP data marking a motion photo, the video that follows the image.
+// Images concatenated in one file, as the frames of an MJPEG file are,
+// are scrubbed one after another. Each is written to standard output
+// as soon as it is done, so scrub can sit in a pipeline scrubbing the
+// endless MJPEG stream of a camera. (The -synthetic-exif flag and
+// -motion=scrub read the whole input first, so do not suit a stream.)
+//
+// Scrub also handles files in other formats, recognized by their
+// contents rather than their names, and removes all their metadata.
+// The flags that select what to keep, and -verify, apply only to JPEGs.
+// A file in a format it does not recognize is an error, and one whose
+// name suggests a format other than that of its contents, such as a PNG
+// image named photo.jpg, is noted. The other formats are:
+//
+//	PNG	text, EXIF, and time chunks
+//	TIFF	EXIF, GPS, XMP, IPTC, and descriptive tags such as Artist,
+//		from every page
+//	DNG	as for TIFF, and serial numbers, maker data, and previews
+//	WebP	EXIF and XMP chunks
+//	HEIF	EXIF and XMP items, as in the HEIC files written by phones
+//	AVIF	EXIF and XMP items
+//	GIF	comments and application extensions other than looping
+//	EXR	header attributes not needed to read the pixels
+//	PDF	the Info dictionary, XMP streams, attachment dates, and
+//		old versions of edited objects
+//	MP4	udta, meta, and XMP boxes, and free space, in MP4 and
+//		QuickTime movies
+//	JXL	Exif, XMP, and JUMBF boxes, and JPEG reconstruction data
+//	PSD	EXIF, XMP, IPTC, thumbnail, slice, and URL resources
+//	SVG	comments, metadata and RDF elements, and Inkscape and
+//		Sodipodi elements and attributes
+//	MP3	ID3 and APE tags
+//	FLAC	comments, pictures, and padding
+//	Ogg	comments in Vorbis and Opus streams
+//	WAV	LIST INFO, bext (Broadcast Wave), iXML, ID3, and XMP chunks
+//	AIFF	name, author, annotation, copyright, comment, and ID3 chunks
+//	Office	document properties in Word, Excel, and PowerPoint files,
+//		and the metadata of the images in them
+//	EPUB	metadata other than the title, identifier, language, date
+//		of modification, and cover, and the metadata of the images
+//	MKV	tags, the title, date, and file names, and Void elements, in
+//		Matroska and WebM files
+//	ICO	the metadata of the PNG images in icons and cursors
+//	ZIP	modification times and comments, and the metadata of the
+//		images in the archive
+//	tar	owners and modification times, and the metadata of the
+//		images in the archive, which may be compressed by gzip
+//
+// Usage:
+//
+//	scrub [flags] [file ...]
+//	scrub -diff a.jpg b.jpg
+//
+// With no file, scrub reads standard input. Given several files, it
+// processes each in turn, continuing past any it cannot, skipping with
+// a note any in a format it does not recognize or, for the flags that
+// examine only JPEG files, that are not JPEGs, and ends by summarizing
+// the run and listing the files that failed. Since the results cannot
+// all go to standard output, scrubbing several files requires -i, -d,
+// or -suffix. Arguments holding the wildcards *, ?, and [ are expanded
+// as by the shell, for systems such as Windows whose shells do not;
+// one naming an existing file is taken literally.
+//
+// The flags are:
+//
+//	-i
+//		Overwrite the input file in place, keeping its permissions
+//		and, where the system allows, its owner and group. The output
+//		is written to a temporary file that then replaces the input,
+//		so a crash leaves either the original or the scrubbed file.
+//	-o file
+//		Write the output to the file rather than standard output.
+//	-d dir
+//		Write the output for each input file to a file of the same
+//		name in the directory, which is created if need be, leaving
+//		the input as it was.
+//	-suffix suffix
+//		Write the output for each input file to a file whose name adds
+//		the suffix before the extension, as photo_clean.jpg for
+//		photo.jpg with -suffix _clean, in the same directory as the
+//		input or, with -d, in that directory.
+//	-watch dir
+//		Watch the directory, and the tree beneath it, until interrupted,
+//		scrubbing in place each file in it and each file added or
+//		changed later, once it has stopped changing, so that an uploads
+//		folder, say, stays free of metadata. The directory is examined
+//		every second. Files skipped by -exclude are left alone.
+//	-files file
+//		Process too the files named in the file, one per line, or, if
+//		the file is -, on standard input. The names are taken literally,
+//		without expanding wildcards.
+//	-0
+//		With -files, the names are separated by NUL bytes rather than
+//		newlines, as written by find -print0, so they may hold any
+//		character:
+//			find . -name '*.jpg' -print0 | scrub -i -0 -files -
+//	-exclude patterns
+//		Skip the files, whether named as arguments or with -files, whose
+//		names match any of the comma-separated patterns. A pattern may
+//		hold the wildcards of a shell and also **, which matches any
+//		number of directories. Without a slash, a pattern matches the
+//		last element of a name, so *.tmp.jpg skips every such file; with
+//		one, it matches the name or its trailing part, so thumbnails/**
+//		skips everything in any directory named thumbnails. The flag
+//		may be repeated.
+//	-symlinks=follow
+//		What to do with a file named by a symbolic link: follow, the
+//		default, scrubs the file the link refers to, which with -i is
+//		overwritten even if it lies outside the tree being scrubbed;
+//		skip skips it with a note; and replace, with -i, writes the
+//		scrubbed file in place of the link, leaving the file it
+//		refers to as it was.
+//	-j n
+//		Process n files at once, which is faster for many files on
+//		a machine with several processors. Each file being processed
+//		is held in memory. It applies only with -i, -d, -suffix, and
+//		-check, since the output of the others would be interleaved.
+//	-only-dirty
+//		With -i, -o, -d, or -suffix, write only the files that
+//		scrubbing changes, leaving those already clean untouched, so
+//		their modification times are kept and no copies made of them.
+//	-keep-mtime
+//		Give each file written by -i, -o, -d, or -suffix the
+//		modification time of its input, so programs that sort photos
+//		by the dates of their files are not disturbed.
+//	-verify
+//		Check that scrubbing changed only the metadata, by comparing
+//		hashes of the image data of the input and output. If they
+//		differ, or either cannot be hashed, the file is treated as an
+//		error and, with -i, left as it was.
+//	-validate=header
+//		Check that the output of scrubbing still decodes, as read by
+//		Go's image package, before writing it with -i, -o, -d, or
+//		-suffix; if not, the file is treated as an error and left as it
+//		was. Only JPEG, PNG, and GIF files are checked, and only their
+//		headers unless the value is full, which decodes the whole image.
+//		A file that did not decode before scrubbing is not held against
+//		it.
+//	-progress
+//		Report progress every second on standard error: the number of
+//		files done, whether scrubbed, skipped, or failed, the data they
+//		hold, the rate at which it is done, and an estimate of the time
+//		left. On a terminal, the report is a bar
+//		redrawn in place.
+//	-q
+//		Quiet: report only errors, not warnings or summaries.
+//	-v
+//		Report each segment removed, rewritten, even in place, or added,
+//		or for other formats each chunk, tag, or other item removed or
+//		rewritten, and the total number of bytes removed, on standard
+//		error.
+//	-check
+//		Write nothing, but exit with status 1 if the file holds metadata
+//		that would be removed, and 0 if it is already clean.
+//	-n
+//		Write nothing, but report each file that would be changed and
+//		how many bytes would be removed, to preview a run: the same
+//		command line with -n added does everything but write. It is
+//		like -check, but may be combined with -i, -d, -suffix, and -o.
+//	-log file
+//		Append to the file a record of each file scrubbed: the time,
+//		the file name, the segments or other items removed or edited,
+//		and the sizes and SHA-256 hashes of the input and output. Each
+//		record is a line of JSON.
+//	-cache file
+//		Skip the files whose SHA-256 hashes are recorded in the file,
+//		and record there the hashes of each file scrubbed as read and
+//		as written, so that later runs over the same inputs skip them,
+//		whether they were scrubbed in place or to other files. The
+//		file is created if need be. It applies to the flags that write
+//		files.
+//	-detect
+//		Write nothing, but report on standard output each piece of
+//		privacy-sensitive metadata in the file, one per line: the
+//		location, serial numbers, owner names, and thumbnails. The exit
+//		status is 1 if anything is found.
+//	-table format
+//		Write nothing, but print a table with a row for each file,
+//		giving its name, whether it has EXIF, GPS, and XMP data, and
+//		the number of bytes that scrubbing would remove. The format
+//		is csv or tsv. Files that are not JPEGs are skipped.
+//	-list
+//		Rather than scrubbing, print a table of the segments in the
+//		file, giving the offset, marker, length, and contents of each,
+//		to see what metadata it holds.
+//	-sizes
+//		Rather than scrubbing, print how many bytes of the file each
+//		kind of metadata occupies, to see where the bytes go.
+//	-exif
+//		Rather than scrubbing, print the EXIF tags in the file in
+//		human-readable form, with the location in decimal degrees.
+//	-diff
+//		Compare the two files named as arguments and report how their
+//		image data and metadata differ, for instance to verify that
+//		scrubbing changed only the metadata. As with diff, the exit
+//		status is 0 if they are the same, 1 if they differ, and 2 if
+//		there is trouble.
+//	-json
+//		Rather than scrubbing, print a JSON description of the metadata
+//		segments in the file, with the EXIF, XMP, and IPTC data decoded
+//		where possible.
+//	-keep-orientation
+//		Keep the EXIF Orientation tag, in a minimal EXIF segment,
+//		so the image still displays the right way up.
+//	-keep tags=name,...
+//		Keep the named EXIF tags, in a minimal EXIF segment.
+//		Names are those used by ExifTool, such as Orientation,
+//		DateTimeOriginal, and Copyright, which for a few tags differ
+//		from the standard's: CreateDate for DateTimeDigitized and
+//		ModifyDate for DateTime. They may contain wildcards as in GPS*.
+//	-keep-attribution
+//		Keep the EXIF Artist and Copyright tags, in a minimal EXIF
+//		segment, so published images remain attributed.
+//	-keep-date
+//		Keep the EXIF DateTimeOriginal and CreateDate tags, in a minimal
+//		EXIF segment, so the capture date survives.
+//	-keep-icc
+//		Keep the ICC color profile.
+//	-keep-jfif
+//		Keep the JFIF APP0 header, without its thumbnail.
+//	-gps-only
+//		Remove only the location: delete the GPS directory from the
+//		EXIF data and leave all other metadata untouched.
+//	-remove tags=name,...
+//		Remove only the named EXIF tags, such as SerialNumber or GPS*,
+//		and leave the rest of the metadata untouched. Removing a tag
+//		that points to another directory, such as GPSInfo, removes the
+//		whole directory.
+//	-only kind,...
+//		Remove only the listed kinds of metadata and leave the rest
+//		untouched. The kinds are:
+//			com	comment segments
+//			xmp	XMP packets, including extended XMP
+//			iptc	Photoshop APP13 segments, which hold the IPTC data
+//			mpf	the multi-picture index and the images it locates
+//			thumbnail	the thumbnail image in the EXIF data
+//			makernote	the maker note, the camera's private EXIF data
+//	-app n,...
+//		Remove only the listed APPn segments, such as 1 for EXIF and XMP
+//		and 13 for IPTC, and leave the rest untouched.
+//	-max-app-size n
+//		Remove only the APPn and comment segments whose payload is
+//		larger than n bytes, such as big embedded previews, and leave
+//		the small ones untouched. An ICC profile split across several
+//		segments, or an XMP packet and the extended XMP data that
+//		continues it, is kept or removed whole, as its first segment
+//		is.
+//	-keep-app n,...
+//		Keep the listed APPn segments intact while scrubbing the rest.
+//	-add-jfif
+//		If the output would have neither a JFIF header nor EXIF data,
+//		add a minimal JFIF header, as some strict decoders and old
+//		programs expect one or the other. It cannot be combined with
+//		-zero.
+//	-synthetic-exif
+//		After scrubbing, add a minimal EXIF segment recording only the
+//		image dimensions and a neutral software name, for consumers
+//		that reject images with no metadata at all. It cannot be combined
+//		with flags that keep existing EXIF data.
+//	-fake
+//		Replace the EXIF data with plausible decoy values: a common
+//		camera, random exposure settings, and a capture time within a
+//		month of the original, with no location. The output then has
+//		EXIF data even if the input had none, so it does not stand out.
+//		Like -synthetic-exif, it cannot be combined with flags that keep
+//		existing EXIF data.
+//	-zero
+//		Rather than deleting metadata, overwrite it with zero bytes,
+//		leaving every segment in place, so the output has the same
+//		length and layout as the input. It cannot be combined with
+//		flags that add EXIF data.
+//	-force
+//		Recover what can be from a damaged file rather than giving up:
+//		skip garbage between segments, up to the next marker, drop
+//		segments whose lengths are impossible, and end the file at a
+//		segment whose length field is cut short. Each repair is
+//		reported. Damage within the image data cannot be detected, so
+//		the result may still not display properly.
+//	-strict
+//		Treat as an error any departure from the standard in the
+//		structure of a file, even one that scrub can work around, such
+//		as zero bytes between segments, a segment whose length runs
+//		past the end of the file, or a missing EOI marker. It suits
+//		checking an archive, with -check; by default such oddities are
+//		reported and the file is processed anyway. It cannot be combined
+//		with -force or -add-eoi.
+//	-add-eoi
+//		Add an EOI marker to the end of a file that lacks one, as files
+//		cut short often do. The missing marker is reported either way.
+//	-adobe=auto
+//		What to do with the Adobe APP14 segment, which tells decoders
+//		how the colors were transformed: keep, drop, or auto. By default
+//		(auto), it is kept unless the transform is the standard YCbCr one,
+//		since without it CMYK and YCCK images can display with inverted
+//		or otherwise wrong colors.
+//	-motion=strip
+//		What to do with the video of a motion photo, which phones store
+//		after the image, when the XMP data that marks it is removed:
+//		strip, the default, removes the video; scrub keeps it, with its
+//		metadata removed, and replaces the XMP data with a minimal
+//		packet that locates it, so the photo still moves. Anything
+//		after the video, such as the rest of the trailer that Samsung's
+//		phones write, is removed, with a warning, since the new packet
+//		says the video ends the file. It cannot be combined with -zero.
+//
+// The exit status is 0 if nothing was changed, 1 if metadata was removed
+// or edited (or, with -check, -detect, and -table, found), 2 if an input
+// could not be parsed or the command line was bad, and 3 if there was an
+// I/O error.
+//
+// The work is done by package robpike.io/cmd/scrub/scrub,
+// which may be imported by other programs.
 package main // import "robpike.io/cmd/scrub"
 
 import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"errors"
 	"flag"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"robpike.io/cmd/scrub/scrub"
 )
 
-var iFlag = flag.Bool("i", false, "overwrite the input in place")
+var (
+	iFlag           = flag.Bool("i", false, "overwrite the input in place")
+	outFlag         = flag.String("o", "", "write the output to `file`")
+	dirFlag         = flag.String("d", "", "write the output for each input to a file of the same name in `dir`")
+	suffixFlag      = flag.String("suffix", "", "write the output for each input to a file whose name adds `suffix` before the extension")
+	watchFlag       = flag.String("watch", "", "scrub in place, until interrupted, each file added to or changed in `dir`")
+	filesFlag       = flag.String("files", "", "also process the files named, one per line, in `file`, or - for standard input")
+	nulFlag         = flag.Bool("0", false, "with -files, the names are separated by NUL bytes rather than newlines")
+	jobs            = flag.Int("j", 1, "process `n` files at once")
+	onlyDirty       = flag.Bool("only-dirty", false, "write only the files that scrubbing changes")
+	keepMtime       = flag.Bool("keep-mtime", false, "give each file written the modification time of its input")
+	validateFlag    = flag.String("validate", "", "check that the output still decodes, reading its `part`: header or full")
+	verify          = flag.Bool("verify", false, "check that the image data is unchanged")
+	progressFlag    = flag.Bool("progress", false, "report progress periodically")
+	quiet           = flag.Bool("q", false, "report only errors")
+	verbose         = flag.Bool("v", false, "report what is removed")
+	cacheFlag       = flag.String("cache", "", "skip the files whose hashes are recorded in `file`, and record those of files scrubbed")
+	logFlag         = flag.String("log", "", "append an audit record of each file scrubbed to `file`")
+	dryRun          = flag.Bool("n", false, "report the files that scrubbing would change, but write nothing")
+	check           = flag.Bool("check", false, "exit with status 1 if the input holds metadata to remove; write nothing")
+	detectFlag      = flag.Bool("detect", false, "report privacy-sensitive metadata in the input; write nothing")
+	tableFlag       = flag.String("table", "", "print a table of what each file holds in `format` csv or tsv; write nothing")
+	listFlag        = flag.Bool("list", false, "print the segments of the input instead of scrubbing it")
+	sizesFlag       = flag.Bool("sizes", false, "print the bytes used by each kind of metadata instead of scrubbing")
+	exifFlag        = flag.Bool("exif", false, "print the EXIF tags of the input instead of scrubbing it")
+	diffFlag        = flag.Bool("diff", false, "compare the metadata and image data of two files")
+	jsonFlag        = flag.Bool("json", false, "print the metadata of the input as JSON instead of scrubbing it")
+	keepOrientation = flag.Bool("keep-orientation", false, "keep the EXIF orientation tag")
+	keepAttribution = flag.Bool("keep-attribution", false, "keep the EXIF artist and copyright tags")
+	keepDate        = flag.Bool("keep-date", false, "keep the EXIF capture date tags")
+	keepICC         = flag.Bool("keep-icc", false, "keep the ICC color profile")
+	keepJFIF        = flag.Bool("keep-jfif", false, "keep the JFIF APP0 header")
+	gpsOnly         = flag.Bool("gps-only", false, "remove only the EXIF GPS data, keeping other metadata")
+	onlyFlag        = flag.String("only", "", "remove only the listed `kinds` of metadata: com, xmp, iptc, mpf, thumbnail, makernote")
+	appFlag         = flag.String("app", "", "remove only the APPn segments with the listed `numbers`")
+	maxAppSize      = flag.Int("max-app-size", 0, "remove only the APPn and comment segments larger than `n` bytes")
+	keepAppFlag     = flag.String("keep-app", "", "keep the APPn segments with the listed `numbers`")
+	addJFIF         = flag.Bool("add-jfif", false, "add a minimal JFIF header if the output would have no JFIF or EXIF header")
+	syntheticEXIF   = flag.Bool("synthetic-exif", false, "add a minimal synthetic EXIF segment")
+	fake            = flag.Bool("fake", false, "replace the EXIF data with decoy values")
+	zero            = flag.Bool("zero", false, "overwrite metadata with zeros, preserving the file layout")
+	keepTags        tagPatterns
+	excludes        pathPatterns
+	removeTags      tagPatterns
+	force           = flag.Bool("force", false, "recover what can be recovered from damaged files")
+	strict          = flag.Bool("strict", false, "treat any departure from the standard as an error")
+	addEOI          = flag.Bool("add-eoi", false, "add an EOI marker to files that end without one")
+	adobeFlag       = flag.String("adobe", "auto", "`policy` for the Adobe APP14 segment: keep, drop, or auto")
+	symlinksFlag    = flag.String("symlinks", "follow", "`policy` for files named by symbolic links: follow, skip, or replace")
+	motionFlag      = flag.String("motion", "strip", "`policy` for the video of a motion photo: strip or scrub")
+)
 
 func main() {
 	log.SetPrefix("scrub: ")
 	log.SetFlags(0)
+	flag.Var(&keepTags, "keep", "keep the listed EXIF `tags=name,...`")
+	flag.Var(&excludes, "exclude", "skip the files whose names match the `patterns`")
+	flag.Var(&removeTags, "remove", "remove only the listed EXIF `tags=name,...`")
 	flag.Usage = usage
 	flag.Parse()
-	switch len(flag.Args()) {
-	case 0:
-		if *iFlag {
-			log.Fatal("cannot overwrite standard input")
-		}
-		scrub(os.Stdin)
-	case 1:
-		file := flag.Arg(0)
-		f, err := os.Open(file)
-		ck(err)
-		scrub(f)
+	switch *adobeFlag {
+	case "auto", "keep", "drop":
 	default:
 		usage()
 	}
+	switch *symlinksFlag {
+	case "follow", "skip", "replace":
+	default:
+		usage()
+	}
+	switch *validateFlag {
+	case "", "header", "full":
+	default:
+		usage()
+	}
+	switch *motionFlag {
+	case "strip", "scrub":
+	default:
+		usage()
+	}
+	if *motionFlag == "scrub" && *zero {
+		fatal(exitError, "cannot combine -motion=scrub and -zero")
+	}
+	if err := parseOnly(*onlyFlag); err != nil {
+		fatal(exitError, err)
+	}
+	if err := parseApps(removeApp, *appFlag); err != nil {
+		fatal(exitError, err)
+	}
+	if err := parseApps(keepApp, *keepAppFlag); err != nil {
+		fatal(exitError, err)
+	}
+	if *quiet && *verbose {
+		fatal(exitError, "cannot combine -q and -v")
+	}
+	if *maxAppSize < 0 {
+		fatal(exitError, "negative -max-app-size")
+	}
+	if *strict && (*force || *addEOI) {
+		fatal(exitError, "-strict cannot be combined with -force or -add-eoi")
+	}
+	if *syntheticEXIF && *fake {
+		fatal(exitError, "cannot combine -synthetic-exif and -fake")
+	}
+	if *addJFIF && *zero {
+		fatal(exitError, "cannot combine -add-jfif and -zero")
+	}
+	if (*syntheticEXIF || *fake) && *zero {
+		fatal(exitError, "-zero cannot be combined with flags that add EXIF data")
+	}
+	if (*syntheticEXIF || *fake) && keepsEXIF() {
+		fatal(exitError, "cannot add new EXIF data while keeping existing EXIF data")
+	}
+	if modes := count(*listFlag, *sizesFlag, *exifFlag, *jsonFlag, *diffFlag, *check, *detectFlag, *tableFlag != "", *iFlag, *outFlag != "", *dirFlag != "" || *suffixFlag != "", *watchFlag != ""); modes > 1 {
+		fatal(exitError, "at most one of -i, -o, -d or -suffix, -watch, -check, -detect, -table, -list, -sizes, -exif, -json, and -diff may be set")
+	}
+	switch *tableFlag {
+	case "", "csv", "tsv":
+	default:
+		fatal(exitError, fmt.Sprintf("unknown table format %q; must be csv or tsv", *tableFlag))
+	}
+	if *diffFlag {
+		if flag.NArg() != 2 {
+			usage()
+		}
+		differ, err := diff(os.Stdout, flag.Arg(0), flag.Arg(1))
+		if err != nil {
+			log.Print(err)
+			os.Exit(2)
+		}
+		if differ {
+			os.Exit(1)
+		}
+		return
+	}
+	if *check && *zero {
+		fatal(exitError, "cannot combine -check and -zero")
+	}
+	if *logFlag != "" {
+		f, err := os.OpenFile(*logFlag, os.O_WRONLY|os.O_APPEND|os.O_CREATE, 0644)
+		if err != nil {
+			fatal(exitIO, err)
+		}
+		defer f.Close()
+		auditLog = f
+	}
+	if *validateFlag != "" && !*iFlag && *outFlag == "" && *dirFlag == "" && *suffixFlag == "" && *watchFlag == "" {
+		fatal(exitError, "-validate requires -i, -o, -d, -suffix, or -watch")
+	}
+	if *cacheFlag != "" {
+		if !*iFlag && *outFlag == "" && *dirFlag == "" && *suffixFlag == "" && *watchFlag == "" {
+			fatal(exitError, "-cache requires -i, -o, -d, -suffix, or -watch")
+		}
+		c, err := openCache(*cacheFlag)
+		if err != nil {
+			fatal(exitIO, err)
+		}
+		cache = c
+	}
+	args, err := expand(flag.Args())
+	if err != nil {
+		fatal(exitError, err)
+	}
+	if *nulFlag && *filesFlag == "" {
+		fatal(exitError, "-0 requires -files")
+	}
+	if *filesFlag != "" {
+		names, err := readNames(*filesFlag, *nulFlag)
+		if err != nil {
+			fatal(exitIO, err)
+		}
+		args = append(args, names...)
+	}
+	if len(excludes) > 0 {
+		var kept []string
+		for _, name := range args {
+			if !excludes.match(name) {
+				kept = append(kept, name)
+			}
+		}
+		args = kept
+	}
+	var process func(*os.File) (result, error)
+	switch {
+	case *listFlag:
+		process = listFile
+		jpegOnly = true
+	case *sizesFlag:
+		process = sizesFile
+		jpegOnly = true
+	case *exifFlag:
+		process = exifFile
+		jpegOnly = true
+	case *jsonFlag:
+		process = jsonFile
+		jpegOnly = true
+	case *check:
+		process = checkFile
+	case *detectFlag:
+		process = detectFile
+		jpegOnly = true
+	case *tableFlag != "":
+		table = newTable(*tableFlag)
+		process = tableFile
+		jpegOnly = true
+	default:
+		if len(args) > 1 && !*iFlag && *dirFlag == "" && *suffixFlag == "" {
+			fatal(exitError, "cannot write more than one file to one output; use -i, -d, or -suffix")
+		}
+		process = scrubFile
+		if *dryRun {
+			process = checkFile
+		}
+	}
+	if *watchFlag != "" {
+		if len(args) > 0 || *filesFlag != "" {
+			fatal(exitError, "-watch takes no files")
+		}
+		if *dryRun {
+			fatal(exitError, "cannot combine -n and -watch")
+		}
+		*iFlag = true
+	}
+	if *jobs < 1 {
+		fatal(exitError, "-j must be at least 1")
+	}
+	if *jobs > 1 && !*iFlag && *dirFlag == "" && *suffixFlag == "" && !*check && !*dryRun {
+		fatal(exitError, "-j requires -i, -d, -suffix, or -check, whose output is not interleaved")
+	}
+	if *keepMtime && !*iFlag && *outFlag == "" && *dirFlag == "" && *suffixFlag == "" {
+		fatal(exitError, "-keep-mtime requires -i, -o, -d, or -suffix")
+	}
+	if *dirFlag != "" && !*dryRun {
+		if err := os.MkdirAll(*dirFlag, 0777); err != nil {
+			fatal(exitIO, err)
+		}
+	}
+	if *watchFlag != "" {
+		watchDir(*watchFlag, time.Second)
+	}
+	if *progressFlag {
+		n := len(args)
+		if n == 0 {
+			n = 1 // Standard input.
+		}
+		var size int64
+		for _, name := range args {
+			if info, err := os.Stat(name); err == nil {
+				size += info.Size()
+			}
+		}
+		prog = startProgress(n, size, time.Second)
+	}
+	st := stats{batch: len(args) > 1 || *filesFlag != ""}
+	if flag.NArg() == 0 && *filesFlag == "" {
+		if *iFlag {
+			fatal(exitError, "cannot overwrite standard input")
+		}
+		if *dirFlag != "" || *suffixFlag != "" {
+			fatal(exitError, "cannot name the output for standard input; use -o")
+		}
+		st.run("", process)
+	}
+	st.runAll(args, *jobs, process)
+	if prog != nil {
+		prog.stop()
+	}
+	if st.files > 1 {
+		st.summarize()
+	}
+	os.Exit(st.status())
 }
 
-func usage() {
-	fmt.Fprintf(os.Stderr, "usage: scrub [[-i] file]\n")
-	os.Exit(2)
-}
-
+// Exit statuses. The -diff flag follows diff's conventions instead.
 const (
-	/* Constants all preceded by byte 0xFF */
-	SOF  = 0xC0 /* Start of Frame */
-	SOF2 = 0xC2 /* Start of Frame; progressive Huffman */
-	JPG  = 0xC8 /* Reserved for JPEG extensions */
-	DHT  = 0xC4 /* Define Huffman Tables */
-	DAC  = 0xCC /* Arithmetic coding conditioning */
-	RST  = 0xD0 /* Restart interval termination */
-	RST7 = 0xD7 /* Restart interval termination (highest value) */
-	SOI  = 0xD8 /* Start of Image */
-	EOI  = 0xD9 /* End of Image */
-	SOS  = 0xDA /* Start of Scan */
-	DQT  = 0xDB /* Define quantization tables */
-	DNL  = 0xDC /* Define number of lines */
-	DRI  = 0xDD /* Define restart interval */
-	DHP  = 0xDE /* Define hierarchical progression */
-	EXP  = 0xDF /* Expand reference components */
-	APPn = 0xE0 /* Reserved for application segments */
-	JPGn = 0xF0 /* Reserved for JPEG extensions */
-	COM  = 0xFE /* Comment */
+	exitClean   = 0 // Nothing was changed, or would be.
+	exitChanged = 1 // Metadata was removed or edited, or would be.
+	exitError   = 2 // An input could not be parsed, or the command line is bad.
+	exitIO      = 3 // An I/O error occurred.
 )
 
-func scrub(f *os.File) {
-	data, err := ioutil.ReadAll(f)
-	ck(err)
-	s := NewScanner(data)
-	s.header()
-	for s.segment() > 0 {
-	}
-	if *iFlag {
-		ck(ioutil.WriteFile(flag.Arg(0), s.out, 0664))
-	} else {
-		os.Stdout.Write(s.out)
+// fatal reports the error and exits with the status.
+func fatal(status int, err interface{}) {
+	log.Print(err)
+	os.Exit(status)
+}
+
+// warn reports a problem that does not stop the work, unless -q is set.
+func warn(format string, args ...interface{}) {
+	if !*quiet {
+		log.Printf(format, args...)
 	}
 }
 
-func ck(err error) {
-	if err != nil {
-		log.Fatal(err)
+// newScanner returns a Scanner that reads from r and writes to w,
+// reporting the problems it works around as warnings.
+func newScanner(r io.Reader, w io.Writer) *scrub.Scanner {
+	s := scrub.NewScanner(r, w)
+	s.Warn(func(msg string) {
+		warn("%s", msg)
+	})
+	return s
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, "usage: scrub [flags] [file ...]\n")
+	fmt.Fprintf(os.Stderr, "       scrub -diff a.jpg b.jpg\n")
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// count returns the number of true values.
+func count(bools ...bool) int {
+	n := 0
+	for _, b := range bools {
+		if b {
+			n++
+		}
 	}
+	return n
+}
+
+// The functions that process a file return what was done to it, or
+// would be.
+
+func listFile(f *os.File) (result, error) {
+	return result{}, list(os.Stdout, f)
+}
+
+func sizesFile(f *os.File) (result, error) {
+	return result{}, sizes(os.Stdout, f)
 }
 
-type Scanner struct {
-	in     []byte
-	out    []byte
-	offset int
+func exifFile(f *os.File) (result, error) {
+	return result{}, printEXIF(os.Stdout, f)
 }
 
-func NewScanner(data []byte) *Scanner {
-	return &Scanner{in: data, out: make([]byte, 0, len(data))}
+func jsonFile(f *os.File) (result, error) {
+	return result{}, printJSON(os.Stdout, f)
 }
 
-func (s *Scanner) ReadByte() int {
-	if len(s.in) == 0 {
-		log.Fatal("EOF")
+func checkFile(f *os.File) (result, error) {
+	removed, changed, err := clean(nil, f, nil, nil)
+	switch {
+	case err != nil:
+	case removed > 0:
+		warn("%s: %d bytes of metadata", f.Name(), removed)
+	case changed:
+		warn("%s: metadata to edit in place", f.Name())
 	}
-	s.out = append(s.out, s.in[0])
-	c := s.in[0]
-	s.in = s.in[1:]
-	s.offset++
-	return int(c)
+	return result{removed, changed}, err
 }
 
-func (s *Scanner) Read(n int) (data []byte) {
-	if len(s.in) < n {
-		log.Fatal("EOF")
+// detectFile returns the number of findings rather than of bytes.
+func detectFile(f *os.File) (result, error) {
+	findings, err := detect(f)
+	for _, s := range findings {
+		fmt.Printf("%s: %s\n", f.Name(), s)
 	}
-	data, s.in = s.in[0:n], s.in[n:]
-	s.out = append(s.out, data...)
-	s.offset += n
-	return
+	return result{int64(len(findings)), len(findings) > 0}, err
 }
 
-func (s *Scanner) drain() {
-	s.out = append(s.out, s.in...)
-	// s.in no longer valid
+func scrubFile(f *os.File) (result, error) {
+	var out io.Writer = os.Stdout
+	var buf bytes.Buffer
+	name, err := outputName(f)
+	if err != nil {
+		return result{}, err
+	}
+	if name != "" {
+		out = &buf
+	}
+	var sum [sha256.Size]byte
+	if cache != nil {
+		sum, err = hashFile(f)
+		if err != nil {
+			return result{}, err
+		}
+		if cache.has(sum) {
+			if *verbose {
+				log.Printf("%s: scrubbed before", f.Name())
+			}
+			return result{}, nil
+		}
+	}
+	var rec *auditRecord
+	if auditLog != nil {
+		rec = newAuditRecord(f.Name())
+	}
+	removed, changed, err := clean(out, f, rec, nil)
+	res := result{removed, changed}
+	if err != nil {
+		return result{}, err
+	}
+	if *verbose {
+		if removed == 0 && changed {
+			log.Printf("%s: edited in place", f.Name())
+		} else {
+			log.Printf("%s: %d bytes removed", f.Name(), removed)
+		}
+	}
+	if *validateFlag != "" {
+		if err := validate(f, buf.Bytes(), *validateFlag == "full"); err != nil {
+			return result{}, err
+		}
+	}
+	if name != "" && !(*onlyDirty && unchanged(f, buf.Bytes())) {
+		info, err := f.Stat()
+		if err != nil {
+			return res, err
+		}
+		f.Close()
+		if *iFlag {
+			err = replace(name, buf.Bytes(), info)
+		} else {
+			err = ioutil.WriteFile(name, buf.Bytes(), 0664)
+		}
+		if err != nil {
+			return res, err
+		}
+		if *keepMtime {
+			if err := os.Chtimes(name, time.Time{}, info.ModTime()); err != nil {
+				return res, err
+			}
+		}
+	}
+	if cache != nil {
+		// Record the input, which -d, -o, and -suffix leave in place,
+		// and the output, which -i puts in its place.
+		if err := cache.add(sum); err != nil {
+			return res, err
+		}
+		if err := cache.add(sha256.Sum256(buf.Bytes())); err != nil {
+			return res, err
+		}
+	}
+	if rec != nil {
+		err = rec.write()
+	}
+	return res, err
 }
 
-func (s *Scanner) header() {
-	if c := s.marker(); c != SOI {
-		log.Fatalf("expected SOI; saw 0x%.2x\n", c)
+// unchanged reports whether the contents of f, which must be a file
+// that can be read at any offset, are the data.
+func unchanged(f *os.File, data []byte) bool {
+	info, err := f.Stat()
+	if err != nil || !info.Mode().IsRegular() || info.Size() != int64(len(data)) {
+		return false
 	}
+	b := make([]byte, 32*1024)
+	for off := 0; off < len(data); off += len(b) {
+		chunk := data[off:]
+		if len(chunk) > len(b) {
+			chunk = chunk[:len(b)]
+		}
+		n, _ := f.ReadAt(b[:len(chunk)], int64(off))
+		if n != len(chunk) || !bytes.Equal(b[:n], chunk) {
+			return false
+		}
+	}
+	return true
 }
 
-func (s *Scanner) marker() int {
-	var c int
-	for {
-		c = s.ReadByte()
-		if c != 0 {
-			break
+// replace overwrites the named file, whose original is described by
+// info, with the data, keeping its permissions and owner. So that a
+// crash cannot leave the file half written, the data goes first to a
+// temporary file in the same directory, which is then renamed over the
+// original. A symbolic link is followed, unless -symlinks=replace is
+// set, in which case the link itself is replaced.
+func replace(name string, data []byte, info os.FileInfo) error {
+	if *symlinksFlag != "replace" {
+		var err error
+		if name, err = filepath.EvalSymlinks(name); err != nil {
+			return err
 		}
-		fmt.Fprintf(os.Stderr, "scrub: skipping zero byte\n")
 	}
-	if c != 0xFF {
-		log.Fatalf("expecting marker at 0x%x, found 0x%.2x", s.offset-1, c)
+	tmp, err := ioutil.TempFile(filepath.Dir(name), ".scrub")
+	if err != nil {
+		return err
+	}
+	_, err = tmp.Write(data)
+	if err == nil {
+		err = tmp.Sync()
+	}
+	if err1 := tmp.Close(); err == nil {
+		err = err1
+	}
+	if err == nil {
+		err = keepOwner(tmp.Name(), info)
+	}
+	if err == nil {
+		err = os.Rename(tmp.Name(), name)
 	}
-	for c == 0xFF {
-		c = s.ReadByte()
+	if err != nil {
+		os.Remove(tmp.Name())
 	}
-	return c
+	return err
 }
 
-func int2(b []byte) int {
-	return int(b[0])<<8 + int(b[1])
+// outputName returns the name of the file to which the scrubbed f
+// is written, or the empty string for standard output. Unless -i is
+// set, it refuses to name the input itself.
+func outputName(f *os.File) (string, error) {
+	var name string
+	switch {
+	case *iFlag:
+		return f.Name(), nil
+	case *outFlag != "":
+		name = *outFlag
+	case *dirFlag != "" || *suffixFlag != "":
+		dir, base := filepath.Split(f.Name())
+		if *dirFlag != "" {
+			dir = *dirFlag
+		}
+		ext := filepath.Ext(base)
+		name = filepath.Join(dir, strings.TrimSuffix(base, ext)+*suffixFlag+ext)
+	default:
+		return "", nil
+	}
+	in, err1 := f.Stat()
+	out, err2 := os.Stat(name)
+	if err1 == nil && err2 == nil && os.SameFile(in, out) {
+		return "", errors.New("output is the input; use -i to overwrite it")
+	}
+	return name, nil
 }
 
-func (s *Scanner) segment() int {
-	start := len(s.out)
-	var c int
-	switch c = s.marker(); c {
-	case EOI:
-		return 0
-	case 0:
-		log.Fatalf("expecting marker; saw 0x%.2x at offset 0x%x", c, s.offset-1)
+// clean scrubs f as the flags direct, writing the result to out, and
+// returns the number of bytes removed and whether anything was changed,
+// which it may be without the length changing. If out is nil, the
+// result is discarded. If rec is not nil, clean fills it in for the
+// audit log, and if watch is not nil, clean calls it with each segment
+// of the input.
+func clean(out io.Writer, f *os.File, rec *auditRecord, watch func(scrub.Segment)) (int64, bool, error) {
+	var r io.Reader = f
+	stream := out == os.Stdout
+	if out == nil {
+		out = ioutil.Discard
+	}
+	if rec != nil {
+		r = io.TeeReader(r, &rec.in)
+		out = io.MultiWriter(out, &rec.out)
+	}
+	br := bufio.NewReader(r)
+	kind, err := fileFormat(br)
+	if err != nil {
+		return 0, false, err
+	}
+	if why := misnamed(f.Name(), kind); why != "" {
+		warn("%s: %s", f.Name(), why)
+	}
+	if kind.Name != "JPEG" {
+		return scrubOther(kind, out, br, func(r scrub.Removal) {
+			reportRemoval(r)
+			if rec != nil {
+				rec.addRemoval(kind.Name, r)
+			}
+		})
 	}
-	buf := s.Read(2)
-	n := int2(buf[0:2])
-	if n < 2 {
-		log.Fatal("early EOF")
+	r = br
+	var vin, vout *verifier
+	if *verify {
+		vin, vout = newVerifier(), newVerifier()
+		r = io.TeeReader(r, vin)
+		out = io.MultiWriter(out, vout)
 	}
-	n -= 2
-	buf = s.Read(n)
-	// Is this an App, JPEG, or comment segment? if so, ignore it
-	if c >= APPn {
-		s.out = s.out[0:start]
+	var exif []byte
+	var motion *motionVideo
+	if *syntheticEXIF || *motionFlag == "scrub" {
+		// We need the dimensions before writing the EXIF data
+		// at the start of the output, and the length of the
+		// scrubbed video before writing the XMP data that
+		// locates it, so read the whole image.
+		data, err := ioutil.ReadAll(r)
+		if err != nil {
+			return 0, false, err
+		}
+		if *syntheticEXIF {
+			width, height, err := scrub.Dimensions(bytes.NewReader(data))
+			if err != nil {
+				return 0, false, err
+			}
+			exif = scrub.SyntheticEXIF(width, height, "scrub")
+		}
+		if *motionFlag == "scrub" {
+			image, video, err := scrub.SplitMotionPhoto(data)
+			if err != nil {
+				return 0, false, err
+			}
+			if video != nil {
+				var buf bytes.Buffer
+				if err := scrub.ScrubMP4(bytes.NewReader(video), &buf); err != nil {
+					return 0, false, fmt.Errorf("motion photo video: %v", err)
+				}
+				motion = &motionVideo{video: video, scrubbed: buf.Bytes()}
+				if n := len(data) - len(image) - len(video); n > 0 {
+					warn("%s: removing %d bytes after the motion photo video", f.Name(), n)
+					motion.after = int64(n)
+				}
+				data = image
+			}
+		}
+		r = bytes.NewReader(data)
+	}
+	var bw *bufio.Writer
+	if stream {
+		// Gather the segments of each frame for the Scanner to
+		// flush at its end, so a stream of frames from a camera
+		// passes through a frame at a time.
+		bw = bufio.NewWriter(out)
+		out = bw
+	}
+	s := scrub.NewScanner(r, out)
+	s.Warn(func(msg string) {
+		warn("%s: %s", f.Name(), msg)
+	})
+	p := make(parts)
+	s.Filter(filter(s, p, exif, motion))
+	if *addEOI {
+		s.AddEOI()
 	}
-	if c == SOS {
-		// This is real data; just run to completion
-		s.drain()
-		return 0
+	if *force {
+		s.Resync()
+	}
+	if *strict {
+		s.Strict()
+	}
+	if *zero {
+		s.Edit(zeroEdit(p))
+	} else {
+		s.Edit(edit)
+	}
+	var removed int64
+	changed := false
+	for s.Scan() {
+		n, edited := report(s.Segment())
+		removed += n
+		changed = changed || edited
+		if rec != nil {
+			rec.add(s.Segment())
+		}
+		if watch != nil {
+			watch(s.Segment())
+		}
+	}
+	if motion != nil && s.Err() == nil {
+		video := motion.video
+		if motion.replaced {
+			video = motion.scrubbed
+			removed += int64(len(motion.video) - len(video))
+			changed = changed || !bytes.Equal(motion.video, video)
+		}
+		removed += motion.after
+		changed = changed || motion.after > 0
+		if _, err := out.Write(video); err != nil {
+			return removed, changed, err
+		}
+	}
+	if bw != nil {
+		if err := bw.Flush(); err != nil && s.Err() == nil {
+			return removed, changed, err
+		}
+	}
+	if *verify {
+		before, err1 := vin.digest()
+		after, err2 := vout.digest()
+		switch {
+		case s.Err() != nil:
+			// Reported below.
+		case err1 != nil:
+			return removed, changed, fmt.Errorf("cannot verify: %v", err1)
+		case err2 != nil:
+			return removed, changed, fmt.Errorf("cannot verify output: %v", err2)
+		case before != after:
+			return removed, changed, errors.New("verification failed: image data changed")
+		}
+	}
+	return removed, changed, s.Err()
+}
+
+// report returns the number of bytes removed from the segment and its
+// data and whether the output differs there from the input, and, if -v
+// is set, describes the change.
+func report(seg scrub.Segment) (int64, bool) {
+	in := int64(seg.Length) + seg.Data
+	changed := seg.Written != in || seg.Edited || seg.Inserted > 0
+	if !*verbose || !changed {
+		return in - seg.Written, changed
+	}
+	what := scrub.MarkerName(seg.Marker)
+	if d := describe(seg); d != "" {
+		what += " (" + d + ")"
+	}
+	switch {
+	case seg.Inserted == 1:
+		log.Printf("added a segment before %s at 0x%x", what, seg.Offset)
+	case seg.Inserted > 1:
+		log.Printf("added %d segments before %s at 0x%x", seg.Inserted, what, seg.Offset)
+	}
+	switch {
+	case seg.Written == 0:
+		log.Printf("removed %s at 0x%x: %d bytes", what, seg.Offset, in)
+	case seg.Written != in:
+		log.Printf("rewrote %s at 0x%x: %d to %d bytes", what, seg.Offset, in, seg.Written)
+	case seg.Edited:
+		log.Printf("rewrote %s at 0x%x in place: %d bytes", what, seg.Offset, in)
+	}
+	return in - seg.Written, changed
+}
+
+// reportRemoval reports, if -v is set, an item removed or rewritten by
+// the scrubber of a format other than JPEG.
+func reportRemoval(r scrub.Removal) {
+	if !*verbose {
+		return
+	}
+	switch {
+	case r.Written == 0:
+		log.Printf("removed %s: %d bytes", r.What, r.Length)
+	case r.Written != r.Length:
+		log.Printf("rewrote %s: %d to %d bytes", r.What, r.Length, r.Written)
+	default:
+		log.Printf("rewrote %s in place: %d bytes", r.What, r.Length)
 	}
-	return c
 }
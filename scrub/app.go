@@ -0,0 +1,94 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import "bytes"
+
+// Application segments identify their contents with a signature,
+// usually a NUL-terminated string, at the start of the payload.
+
+var iccHeader = []byte("ICC_PROFILE\x00")
+
+// IsICC reports whether the payload of an APP2 segment holds
+// (part of) an ICC color profile.
+func IsICC(payload []byte) bool {
+	return bytes.HasPrefix(payload, iccHeader)
+}
+
+var mpfHeader = []byte("MPF\x00")
+
+// IsMPF reports whether the payload of an APP2 segment holds a
+// Multi-Picture Format index. The index locates further images, such
+// as depth maps or previews, stored after the end of the main image.
+func IsMPF(payload []byte) bool {
+	return bytes.HasPrefix(payload, mpfHeader)
+}
+
+var jfifHeader = []byte("JFIF\x00")
+
+// IsJFIF reports whether the payload of an APP0 segment is a JFIF header.
+func IsJFIF(payload []byte) bool {
+	return bytes.HasPrefix(payload, jfifHeader) && len(payload) >= 14
+}
+
+// JFIFHeader returns the JFIF payload with any embedded thumbnail removed,
+// leaving just the version and pixel density.
+func JFIFHeader(payload []byte) []byte {
+	p := append([]byte(nil), payload[:14]...)
+	p[12], p[13] = 0, 0 // Thumbnail width and height.
+	return p
+}
+
+// MinimalJFIF returns the payload of a minimal JFIF header: version
+// 1.01, square pixels of no particular density, and no thumbnail.
+func MinimalJFIF() []byte {
+	return append(append([]byte(nil), jfifHeader...), 1, 1, 0, 0, 1, 0, 1, 0, 0)
+}
+
+var adobeHeader = []byte("Adobe")
+
+// IsAdobe reports whether the payload of an APP14 segment is an Adobe
+// header, which records how the color components were transformed.
+func IsAdobe(payload []byte) bool {
+	return bytes.HasPrefix(payload, adobeHeader) && len(payload) >= 12
+}
+
+// Adobe color transforms.
+const (
+	AdobeUnknown = 0 // RGB or CMYK, depending on the number of components.
+	AdobeYCbCr   = 1
+	AdobeYCCK    = 2
+)
+
+// AdobeTransform returns the color transform recorded in the Adobe
+// APP14 payload.
+func AdobeTransform(payload []byte) int {
+	return int(payload[11])
+}
+
+var (
+	xmpHeader         = []byte("http://ns.adobe.com/xap/1.0/\x00")
+	extendedXMPHeader = []byte("http://ns.adobe.com/xmp/extension/\x00")
+)
+
+// IsXMP reports whether the payload of an APP1 segment holds an XMP packet.
+func IsXMP(payload []byte) bool {
+	return bytes.HasPrefix(payload, xmpHeader)
+}
+
+// IsExtendedXMP reports whether the payload of an APP1 segment holds part
+// of the extended XMP data, which continues a packet too large for one segment.
+func IsExtendedXMP(payload []byte) bool {
+	return bytes.HasPrefix(payload, extendedXMPHeader)
+}
+
+var photoshopHeader = []byte("Photoshop 3.0\x00")
+
+// IsPhotoshop reports whether the payload of an APP13 segment holds
+// Photoshop image resource blocks, which carry the IPTC data: captions,
+// keywords, bylines, and the like.
+func IsPhotoshop(payload []byte) bool {
+	return bytes.HasPrefix(payload, photoshopHeader)
+}
@@ -0,0 +1,183 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"encoding/binary"
+	"errors"
+	"io"
+)
+
+// HEIF, AVIF, MP4, and QuickTime files are all built from the boxes
+// of the ISO base media file format. A box is a big-endian size, a
+// four-character type, and the contents, which may be further boxes.
+// A size of 1 means a 64-bit size follows the type; 0 means the box
+// extends to the end of the file.
+
+var errBMFF = errors.New("malformed ISO media box")
+
+// A box is a box in a file, located by its offsets in the data.
+type box struct {
+	typ   string
+	start int64 // The start of the header.
+	body  int64 // The start of the contents.
+	end   int64
+}
+
+// boxes parses the sequence of boxes in data[start:end].
+func boxes(data []byte, start, end int64) ([]box, error) {
+	var list []box
+	for p := start; p < end; {
+		if p+8 > end {
+			return nil, errBMFF
+		}
+		b := box{
+			typ:   string(data[p+4 : p+8]),
+			start: p,
+			body:  p + 8,
+		}
+		size := int64(binary.BigEndian.Uint32(data[p:]))
+		switch size {
+		case 0:
+			size = end - p
+		case 1:
+			if p+16 > end {
+				return nil, errBMFF
+			}
+			size = int64(binary.BigEndian.Uint64(data[p+8:]))
+			b.body = p + 16
+		}
+		if size < b.body-p || size > end-p {
+			return nil, errBMFF
+		}
+		b.end = p + size
+		list = append(list, b)
+		p = b.end
+	}
+	return list, nil
+}
+
+// readBox reads the header of the next box from r and returns it,
+// with the box's type and the size of its contents, which is -1 if
+// the box extends to the end of the file. At the end of the input,
+// it returns io.EOF.
+func readBox(r io.Reader) (hdr []byte, typ string, size int64, err error) {
+	hdr = make([]byte, 8, 16)
+	if _, err := io.ReadFull(r, hdr); err != nil {
+		return nil, "", 0, err
+	}
+	typ = string(hdr[4:8])
+	size = int64(binary.BigEndian.Uint32(hdr))
+	switch size {
+	case 0:
+		return hdr, typ, -1, nil
+	case 1:
+		hdr = hdr[:16]
+		if _, err := io.ReadFull(r, hdr[8:]); err != nil {
+			return nil, "", 0, noEOF(err)
+		}
+		size = int64(binary.BigEndian.Uint64(hdr[8:]))
+	}
+	if size < int64(len(hdr)) {
+		return nil, "", 0, errBMFF
+	}
+	return hdr, typ, size - int64(len(hdr)), nil
+}
+
+// ftyp returns the major brand and the compatible brands of the file,
+// which begins with a file type box.
+func ftyp(data []byte) []string {
+	if len(data) < 16 || string(data[4:8]) != "ftyp" {
+		return nil
+	}
+	size := int(binary.BigEndian.Uint32(data))
+	if size < 16 || size > len(data) {
+		size = len(data)
+	}
+	brands := []string{string(data[8:12])}
+	for p := 16; p+4 <= size; p += 4 {
+		brands = append(brands, string(data[p:p+4]))
+	}
+	return brands
+}
+
+// appendBox appends to b a box of the given type holding the contents.
+func appendBox(b []byte, typ string, contents []byte) []byte {
+	var hdr [8]byte
+	binary.BigEndian.PutUint32(hdr[:], uint32(8+len(contents)))
+	copy(hdr[4:], typ)
+	return append(append(b, hdr[:]...), contents...)
+}
+
+// uintN returns the big-endian unsigned integer of n bytes at the start
+// of b, where n is 0, 2, 4, or 8.
+func uintN(b []byte, n int) uint64 {
+	switch n {
+	case 2:
+		return uint64(binary.BigEndian.Uint16(b))
+	case 4:
+		return uint64(binary.BigEndian.Uint32(b))
+	case 8:
+		return binary.BigEndian.Uint64(b)
+	}
+	return 0
+}
+
+// appendUintN appends v to b as a big-endian unsigned integer of n bytes,
+// where n is 0, 2, 4, or 8.
+func appendUintN(b []byte, n int, v uint64) []byte {
+	var buf [8]byte
+	binary.BigEndian.PutUint64(buf[:], v)
+	return append(b, buf[8-n:]...)
+}
+
+// A cursor reads the fields of a box's contents in order. Reading past
+// the end yields zeros and marks the cursor bad.
+type cursor struct {
+	b   []byte
+	p   int
+	bad bool
+}
+
+// next returns the next n bytes.
+func (c *cursor) next(n int) []byte {
+	if n < 0 {
+		n = 0
+		c.bad = true
+	}
+	if c.bad || c.p+n > len(c.b) {
+		c.bad = true
+		return make([]byte, n)
+	}
+	c.p += n
+	return c.b[c.p-n : c.p]
+}
+
+// uint returns the next n bytes as a big-endian unsigned integer,
+// where n is 0, 2, 4, or 8.
+func (c *cursor) uint(n int) uint64 {
+	return uintN(c.next(n), n)
+}
+
+// cstring returns the next NUL-terminated string.
+func (c *cursor) cstring() string {
+	for i := c.p; i < len(c.b); i++ {
+		if c.b[i] == 0 {
+			s := string(c.b[c.p:i])
+			c.p = i + 1
+			return s
+		}
+	}
+	c.bad = true
+	return ""
+}
+
+// rest returns the bytes not yet read.
+func (c *cursor) rest() []byte {
+	if c.bad {
+		return nil
+	}
+	return c.b[c.p:]
+}
@@ -0,0 +1,328 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"math"
+	"strings"
+)
+
+// Decoding, for programs that want to show the metadata before removing it.
+
+// A Field is an EXIF tag and its value. The type of Value depends on
+// the type of the tag: string for ASCII, []byte for UNDEFINED, []uint32
+// for the unsigned integer types, []int32 for the signed ones, []Rational
+// for the rational types, and []float64 for the floating-point ones.
+type Field struct {
+	Tag   Tag
+	Value interface{}
+}
+
+// A Rational is the value of an EXIF rational number, Num/Den.
+type Rational struct {
+	Num, Den int64
+}
+
+// Float returns the value of the rational as a float64.
+func (r Rational) Float() float64 {
+	if r.Den == 0 {
+		return math.NaN()
+	}
+	return float64(r.Num) / float64(r.Den)
+}
+
+func (r Rational) String() string {
+	return fmt.Sprintf("%d/%d", r.Num, r.Den)
+}
+
+// DecodeEXIF returns the fields of the EXIF payload, directory by
+// directory. The tags that point to other directories are omitted.
+func DecodeEXIF(payload []byte) ([]Field, error) {
+	if !IsEXIF(payload) {
+		return nil, fmt.Errorf("not EXIF data")
+	}
+	t, err := parseTIFF(payload[6:])
+	if err != nil {
+		return nil, err
+	}
+	var fields []Field
+	err = t.walk(func(which IFD, d *ifd) error {
+		for _, e := range d.entries {
+			if isPointer(which, e.tag) || e.value == nil {
+				continue
+			}
+			fields = append(fields, Field{Tag{which, e.tag}, t.decode(e)})
+		}
+		return nil
+	})
+	return fields, err
+}
+
+// decode returns the value of the entry, as described for Field.
+func (t *tiff) decode(e entry) interface{} {
+	v := e.value
+	switch e.typ {
+	case 1, 3, 4: // BYTE, SHORT, LONG
+		if e.typ == 1 {
+			u := make([]uint32, len(v))
+			for i, b := range v {
+				u[i] = uint32(b)
+			}
+			return u
+		}
+		return t.uints(e)
+	case 2: // ASCII
+		return string(bytes.TrimRight(v, "\x00"))
+	case 6: // SBYTE
+		s := make([]int32, len(v))
+		for i, b := range v {
+			s[i] = int32(int8(b))
+		}
+		return s
+	case 8: // SSHORT
+		var s []int32
+		for i := 0; i+2 <= len(v); i += 2 {
+			s = append(s, int32(int16(t.order.Uint16(v[i:]))))
+		}
+		return s
+	case 9: // SLONG
+		var s []int32
+		for i := 0; i+4 <= len(v); i += 4 {
+			s = append(s, int32(t.order.Uint32(v[i:])))
+		}
+		return s
+	case 5, 10: // RATIONAL, SRATIONAL
+		var r []Rational
+		for i := 0; i+8 <= len(v); i += 8 {
+			n, d := t.order.Uint32(v[i:]), t.order.Uint32(v[i+4:])
+			if e.typ == 5 {
+				r = append(r, Rational{int64(n), int64(d)})
+			} else {
+				r = append(r, Rational{int64(int32(n)), int64(int32(d))})
+			}
+		}
+		return r
+	case 11: // FLOAT
+		var f []float64
+		for i := 0; i+4 <= len(v); i += 4 {
+			f = append(f, float64(math.Float32frombits(t.order.Uint32(v[i:]))))
+		}
+		return f
+	case 12: // DOUBLE
+		var f []float64
+		for i := 0; i+8 <= len(v); i += 8 {
+			f = append(f, math.Float64frombits(t.order.Uint64(v[i:])))
+		}
+		return f
+	}
+	return append([]byte(nil), v...)
+}
+
+// A Property is one value of an XMP packet, such as dc:creator.
+// Array items appear as separate properties with the same name.
+type Property struct {
+	Name  string // Prefixed name, as in "dc:creator".
+	Value string
+}
+
+// xmpPrefixes gives the conventional prefixes of common XMP namespaces.
+var xmpPrefixes = map[string]string{
+	"http://purl.org/dc/elements/1.1/":                 "dc",
+	"http://ns.adobe.com/xap/1.0/":                     "xmp",
+	"http://ns.adobe.com/xap/1.0/mm/":                  "xmpMM",
+	"http://ns.adobe.com/xap/1.0/sType/ResourceEvent#": "stEvt",
+	"http://ns.adobe.com/xap/1.0/sType/ResourceRef#":   "stRef",
+	"http://ns.adobe.com/xap/1.0/rights/":              "xmpRights",
+	"http://ns.adobe.com/photoshop/1.0/":               "photoshop",
+	"http://ns.adobe.com/exif/1.0/":                    "exif",
+	"http://ns.adobe.com/exif/1.0/aux/":                "aux",
+	"http://ns.adobe.com/tiff/1.0/":                    "tiff",
+	"http://ns.adobe.com/camera-raw-settings/1.0/":     "crs",
+	"http://iptc.org/std/Iptc4xmpCore/1.0/xmlns/":      "Iptc4xmpCore",
+	"http://iptc.org/std/Iptc4xmpExt/2008-02-29/":      "Iptc4xmpExt",
+	"http://www.w3.org/1999/02/22-rdf-syntax-ns#":      "rdf",
+	"adobe:ns:meta/":                                   "x",
+	"http://ns.google.com/photos/1.0/camera/":          "GCamera",
+	"http://ns.adobe.com/xmp/note/":                    "xmpNote",
+	"http://ns.adobe.com/xap/1.0/g/img/":               "xmpGImg",
+	"http://cipa.jp/exif/1.0/":                         "exifEX",
+	"http://ns.microsoft.com/photo/1.0/":               "MicrosoftPhoto",
+	"http://ns.adobe.com/lightroom/1.0/":               "lr",
+	"http://ns.useplus.org/ldf/xmp/1.0/":               "plus",
+	"http://ns.adobe.com/xap/1.0/sType/Dimensions#":    "stDim",
+}
+
+const rdfNS = "http://www.w3.org/1999/02/22-rdf-syntax-ns#"
+
+// DecodeXMP returns the simple properties of the XMP packet in the payload
+// of an APP1 segment, in the order they appear. Properties with structured
+// values are flattened: each leaf is reported under the name of its
+// nearest enclosing property.
+func DecodeXMP(payload []byte) ([]Property, error) {
+	if !IsXMP(payload) {
+		return nil, fmt.Errorf("not XMP data")
+	}
+	d := xml.NewDecoder(bytes.NewReader(payload[len(xmpHeader):]))
+	d.Strict = false
+	var props []Property
+	var stack []xml.Name
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return props, nil
+		}
+		if err != nil {
+			return props, err
+		}
+		switch tok := tok.(type) {
+		case xml.StartElement:
+			stack = append(stack, tok.Name)
+			for _, a := range tok.Attr {
+				if a.Name.Space == "xmlns" || a.Name.Space == "" || isRDF(a.Name) {
+					continue
+				}
+				props = append(props, Property{xmpName(a.Name), a.Value})
+			}
+		case xml.EndElement:
+			stack = stack[:len(stack)-1]
+		case xml.CharData:
+			v := strings.TrimSpace(string(tok))
+			if v == "" {
+				break
+			}
+			for i := len(stack) - 1; i >= 0; i-- {
+				if !isRDF(stack[i]) {
+					props = append(props, Property{xmpName(stack[i]), v})
+					break
+				}
+			}
+		}
+	}
+}
+
+// isRDF reports whether the name is in the RDF namespace, which
+// gives the structure of the XMP data rather than its content.
+func isRDF(n xml.Name) bool {
+	return n.Space == rdfNS || n.Space == "rdf"
+}
+
+// xmpName returns the prefixed form of the name.
+func xmpName(n xml.Name) string {
+	prefix, ok := xmpPrefixes[n.Space]
+	if !ok {
+		prefix = n.Space // Unknown namespace, or undeclared prefix.
+	}
+	if prefix == "" {
+		return n.Local
+	}
+	return prefix + ":" + n.Local
+}
+
+// An IPTCField is one dataset of IPTC data, such as 2:120, the caption.
+type IPTCField struct {
+	Record, Dataset int
+	Value           []byte
+}
+
+// iptcNames gives the names of common IPTC datasets in record 2.
+var iptcNames = map[int]string{
+	5:   "ObjectName",
+	10:  "Urgency",
+	15:  "Category",
+	20:  "SupplementalCategories",
+	25:  "Keywords",
+	40:  "SpecialInstructions",
+	55:  "DateCreated",
+	60:  "TimeCreated",
+	62:  "DigitalCreationDate",
+	63:  "DigitalCreationTime",
+	65:  "OriginatingProgram",
+	80:  "By-line",
+	85:  "By-lineTitle",
+	90:  "City",
+	92:  "Sub-location",
+	95:  "Province-State",
+	100: "Country-PrimaryLocationCode",
+	101: "Country-PrimaryLocationName",
+	103: "OriginalTransmissionReference",
+	105: "Headline",
+	110: "Credit",
+	115: "Source",
+	116: "CopyrightNotice",
+	118: "Contact",
+	120: "Caption-Abstract",
+	122: "Writer-Editor",
+}
+
+// Name returns the name of the dataset, or its number if the name is unknown.
+func (f IPTCField) Name() string {
+	if name, ok := iptcNames[f.Dataset]; ok && f.Record == 2 {
+		return name
+	}
+	return fmt.Sprintf("%d:%d", f.Record, f.Dataset)
+}
+
+// iptcResource is the ID of the Photoshop resource holding IPTC data.
+const iptcResource = 0x0404
+
+// DecodeIPTC returns the IPTC datasets held in the payload of a
+// Photoshop APP13 segment.
+func DecodeIPTC(payload []byte) ([]IPTCField, error) {
+	if !IsPhotoshop(payload) {
+		return nil, fmt.Errorf("not Photoshop data")
+	}
+	var fields []IPTCField
+	p := payload[len(photoshopHeader):]
+	for len(p) >= 12 && string(p[:4]) == "8BIM" {
+		id := binary.BigEndian.Uint16(p[4:])
+		// The name is a Pascal string padded to an even length.
+		n := 6 + (1+int(p[6])+1)&^1
+		if n+4 > len(p) {
+			return fields, errPhotoshop
+		}
+		size := int(binary.BigEndian.Uint32(p[n:]))
+		p = p[n+4:]
+		if size > len(p) {
+			return fields, errPhotoshop
+		}
+		if id == iptcResource {
+			f, err := decodeIPTC(p[:size])
+			fields = append(fields, f...)
+			if err != nil {
+				return fields, err
+			}
+		}
+		if size&1 != 0 && size < len(p) {
+			size++ // Data is padded to an even length.
+		}
+		p = p[size:]
+	}
+	return fields, nil
+}
+
+var errPhotoshop = errors.New("malformed Photoshop resource data")
+
+var errIPTC = errors.New("malformed IPTC data")
+
+// decodeIPTC decodes a block of IPTC datasets.
+func decodeIPTC(p []byte) ([]IPTCField, error) {
+	var fields []IPTCField
+	for len(p) >= 5 && p[0] == 0x1C {
+		n := int(binary.BigEndian.Uint16(p[3:]))
+		if n&0x8000 != 0 || 5+n > len(p) {
+			// Extended datasets hold no metadata of interest; stop.
+			return fields, errIPTC
+		}
+		fields = append(fields, IPTCField{int(p[1]), int(p[2]), p[5 : 5+n]})
+		p = p[5+n:]
+	}
+	return fields, nil
+}
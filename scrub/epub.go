@@ -0,0 +1,66 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"io"
+	"regexp"
+	"strings"
+)
+
+// An EPUB book is a ZIP archive that begins with a file named mimetype
+// holding the type. The package document, with the extension .opf,
+// describes the book in its metadata element: besides the title,
+// identifier, and language, which are required, it names creators,
+// contributors, and the publisher, gives dates, and holds meta
+// elements added by tools such as calibre that record where the book
+// came from. Scrubbing keeps only the required elements, the date of
+// modification that EPUB 3 also requires, and the reference to the
+// cover, and scrubs the images.
+
+// IsEPUB reports whether the data begins like an EPUB book.
+func IsEPUB(data []byte) bool {
+	return zipName(data) == "mimetype" && bytes.Contains(data, []byte("application/epub+zip"))
+}
+
+// epubKept lists the elements of the metadata that are kept.
+var epubKept = map[string]bool{
+	"title":      true,
+	"identifier": true,
+	"language":   true,
+}
+
+// epubMetaKeptRE matches the meta elements that are kept.
+var epubMetaKeptRE = regexp.MustCompile(`\b(property\s*=\s*["']dcterms:modified["']|name\s*=\s*["']cover["'])`)
+
+// ScrubEPUB reads an EPUB book from r and writes it to w with only the
+// required metadata and its images scrubbed. It holds the whole file
+// in memory.
+func ScrubEPUB(r io.Reader, w io.Writer) error {
+	return scrubEPUB(r, w, nil)
+}
+
+func scrubEPUB(r io.Reader, w io.Writer, removed func(Removal)) error {
+	return rewriteZip(r, w, removed, func(name string, data []byte) ([]byte, error) {
+		if strings.HasSuffix(strings.ToLower(name), ".opf") {
+			return filterXML(data, epubDropElement, func(string) bool { return false }, nil)
+		}
+		return scrubImage(data)
+	})
+}
+
+// epubDropElement reports whether the element of the package document
+// is metadata to be dropped.
+func epubDropElement(parent, name string, tag []byte) bool {
+	if _, p := xmlPrefix(parent); p != "metadata" {
+		return false
+	}
+	_, local := xmlPrefix(name)
+	if local == "meta" {
+		return !epubMetaKeptRE.Match(tag)
+	}
+	return !epubKept[local]
+}
@@ -0,0 +1,636 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"sort"
+)
+
+// EXIF metadata is stored in an APP1 segment as a TIFF structure: a header
+// giving the byte order, then a chain of image file directories (IFDs),
+// each a list of tagged values. Some tags point to further IFDs holding
+// camera settings (the Exif IFD) and location (the GPS IFD).
+
+// An IFD identifies one of the directories of an EXIF block.
+type IFD int
+
+const (
+	IFD0       IFD = iota // The primary image.
+	IFD1                  // The thumbnail image.
+	ExifIFD               // Camera settings.
+	GPSIFD                // Location.
+	InteropIFD            // Interoperability.
+)
+
+// A Tag identifies an EXIF tag. Tag numbers are interpreted
+// relative to the directory that holds them.
+type Tag struct {
+	IFD IFD
+	ID  uint16
+}
+
+// Orientation is the EXIF tag that says which way up the image should
+// be displayed.
+var Orientation = Tag{IFD0, 0x0112}
+
+// GPSInfo is the EXIF tag that points to the GPS IFD.
+// Removing it removes all location data.
+var GPSInfo = Tag{IFD0, gpsPointer}
+
+// MakerNote is the EXIF tag holding the camera maker's private data,
+// which often includes serial numbers and firmware details.
+var MakerNote = Tag{ExifIFD, 0x927C}
+
+var dateTimeOriginal = Tag{ExifIFD, 0x9003}
+
+// Tags that point to other directories.
+const (
+	exifPointer    = 0x8769
+	gpsPointer     = 0x8825
+	interopPointer = 0xA005
+)
+
+var exifHeader = []byte("Exif\x00")
+
+// IsEXIF reports whether the payload of an APP1 segment holds EXIF data.
+func IsEXIF(payload []byte) bool {
+	return bytes.HasPrefix(payload, exifHeader) && len(payload) >= 6
+}
+
+// tiff is a parsed TIFF structure.
+type tiff struct {
+	data  []byte
+	order binary.ByteOrder
+}
+
+// An entry is one tagged value in an IFD.
+type entry struct {
+	tag   uint16
+	typ   uint16
+	count uint32
+	value []byte // The value, in the byte order of the TIFF data.
+	pos   int64  // The position of the value in the TIFF data.
+}
+
+// An ifd is a parsed image file directory.
+type ifd struct {
+	off     uint32
+	entries []entry
+	next    uint32 // Offset of the next IFD in the chain; 0 if none.
+}
+
+// end returns the offset of the end of the directory.
+func (d *ifd) end() int64 {
+	return int64(d.off) + 2 + 12*int64(len(d.entries)) + 4
+}
+
+var errTIFF = errors.New("malformed TIFF data")
+
+// typeSize gives the size in bytes of each TIFF data type.
+var typeSize = [...]int{
+	1:  1, // BYTE
+	2:  1, // ASCII
+	3:  2, // SHORT
+	4:  4, // LONG
+	5:  8, // RATIONAL
+	6:  1, // SBYTE
+	7:  1, // UNDEFINED
+	8:  2, // SSHORT
+	9:  4, // SLONG
+	10: 8, // SRATIONAL
+	11: 4, // FLOAT
+	12: 8, // DOUBLE
+	13: 4, // IFD
+}
+
+// size returns the size in bytes of the value of an entry with
+// the given type and count, or -1 if the type is unknown.
+func size(typ uint16, count uint32) int64 {
+	if int(typ) >= len(typeSize) || typeSize[typ] == 0 {
+		return -1
+	}
+	return int64(typeSize[typ]) * int64(count)
+}
+
+func parseTIFF(data []byte) (*tiff, error) {
+	if len(data) < 8 {
+		return nil, errTIFF
+	}
+	t := &tiff{data: data}
+	switch string(data[:4]) {
+	case "II*\x00":
+		t.order = binary.LittleEndian
+	case "MM\x00*":
+		t.order = binary.BigEndian
+	default:
+		return nil, errTIFF
+	}
+	return t, nil
+}
+
+// first returns the offset of the first IFD.
+func (t *tiff) first() uint32 {
+	return t.order.Uint32(t.data[4:])
+}
+
+// ifd parses the IFD at the given offset.
+func (t *tiff) ifd(off uint32) (*ifd, error) {
+	if int64(off)+2 > int64(len(t.data)) {
+		return nil, errTIFF
+	}
+	n := int64(t.order.Uint16(t.data[off:]))
+	end := int64(off) + 2 + 12*n
+	if end+4 > int64(len(t.data)) {
+		return nil, errTIFF
+	}
+	d := &ifd{
+		off:     off,
+		entries: make([]entry, 0, n),
+		next:    t.order.Uint32(t.data[end:]),
+	}
+	for p := int64(off) + 2; p < end; p += 12 {
+		e := entry{
+			tag:   t.order.Uint16(t.data[p:]),
+			typ:   t.order.Uint16(t.data[p+2:]),
+			count: t.order.Uint32(t.data[p+4:]),
+		}
+		size := size(e.typ, e.count)
+		switch {
+		case size < 0:
+			// Unknown type; we cannot know where the value is.
+		case size <= 4:
+			e.pos = p + 8
+			e.value = t.data[p+8 : p+8+size]
+		default:
+			voff := int64(t.order.Uint32(t.data[p+8:]))
+			if voff+size > int64(len(t.data)) {
+				return nil, errTIFF
+			}
+			e.pos = voff
+			e.value = t.data[voff : voff+size]
+		}
+		d.entries = append(d.entries, e)
+	}
+	return d, nil
+}
+
+// pointer returns the offset stored in the entry with the given tag,
+// or 0 if there is none.
+func (t *tiff) pointer(d *ifd, tag uint16) uint32 {
+	for _, e := range d.entries {
+		if e.tag == tag && len(e.value) == 4 {
+			return t.order.Uint32(e.value)
+		}
+	}
+	return 0
+}
+
+// walk calls f for each IFD in the EXIF structure, identifying it.
+// The thumbnail directory, IFD1, is the IFD following IFD0 in the chain.
+func (t *tiff) walk(f func(which IFD, d *ifd) error) error {
+	d0, err := t.ifd(t.first())
+	if err != nil {
+		return err
+	}
+	if err := f(IFD0, d0); err != nil {
+		return err
+	}
+	sub := func(which IFD, parent *ifd, tag uint16) (*ifd, error) {
+		off := t.pointer(parent, tag)
+		if off == 0 {
+			return nil, nil
+		}
+		d, err := t.ifd(off)
+		if err != nil {
+			return nil, err
+		}
+		return d, f(which, d)
+	}
+	exif, err := sub(ExifIFD, d0, exifPointer)
+	if err != nil {
+		return err
+	}
+	if exif != nil {
+		if _, err := sub(InteropIFD, exif, interopPointer); err != nil {
+			return err
+		}
+	}
+	if _, err := sub(GPSIFD, d0, gpsPointer); err != nil {
+		return err
+	}
+	if d0.next != 0 {
+		d1, err := t.ifd(d0.next)
+		if err != nil {
+			return err
+		}
+		return f(IFD1, d1)
+	}
+	return nil
+}
+
+// find returns the entry for the tag.
+func (t *tiff) find(tag Tag) (entry, bool) {
+	var found entry
+	ok := false
+	t.walk(func(which IFD, d *ifd) error {
+		for _, e := range d.entries {
+			if which == tag.IFD && e.tag == tag.ID {
+				found, ok = e, true
+			}
+		}
+		return nil
+	})
+	return found, ok
+}
+
+// ReduceEXIF returns the payload of a new, minimal EXIF APP1 segment
+// holding only those tags from the EXIF payload for which keep returns
+// true. The thumbnail is never kept. If no tags are kept, ReduceEXIF
+// returns nil.
+func ReduceEXIF(payload []byte, keep func(Tag) bool) ([]byte, error) {
+	if !IsEXIF(payload) {
+		return nil, fmt.Errorf("not EXIF data")
+	}
+	t, err := parseTIFF(payload[6:])
+	if err != nil {
+		return nil, err
+	}
+	kept := make(map[IFD][]entry)
+	err = t.walk(func(which IFD, d *ifd) error {
+		for _, e := range d.entries {
+			if isPointer(which, e.tag) || e.value == nil {
+				continue
+			}
+			if keep(Tag{which, e.tag}) {
+				kept[which] = append(kept[which], e)
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(kept[IFD0])+len(kept[ExifIFD])+len(kept[GPSIFD]) == 0 {
+		return nil, nil
+	}
+	return buildEXIF(t.order, kept[IFD0], kept[ExifIFD], kept[GPSIFD]), nil
+}
+
+// SyntheticEXIF returns the payload of a minimal EXIF APP1 segment that
+// records only the image dimensions and the name of the software, for
+// consumers that reject images without EXIF data.
+func SyntheticEXIF(width, height int, software string) []byte {
+	order := binary.BigEndian
+	long := func(v int) []byte {
+		b := make([]byte, 4)
+		order.PutUint32(b, uint32(v))
+		return b
+	}
+	ifd0 := []entry{
+		{tag: 0x0131, typ: 2, count: uint32(len(software) + 1), value: append([]byte(software), 0)}, // Software.
+	}
+	exif := []entry{
+		{tag: 0x9000, typ: 7, count: 4, value: []byte("0232")}, // ExifVersion.
+		{tag: 0xA002, typ: 4, count: 1, value: long(width)},    // ExifImageWidth.
+		{tag: 0xA003, typ: 4, count: 1, value: long(height)},   // ExifImageHeight.
+	}
+	return buildEXIF(order, ifd0, exif, nil)
+}
+
+// isPointer reports whether the tag in the IFD points to another IFD.
+func isPointer(which IFD, tag uint16) bool {
+	switch which {
+	case IFD0:
+		return tag == exifPointer || tag == gpsPointer
+	case ExifIFD:
+		return tag == interopPointer
+	}
+	return false
+}
+
+// buildEXIF returns an EXIF payload holding the entries for IFD0 and,
+// if there are any, an Exif IFD and GPS IFD.
+func buildEXIF(order binary.ByteOrder, ifd0, exif, gps []entry) []byte {
+	b := &tiffBuilder{order: order}
+	b.data = append(b.data, exifHeader...)
+	b.data = append(b.data, 0)
+	b.base = len(b.data)
+	if order == binary.LittleEndian {
+		b.data = append(b.data, "II*\x00"...)
+	} else {
+		b.data = append(b.data, "MM\x00*"...)
+	}
+	b.data = b.uint32(b.data, 8)
+	ifd0 = append([]entry(nil), ifd0...)
+	if len(exif) > 0 {
+		ifd0 = append(ifd0, entry{tag: exifPointer, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+	if len(gps) > 0 {
+		ifd0 = append(ifd0, entry{tag: gpsPointer, typ: 4, count: 1, value: make([]byte, 4)})
+	}
+	sortEntries(ifd0)
+	pos0 := b.ifd(ifd0)
+	for i, e := range ifd0 {
+		switch e.tag {
+		case exifPointer:
+			b.patch(pos0, i, b.ifd(exif))
+		case gpsPointer:
+			b.patch(pos0, i, b.ifd(gps))
+		}
+	}
+	return b.data
+}
+
+func sortEntries(entries []entry) {
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].tag < entries[j].tag
+	})
+}
+
+// tiffBuilder assembles TIFF data. Offsets within the TIFF data are
+// relative to base, the position of the byte-order mark.
+type tiffBuilder struct {
+	order binary.ByteOrder
+	data  []byte
+	base  int
+}
+
+func (b *tiffBuilder) uint16(data []byte, v uint16) []byte {
+	var buf [2]byte
+	b.order.PutUint16(buf[:], v)
+	return append(data, buf[:]...)
+}
+
+func (b *tiffBuilder) uint32(data []byte, v uint32) []byte {
+	var buf [4]byte
+	b.order.PutUint32(buf[:], v)
+	return append(data, buf[:]...)
+}
+
+// ifd appends a directory holding the entries, followed by their
+// out-of-line values, and returns its offset.
+func (b *tiffBuilder) ifd(entries []entry) uint32 {
+	entries = append([]entry(nil), entries...)
+	sortEntries(entries)
+	if len(b.data)%2 == 1 {
+		b.data = append(b.data, 0)
+	}
+	pos := len(b.data) - b.base
+	b.data = b.uint16(b.data, uint16(len(entries)))
+	valuePos := pos + 2 + 12*len(entries) + 4
+	var values []byte
+	for _, e := range entries {
+		b.data = b.uint16(b.data, e.tag)
+		b.data = b.uint16(b.data, e.typ)
+		b.data = b.uint32(b.data, e.count)
+		if len(e.value) <= 4 {
+			var v [4]byte
+			copy(v[:], e.value)
+			b.data = append(b.data, v[:]...)
+			continue
+		}
+		b.data = b.uint32(b.data, uint32(valuePos+len(values)))
+		values = append(values, e.value...)
+		if len(values)%2 == 1 {
+			values = append(values, 0)
+		}
+	}
+	b.data = b.uint32(b.data, 0) // No next IFD.
+	b.data = append(b.data, values...)
+	return uint32(pos)
+}
+
+// patch sets the value of the i'th entry of the IFD at pos to v.
+func (b *tiffBuilder) patch(pos uint32, i int, v uint32) {
+	b.order.PutUint32(b.data[b.base+int(pos)+2+12*i+8:], v)
+}
+
+// RemoveEXIF returns a copy of the EXIF payload with the tags for which
+// remove returns true deleted. Removing a tag that points to another
+// directory, such as GPSInfo, removes the whole directory. The data is
+// edited in place: the values of deleted tags are overwritten with zeros
+// and everything else keeps its position, so offsets within the data,
+// including any in maker notes, remain valid.
+func RemoveEXIF(payload []byte, remove func(Tag) bool) ([]byte, error) {
+	if !IsEXIF(payload) {
+		return nil, fmt.Errorf("not EXIF data")
+	}
+	data := append([]byte(nil), payload...)
+	t, err := parseTIFF(data[6:])
+	if err != nil {
+		return nil, err
+	}
+	if err := t.remove(IFD0, t.first(), remove); err != nil {
+		return nil, err
+	}
+	return t.trim(data)
+}
+
+// RemoveThumbnail returns a copy of the EXIF payload without the
+// thumbnail image and its directory, IFD1. As with RemoveEXIF, the
+// rest of the data is untouched.
+func RemoveThumbnail(payload []byte) ([]byte, error) {
+	if !IsEXIF(payload) {
+		return nil, fmt.Errorf("not EXIF data")
+	}
+	data := append([]byte(nil), payload...)
+	t, err := parseTIFF(data[6:])
+	if err != nil {
+		return nil, err
+	}
+	d0, err := t.ifd(t.first())
+	if err != nil {
+		return nil, err
+	}
+	if d0.next != 0 {
+		if err := t.zeroIFD(d0.next); err != nil {
+			return nil, err
+		}
+		// Unlink IFD1 from the chain.
+		t.order.PutUint32(t.data[d0.end()-4:], 0)
+	}
+	return t.trim(data)
+}
+
+// trim returns the EXIF payload holding t, truncated after the last
+// byte of the TIFF data still in use, so deleted data at the end
+// does not occupy space.
+func (t *tiff) trim(payload []byte) ([]byte, error) {
+	end := int64(8)
+	extend := func(e int64) {
+		if e > end {
+			end = e
+		}
+	}
+	err := t.walk(func(which IFD, d *ifd) error {
+		extend(d.end())
+		for _, e := range d.entries {
+			extend(e.pos + int64(len(e.value)))
+		}
+		for _, p := range dataPointers {
+			offsets, lengths := t.dataBlocks(d, p)
+			for i := 0; i < len(offsets) && i < len(lengths); i++ {
+				extend(int64(offsets[i]) + int64(lengths[i]))
+			}
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if end > int64(len(t.data)) {
+		return payload, nil
+	}
+	return payload[:len(payload)-len(t.data)+int(end)], nil
+}
+
+// subIFD returns the directory that the tag in the IFD points to, if any.
+func subIFD(which IFD, tag uint16) (IFD, bool) {
+	switch {
+	case which == IFD0 && tag == exifPointer:
+		return ExifIFD, true
+	case which == IFD0 && tag == gpsPointer:
+		return GPSIFD, true
+	case which == ExifIFD && tag == interopPointer:
+		return InteropIFD, true
+	}
+	return 0, false
+}
+
+// remove deletes the entries for which f returns true from the IFD
+// at off, which is the directory identified by which, and from the
+// directories it points to.
+func (t *tiff) remove(which IFD, off uint32, f func(Tag) bool) error {
+	d, err := t.ifd(off)
+	if err != nil {
+		return err
+	}
+	var kept []byte
+	for i, e := range d.entries {
+		p := int(off) + 2 + 12*i
+		sub, isSub := subIFD(which, e.tag)
+		isSub = isSub && len(e.value) == 4 // Otherwise it points nowhere.
+		if f(Tag{which, e.tag}) {
+			if isSub {
+				if err := t.zeroIFD(t.order.Uint32(e.value)); err != nil {
+					return err
+				}
+			} else {
+				t.zeroValue(d, e)
+			}
+			continue
+		}
+		if isSub {
+			if err := t.remove(sub, t.order.Uint32(e.value), f); err != nil {
+				return err
+			}
+		}
+		kept = append(kept, t.data[p:p+12]...)
+	}
+	if which == IFD0 && d.next != 0 {
+		if err := t.remove(IFD1, d.next, f); err != nil {
+			return err
+		}
+	}
+	if len(kept) == 12*len(d.entries) {
+		return nil
+	}
+	// Rewrite the directory with the surviving entries and zero the rest.
+	dir := t.data[off:d.end()]
+	t.order.PutUint16(dir, uint16(len(kept)/12))
+	n := 2 + copy(dir[2:], kept)
+	t.order.PutUint32(dir[n:], d.next)
+	zero(dir[n+4:])
+	return nil
+}
+
+// zeroValue overwrites with zeros the out-of-line value of the entry
+// in d, and any image data it locates.
+func (t *tiff) zeroValue(d *ifd, e entry) {
+	if size(e.typ, e.count) > 4 {
+		zero(e.value)
+	}
+	for _, p := range dataPointers {
+		if e.tag == p.offsets {
+			t.zeroData(d, p)
+		}
+	}
+}
+
+// zeroIFD overwrites with zeros the IFD at off and everything it refers to.
+func (t *tiff) zeroIFD(off uint32) error {
+	d, err := t.ifd(off)
+	if err != nil {
+		return err
+	}
+	for _, e := range d.entries {
+		t.zeroValue(d, e)
+	}
+	zero(t.data[off:d.end()])
+	return nil
+}
+
+// A dataPointer pairs the tags that give the offsets and lengths of
+// blocks of image data.
+type dataPointer struct {
+	offsets, lengths uint16
+}
+
+var dataPointers = []dataPointer{
+	{0x0111, 0x0117}, // StripOffsets, StripByteCounts.
+	{0x0144, 0x0145}, // TileOffsets, TileByteCounts.
+	{0x0201, 0x0202}, // JPEGInterchangeFormat, JPEGInterchangeFormatLength.
+}
+
+// dataBlocks returns the offsets and lengths of the blocks of data
+// located by p in d.
+func (t *tiff) dataBlocks(d *ifd, p dataPointer) (offsets, lengths []uint32) {
+	for _, e := range d.entries {
+		switch e.tag {
+		case p.offsets:
+			offsets = t.uints(e)
+		case p.lengths:
+			lengths = t.uints(e)
+		}
+	}
+	return offsets, lengths
+}
+
+// zeroData overwrites with zeros the blocks of data located by p in d.
+func (t *tiff) zeroData(d *ifd, p dataPointer) {
+	offsets, lengths := t.dataBlocks(d, p)
+	for i := 0; i < len(offsets) && i < len(lengths); i++ {
+		start, end := int64(offsets[i]), int64(offsets[i])+int64(lengths[i])
+		if end <= int64(len(t.data)) {
+			zero(t.data[start:end])
+		}
+	}
+}
+
+// uints returns the value of an entry holding SHORTs, LONGs, or IFD offsets.
+func (t *tiff) uints(e entry) []uint32 {
+	var u []uint32
+	switch e.typ {
+	case 3:
+		for i := 0; i+2 <= len(e.value); i += 2 {
+			u = append(u, uint32(t.order.Uint16(e.value[i:])))
+		}
+	case 4, 13:
+		for i := 0; i+4 <= len(e.value); i += 4 {
+			u = append(u, t.order.Uint32(e.value[i:]))
+		}
+	}
+	return u
+}
+
+func zero(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
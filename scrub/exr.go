@@ -0,0 +1,175 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// An OpenEXR file is a magic number and version, a header of named,
+// typed attributes (one header per part in a multi-part file), a table
+// of the file offsets of the chunks of pixel data, and the chunks.
+// Besides the attributes needed to read the pixels, the header can hold
+// anything: camera, lens, artist, and pipeline details. Scrubbing
+// keeps only the needed attributes and, since the header shrinks,
+// moves the offsets in the table to match.
+
+var exrMagic = []byte{0x76, 0x2f, 0x31, 0x01}
+
+// IsEXR reports whether the data begins with the OpenEXR magic number.
+func IsEXR(data []byte) bool {
+	return bytes.HasPrefix(data, exrMagic)
+}
+
+// exrMultipart is the version flag that marks a multi-part file.
+const exrMultipart = 0x1000
+
+// exrRequired lists the attributes needed to read the pixels and show
+// them as intended.
+var exrRequired = map[string]bool{
+	"channels":           true,
+	"compression":        true,
+	"dataWindow":         true,
+	"displayWindow":      true,
+	"lineOrder":          true,
+	"pixelAspectRatio":   true,
+	"screenWindowCenter": true,
+	"screenWindowWidth":  true,
+	"tiles":              true,
+	"chromaticities":     true,
+	"multiView":          true,
+	// Multi-part and deep files.
+	"name":               true,
+	"type":               true,
+	"version":            true,
+	"chunkCount":         true,
+	"maxSamplesPerPixel": true,
+	"view":               true,
+}
+
+// ScrubEXR reads an OpenEXR file from r and writes it to w with only
+// the header attributes needed to read its pixels, which are copied
+// unchanged. Like Scrub, it works in constant space.
+func ScrubEXR(r io.Reader, w io.Writer) error {
+	return scrubEXR(r, w, nil)
+}
+
+func scrubEXR(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	var hdr [8]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return noEOF(err)
+	}
+	if !IsEXR(hdr[:]) {
+		return fmt.Errorf("not an OpenEXR file")
+	}
+	var out bytes.Buffer
+	out.Write(hdr[:])
+	in := int64(len(hdr))
+	multipart := binary.LittleEndian.Uint32(hdr[4:])&exrMultipart != 0
+	for {
+		n, err := scrubEXRHeader(&out, br, removed)
+		in += n
+		if err != nil {
+			return err
+		}
+		if !multipart {
+			break
+		}
+		// The headers of a multi-part file end with an empty one.
+		c, err := br.Peek(1)
+		if err != nil {
+			return noEOF(err)
+		}
+		if c[0] == 0 {
+			br.ReadByte()
+			out.WriteByte(0)
+			in++
+			break
+		}
+	}
+	if _, err := w.Write(out.Bytes()); err != nil {
+		return err
+	}
+	// The offset tables hold one entry per chunk, but rather than work
+	// out how many chunks there are, which depends on the compression,
+	// tiling, and more, we use the fact that the chunks follow the
+	// tables: the tables end where the earliest chunk begins.
+	delta := int64(out.Len()) - in
+	first := int64(1<<63 - 1)
+	var buf [8]byte
+	for in < first {
+		if _, err := io.ReadFull(br, buf[:]); err != nil {
+			return noEOF(err)
+		}
+		in += 8
+		off := int64(binary.LittleEndian.Uint64(buf[:]))
+		if off < in {
+			return fmt.Errorf("bad OpenEXR chunk offset %d", off)
+		}
+		if off < first {
+			first = off
+		}
+		binary.LittleEndian.PutUint64(buf[:], uint64(off+delta))
+		if _, err := w.Write(buf[:]); err != nil {
+			return err
+		}
+	}
+	if in != first {
+		return fmt.Errorf("bad OpenEXR chunk offset %d", first)
+	}
+	_, err := io.Copy(w, br)
+	return err
+}
+
+// scrubEXRHeader copies to out the required attributes of the header
+// read from r, and the NUL byte that ends it. It returns the number
+// of bytes read.
+func scrubEXRHeader(out *bytes.Buffer, r *bufio.Reader, removed func(Removal)) (int64, error) {
+	var in int64
+	for {
+		name, err := r.ReadBytes(0)
+		in += int64(len(name))
+		if err != nil {
+			return in, noEOF(err)
+		}
+		if len(name) == 1 {
+			out.WriteByte(0)
+			return in, nil
+		}
+		typ, err := r.ReadBytes(0)
+		in += int64(len(typ))
+		if err != nil {
+			return in, noEOF(err)
+		}
+		var size [4]byte
+		if _, err := io.ReadFull(r, size[:]); err != nil {
+			return in, noEOF(err)
+		}
+		n := int64(binary.LittleEndian.Uint32(size[:]))
+		if len(name) > 256 || len(typ) > 256 {
+			return in, fmt.Errorf("bad OpenEXR attribute name")
+		}
+		in += 4 + n
+		if !exrRequired[string(name[:len(name)-1])] {
+			if _, err := io.CopyN(ioutil.Discard, r, n); err != nil {
+				return in, noEOF(err)
+			}
+			report(removed, string(name[:len(name)-1])+" attribute", int64(len(name)+len(typ))+4+n, 0)
+			continue
+		}
+		out.Write(name)
+		out.Write(typ)
+		out.Write(size[:])
+		if _, err := io.CopyN(out, r, n); err != nil {
+			return in, noEOF(err)
+		}
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"time"
+)
+
+// cameras lists common cameras, to make decoy EXIF data plausible.
+var cameras = []struct{ make, model string }{
+	{"Apple", "iPhone 13"},
+	{"Apple", "iPhone 14 Pro"},
+	{"samsung", "SM-G991B"},
+	{"Google", "Pixel 7"},
+	{"Canon", "Canon EOS 80D"},
+	{"NIKON CORPORATION", "NIKON D7500"},
+	{"SONY", "ILCE-7M3"},
+	{"FUJIFILM", "X-T30"},
+}
+
+const exifTime = "2006:01:02 15:04:05"
+
+// FakeEXIF returns the payload of an EXIF APP1 segment holding decoy
+// values chosen using rng: a common camera, plausible exposure settings,
+// and a capture time within a month of the original's. It holds no
+// location. The original EXIF payload, which may be nil, provides only
+// the time and the Orientation tag, which is kept so the image still
+// displays the right way up.
+func FakeEXIF(rng *rand.Rand, original []byte) []byte {
+	order := binary.BigEndian
+	short := func(v int) []byte {
+		b := make([]byte, 2)
+		order.PutUint16(b, uint16(v))
+		return b
+	}
+	rational := func(num, den int) []byte {
+		b := make([]byte, 8)
+		order.PutUint32(b, uint32(num))
+		order.PutUint32(b[4:], uint32(den))
+		return b
+	}
+	ascii := func(tag uint16, s string) entry {
+		return entry{tag: tag, typ: 2, count: uint32(len(s) + 1), value: append([]byte(s), 0)}
+	}
+	when := time.Now().AddDate(0, 0, -rng.Intn(3*365))
+	var orientation []byte
+	if IsEXIF(original) {
+		if t, err := parseTIFF(original[6:]); err == nil {
+			if e, ok := t.find(Orientation); ok {
+				if u := t.uints(e); len(u) == 1 {
+					orientation = short(int(u[0]))
+				}
+			}
+			if e, ok := t.find(dateTimeOriginal); ok && e.typ == 2 {
+				if t, err := time.Parse(exifTime, string(bytes.TrimRight(e.value, "\x00"))); err == nil {
+					when = t
+				}
+			}
+		}
+	}
+	// Fuzz the time by up to a month either way, at a random time of day.
+	when = when.AddDate(0, 0, rng.Intn(61)-30).Truncate(24 * time.Hour).Add(time.Duration(rng.Int63n(int64(24 * time.Hour))))
+	date := when.Format(exifTime)
+	camera := cameras[rng.Intn(len(cameras))]
+	ifd0 := []entry{
+		ascii(0x010F, camera.make),
+		ascii(0x0110, camera.model),
+		ascii(0x0132, date), // ModifyDate.
+	}
+	if orientation != nil {
+		ifd0 = append(ifd0, entry{tag: Orientation.ID, typ: 3, count: 1, value: orientation})
+	}
+	speeds := []int{30, 60, 125, 250, 500, 1000}
+	apertures := []int{18, 28, 40, 56, 80}
+	isos := []int{50, 100, 200, 400, 800, 1600}
+	exif := []entry{
+		{tag: 0x829A, typ: 5, count: 1, value: rational(1, speeds[rng.Intn(len(speeds))])},        // ExposureTime.
+		{tag: 0x829D, typ: 5, count: 1, value: rational(apertures[rng.Intn(len(apertures))], 10)}, // FNumber.
+		{tag: 0x8827, typ: 3, count: 1, value: short(isos[rng.Intn(len(isos))])},                  // ISO.
+		{tag: 0x9000, typ: 7, count: 4, value: []byte("0232")},                                    // ExifVersion.
+		ascii(dateTimeOriginal.ID, date),
+		ascii(0x9004, date),                                                  // CreateDate.
+		{tag: 0x920A, typ: 5, count: 1, value: rational(24+rng.Intn(50), 1)}, // FocalLength.
+	}
+	return buildEXIF(order, ifd0, exif, nil)
+}
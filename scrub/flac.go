@@ -0,0 +1,96 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// A FLAC file is a signature, a sequence of metadata blocks, and the
+// audio frames. Each block has a 4-byte header: a flag marking the last
+// block, the type, and the length. The VORBIS_COMMENT block holds the
+// tags and the PICTURE blocks the cover art. Padding, left for editors
+// to grow the tags into, can hold the remains of earlier ones, so it
+// goes too. Nothing refers to the position of the frames.
+
+var flacMagic = []byte("fLaC")
+
+// IsFLAC reports whether the data begins with the FLAC signature.
+func IsFLAC(data []byte) bool {
+	return bytes.HasPrefix(data, flacMagic)
+}
+
+// flacMetadata gives the names of the types of the metadata blocks
+// that are dropped.
+var flacMetadata = map[byte]string{
+	1: "PADDING",
+	4: "VORBIS_COMMENT",
+	6: "PICTURE",
+}
+
+// flacLast marks the last metadata block.
+const flacLast = 0x80
+
+// ScrubFLAC reads a FLAC file from r and writes it to w without its
+// comments, pictures, and padding. The audio is copied unchanged.
+func ScrubFLAC(r io.Reader, w io.Writer) error {
+	return scrubFLAC(r, w, nil)
+}
+
+func scrubFLAC(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	var sig [4]byte
+	if _, err := io.ReadFull(br, sig[:]); err != nil {
+		return noEOF(err)
+	}
+	if !IsFLAC(sig[:]) {
+		return fmt.Errorf("not a FLAC file")
+	}
+	// Hold the blocks that are kept, to mark the last one.
+	var blocks [][]byte
+	for {
+		var hdr [4]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return noEOF(err)
+		}
+		n := int64(hdr[1])<<16 | int64(hdr[2])<<8 | int64(hdr[3])
+		typ := hdr[0] &^ flacLast
+		if flacMetadata[typ] != "" {
+			if _, err := io.CopyN(ioutil.Discard, br, n); err != nil {
+				return noEOF(err)
+			}
+			report(removed, flacMetadata[typ]+" block", 4+n, 0)
+		} else {
+			b := make([]byte, 4+n)
+			copy(b, hdr[:])
+			b[0] = typ
+			if _, err := io.ReadFull(br, b[4:]); err != nil {
+				return noEOF(err)
+			}
+			blocks = append(blocks, b)
+		}
+		if hdr[0]&flacLast != 0 {
+			break
+		}
+	}
+	if len(blocks) == 0 {
+		return fmt.Errorf("FLAC file has no STREAMINFO block")
+	}
+	blocks[len(blocks)-1][0] |= flacLast
+	if _, err := w.Write(sig[:]); err != nil {
+		return err
+	}
+	for _, b := range blocks {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	_, err := io.Copy(w, br)
+	return err
+}
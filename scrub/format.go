@@ -0,0 +1,91 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import "io"
+
+// A Format is a file format that can be scrubbed.
+type Format struct {
+	Name string
+	// Match reports whether the data, the first MagicLen bytes of a
+	// file or all of a shorter one, begins like a file in the format.
+	Match func(data []byte) bool
+	// Scrub reads a file from r and writes it to w without its metadata.
+	Scrub func(r io.Reader, w io.Writer) error
+	// Report, if not nil, is like Scrub but also calls removed for
+	// each item of metadata it removes or rewrites.
+	Report func(r io.Reader, w io.Writer, removed func(Removal)) error
+}
+
+// A Removal describes an item of metadata, such as a chunk, box, tag,
+// or element, that a scrubber removed or rewrote.
+type Removal struct {
+	What    string // What the item is, such as "tEXt chunk".
+	Length  int64  // Its length in the input.
+	Written int64  // Its length in the output, 0 if it was removed.
+}
+
+// report calls removed, if it is not nil, with the description of an item.
+func report(removed func(Removal), what string, length, written int64) {
+	if removed != nil {
+		removed(Removal{what, length, written})
+	}
+}
+
+// MagicLen is the number of bytes Match functions are given. Most need
+// only a few, but an SVG image may begin with an XML declaration and
+// comments.
+const MagicLen = 1024
+
+// formats holds the registered formats, in the order they are tried.
+// Formats whose signatures are weak come last.
+var formats = []*Format{
+	{"JPEG", IsJPEG, Scrub, nil},
+	{"PNG", IsPNG, ScrubPNG, scrubPNG},
+	{"TIFF", IsTIFF, ScrubTIFF, scrubTIFF},
+	{"WebP", IsWebP, ScrubWebP, scrubWebP},
+	{"HEIF", IsHEIF, ScrubHEIF, scrubHEIF},
+	{"GIF", IsGIF, ScrubGIF, scrubGIF},
+	{"EXR", IsEXR, ScrubEXR, scrubEXR},
+	{"PDF", IsPDF, ScrubPDF, scrubPDF},
+	{"JXL", IsJXL, ScrubJXL, scrubJXL},
+	{"MP4", IsMP4, ScrubMP4, scrubMP4},
+	{"PSD", IsPSD, ScrubPSD, scrubPSD},
+	{"SVG", IsSVG, ScrubSVG, scrubSVG},
+	{"FLAC", IsFLAC, ScrubFLAC, scrubFLAC},
+	{"Ogg", IsOgg, ScrubOgg, scrubOgg},
+	{"WAV", IsWAV, ScrubWAV, scrubWAV},
+	{"AIFF", IsAIFF, ScrubAIFF, scrubAIFF},
+	{"Office", IsOOXML, ScrubOOXML, scrubOOXML},
+	{"EPUB", IsEPUB, ScrubEPUB, scrubEPUB},
+	{"ZIP", IsZIP, ScrubZIP, scrubZIP},
+	{"tar", IsTar, ScrubTar, scrubTar},
+	{"MKV", IsMKV, ScrubMKV, scrubMKV},
+	{"MP3", IsMP3, ScrubMP3, scrubMP3},
+	{"ICO", IsICO, ScrubICO, scrubICO},
+}
+
+// RegisterFormat registers a format for Detect to recognize. It is
+// tried after the formats already registered, including those built in.
+func RegisterFormat(f *Format) {
+	formats = append(formats, f)
+}
+
+// Detect returns the format of the file that data begins, or nil if it
+// is not recognized. The data should be the first MagicLen bytes of the
+// file, or all of a shorter one.
+func Detect(data []byte) *Format {
+	for _, f := range formats {
+		if f.Match(data) {
+			return f
+		}
+	}
+	return nil
+}
+
+// IsJPEG reports whether the data begins with a JPEG SOI marker.
+func IsJPEG(data []byte) bool {
+	return len(data) >= 2 && data[0] == 0xFF && data[1] == SOI
+}
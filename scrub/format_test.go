@@ -0,0 +1,756 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"hash/crc32"
+	"io"
+	"runtime"
+	"strings"
+	"testing"
+	"time"
+)
+
+// Each file built for these tests holds the word "secret" only in its
+// metadata, so none of it may be left once the file is scrubbed.
+
+var formatTests = []struct {
+	name    string
+	format  string
+	in      []byte
+	removed []string            // As described by describe, in order.
+	check   func([]byte) string // If not nil, checks the output; returns a complaint.
+}{
+	{"JPEG", "JPEG", []byte(soi + app1 + image + com + eoi), nil, nil},
+	{"PNG", "PNG", pngFile(pngText, pngTime), []string{"tEXt chunk", "tIME chunk"}, nil},
+	{"PNG clean", "PNG", pngFile(), nil, nil},
+	{"WebP", "WebP", webpFile(), []string{"EXIF chunk", "XMP chunk"}, checkVP8X},
+	{"GIF", "GIF", gifFile(), []string{"comment extension", `"XMP DataXMP" application extension`}, nil},
+	{"TIFF", "TIFF", tiffFile("secret"), []string{"ImageDescription tag"}, checkStrip},
+	{"TIFF clean", "TIFF", tiffFile(""), nil, checkStrip},
+	{"DNG", "TIFF", dngFile(), []string{"IFD0:0xC62F tag", "preview image"}, checkDNG},
+	{"HEIF", "HEIF", heifFile("heic", "hvc1"), []string{"Exif item"}, checkHEIF},
+	{"AVIF", "HEIF", heifFile("avif", "av01"), []string{"Exif item"}, checkHEIF},
+	{"EXR", "EXR", exrFile(), []string{"owner attribute"}, checkEXR},
+	{"JXL", "JXL", jxlFile(), []string{"Exif box", "compressed xml box"}, nil},
+	{"JXL codestream", "JXL", []byte("\xFF\x0Acodestream"), nil, nil},
+	{"MP4", "MP4", mp4File(), []string{"udta box", "XMP uuid box", "free box"}, checkMP4},
+	{"PSD", "PSD", psdFile(), []string{"image resource 0x0424", "image resource 0x0404"}, nil},
+	{"FLAC", "FLAC", flacFile(), []string{"VORBIS_COMMENT block", "PICTURE block", "PADDING block"}, checkFLAC},
+	{"WAV", "WAV", wavFile(), []string{"LIST chunk", "bext chunk"}, nil},
+	{"AIFF", "AIFF", aiffFile(), []string{"NAME chunk", "ANNO chunk"}, nil},
+	{"MP3", "MP3", mp3File(), []string{"ID3v2 tag", "ID3v1 tag", "APE tag"}, nil},
+	{"ICO", "ICO", icoFile(), []string{"tEXt chunk"}, checkICO},
+	{"MKV", "MKV", mkvFile(), []string{"Void element", "Info element rewritten", "Tags element", "Tags element rewritten in place"}, checkMKV},
+	{"Ogg", "Ogg", oggFile("ARTIST=secret"), []string{"comment header rewritten"}, checkOgg},
+	{"Ogg clean", "Ogg", oggFile(), nil, checkOgg},
+	{"SVG", "SVG", []byte(svgFile), []string{"comment", "xmlns:inkscape attribute", "inkscape:version attribute", "metadata element", "sodipodi:namedview element"}, nil},
+	{"PDF", "PDF", []byte(pdfFile), []string{"metadata stream", "Info dictionary", "embedded file parameters"}, nil},
+	{"ZIP", "ZIP", zipFile("photo.jpg", soi+app1+image+eoi, "notes.txt", "notes"), []string{"photo.jpg rewritten"}, nil},
+	{"ZIP dates only", "ZIP", zipFile("notes.txt", "notes"), nil, nil},
+	{"EPUB", "EPUB", zipFile("mimetype", "application/epub+zip", "content.opf", opfFile), []string{"content.opf rewritten"}, nil},
+	{"Office", "Office", zipFile("[Content_Types].xml", "<Types/>", "docProps/core.xml", "<cp:coreProperties>secret</cp:coreProperties>"), []string{"docProps/core.xml rewritten"}, nil},
+	{"tar", "tar", tarFile(false), []string{"photo.jpg rewritten"}, nil},
+	{"tar.gz", "tar", tarFile(true), []string{"photo.jpg rewritten"}, nil},
+}
+
+// describe returns the description of the removal used in formatTests.
+func describe(r Removal) string {
+	switch {
+	case r.Written == 0:
+		return r.What
+	case r.Written == r.Length:
+		return r.What + " rewritten in place"
+	}
+	return r.What + " rewritten"
+}
+
+// scrubFormat scrubs the file in the format, returning the output and
+// the removals reported.
+func scrubFormat(f *Format, in []byte) ([]byte, []string, error) {
+	var out bytes.Buffer
+	var removed []string
+	var err error
+	if f.Report == nil {
+		err = f.Scrub(bytes.NewReader(in), &out)
+	} else {
+		err = f.Report(bytes.NewReader(in), &out, func(r Removal) {
+			removed = append(removed, describe(r))
+		})
+	}
+	return out.Bytes(), removed, err
+}
+
+func TestFormats(t *testing.T) {
+	for _, test := range formatTests {
+		if len(test.removed) > 0 && !bytes.Contains(test.in, []byte("secret")) {
+			t.Errorf("%s: no metadata in input", test.name)
+		}
+		f := Detect(test.in)
+		if f == nil || f.Name != test.format {
+			t.Errorf("%s: not detected as %s", test.name, test.format)
+			continue
+		}
+		out, removed, err := scrubFormat(f, test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if strings.Join(removed, "; ") != strings.Join(test.removed, "; ") {
+			t.Errorf("%s: removed %q; want %q", test.name, removed, test.removed)
+		}
+		if bytes.Contains(out, []byte("secret")) {
+			t.Errorf("%s: metadata left in output %q", test.name, out)
+		}
+		if test.check != nil {
+			if msg := test.check(out); msg != "" {
+				t.Errorf("%s: %s", test.name, msg)
+			}
+		}
+		// Without a report, the output is the same.
+		var plain bytes.Buffer
+		if err := f.Scrub(bytes.NewReader(test.in), &plain); err != nil || !bytes.Equal(plain.Bytes(), out) {
+			t.Errorf("%s: Scrub and Report differ (error %v)", test.name, err)
+		}
+		// Scrubbing again changes nothing.
+		if Detect(out) != f {
+			t.Errorf("%s: output not detected as %s", test.name, test.format)
+			continue
+		}
+		again, removed, err := scrubFormat(f, out)
+		if err != nil || len(removed) > 0 || !bytes.Equal(again, out) {
+			t.Errorf("%s: scrubbing again removed %q (error %v)", test.name, removed, err)
+		}
+	}
+}
+
+// Each of these files holds a length far beyond its end.
+var hugeTests = []struct {
+	name string
+	in   []byte
+}{
+	{"MP4", []byte(newBox("ftyp", "isom", "\x00\x00\x00\x00", "isom") + be32(1<<30) + "moovshort")},
+	{"PSD", []byte("8BPS" + be16(1) + strings.Repeat("\x00", 20) + be32(0) + be32(1<<30-1) + "short")},
+	{"MKV", []byte(ebml(ebmlHeader, ebml(0x4282, "webm")) + ebmlID(mkvSegment) + "\x01\x00\x00\x00\x7F\xFF\xFF\xFF" + ebmlID(mkvInfo) + "\x08\x3F\xFF\xFF\xFF" + "short")},
+}
+
+func TestHugeLength(t *testing.T) {
+	for _, test := range hugeTests {
+		f := Detect(test.in)
+		if f == nil {
+			t.Errorf("%s: not detected", test.name)
+			continue
+		}
+		var before, after runtime.MemStats
+		runtime.ReadMemStats(&before)
+		_, _, err := scrubFormat(f, test.in)
+		runtime.ReadMemStats(&after)
+		if err != io.ErrUnexpectedEOF {
+			t.Errorf("%s: got error %v; want %v", test.name, err, io.ErrUnexpectedEOF)
+		}
+		if n := after.TotalAlloc - before.TotalAlloc; n > 1<<20 {
+			t.Errorf("%s: allocated %d bytes", test.name, n)
+		}
+	}
+}
+
+// Encoding helpers.
+
+func be16(v int) string { return string([]byte{byte(v >> 8), byte(v)}) }
+func be32(v int) string { return be16(v>>16) + be16(v) }
+func le16(v int) string { return string([]byte{byte(v), byte(v >> 8)}) }
+func le32(v int) string { return le16(v) + le16(v>>16) }
+
+// PNG.
+
+func pngChunk(typ, data string) string {
+	return be32(len(data)) + typ + data + be32(int(crc32.ChecksumIEEE([]byte(typ+data))))
+}
+
+var (
+	pngText = pngChunk("tEXt", "Author\x00secret")
+	pngTime = pngChunk("tIME", "\x07\xE0\x01\x01\x00\x00\x00")
+)
+
+// pngFile returns a one-pixel PNG image holding the extra chunks.
+func pngFile(extra ...string) []byte {
+	return []byte(string(pngHeader) +
+		pngChunk("IHDR", "\x00\x00\x00\x01\x00\x00\x00\x01\x08\x00\x00\x00\x00") +
+		strings.Join(extra, "") +
+		pngChunk("IDAT", "pixels") +
+		pngChunk("IEND", ""))
+}
+
+// RIFF and IFF: WebP, WAV, and AIFF.
+
+// chunk returns a chunk, padded to an even length, with its size in
+// the byte order.
+func chunk(order binary.ByteOrder, typ, data string) string {
+	var size [4]byte
+	order.PutUint32(size[:], uint32(len(data)))
+	if len(data)%2 == 1 {
+		data += "\x00"
+	}
+	return typ + string(size[:]) + data
+}
+
+// riff returns a file with the magic number and form type holding
+// the chunks.
+func riff(order binary.ByteOrder, magic, form string, chunks ...string) []byte {
+	body := form + strings.Join(chunks, "")
+	var size [4]byte
+	order.PutUint32(size[:], uint32(len(body)))
+	return []byte(magic + string(size[:]) + body)
+}
+
+func webpFile() []byte {
+	le := binary.LittleEndian
+	return riff(le, "RIFF", "WEBP",
+		chunk(le, "VP8X", "\x0C\x00\x00\x00\x00\x00\x00\x00\x00\x00"),
+		chunk(le, "VP8L", "pixels"),
+		chunk(le, "EXIF", "Exif\x00\x00secret"),
+		chunk(le, "XMP ", "<x:xmpmeta>secret</x:xmpmeta>"))
+}
+
+// checkVP8X checks that the VP8X chunk no longer announces metadata.
+func checkVP8X(out []byte) string {
+	if out[20]&(vp8xEXIF|vp8xXMP) != 0 {
+		return "VP8X chunk still flags metadata"
+	}
+	return ""
+}
+
+func wavFile() []byte {
+	le := binary.LittleEndian
+	return riff(le, "RIFF", "WAVE",
+		chunk(le, "fmt ", "\x01\x00\x01\x00\x44\xAC\x00\x00\x88\x58\x01\x00\x02\x00\x10\x00"),
+		chunk(le, "LIST", "INFOIART"+le32(6)+"secret"),
+		chunk(le, "bext", "secret"),
+		chunk(le, "LIST", "adtllabl"+le32(4)+"cue1"),
+		chunk(le, "data", "samples"))
+}
+
+func aiffFile() []byte {
+	be := binary.BigEndian
+	return riff(be, "FORM", "AIFF",
+		chunk(be, "COMM", "\x00\x01\x00\x00\x00\x04\x00\x10\x40\x0E\xAC\x44\x00\x00\x00\x00\x00\x00"),
+		chunk(be, "NAME", "secret"),
+		chunk(be, "ANNO", "secret!"),
+		chunk(be, "SSND", "\x00\x00\x00\x00\x00\x00\x00\x00samples"))
+}
+
+// GIF.
+
+func gifFile() []byte {
+	return []byte("GIF89a\x01\x00\x01\x00\x80\x00\x00" + "\x00\x00\x00\xFF\xFF\xFF" +
+		"\x21\xFE\x06secret\x00" +
+		"\x21\xFF\x0BNETSCAPE2.0\x03\x01\x00\x00\x00" +
+		"\x21\xFF\x0BXMP DataXMP\x06secret\x00" +
+		"\x21\xF9\x04\x00\x00\x00\x00\x00" +
+		"\x2C\x00\x00\x00\x00\x01\x00\x01\x00\x00" + "\x02\x02\x44\x01\x00" +
+		"\x3B")
+}
+
+// TIFF.
+
+// A tiffField is a field of a directory built by tiffDir.
+type tiffField struct {
+	tag, typ, count int
+	value           string // If longer than 4 bytes, stored after the directory.
+}
+
+// tiffDir returns a little-endian directory, with no successor, to be
+// stored at off.
+func tiffDir(off int, fields ...tiffField) string {
+	dir := le16(len(fields))
+	data := off + 2 + 12*len(fields) + 4
+	var extra string
+	for _, f := range fields {
+		v := f.value
+		if len(v) > 4 {
+			extra += v
+			v = le32(data)
+			data += len(f.value)
+		}
+		v += strings.Repeat("\x00", 4-len(v))
+		dir += le16(f.tag) + le16(f.typ) + le32(f.count) + v
+	}
+	return dir + le32(0) + extra
+}
+
+// tiffImage returns the fields of a one-pixel image of the subfile type
+// whose strip is at off.
+func tiffImage(subfile, off int) []tiffField {
+	return []tiffField{
+		{0x00FE, 4, 1, le32(subfile)}, // NewSubfileType.
+		{0x0100, 3, 1, le16(1)},       // ImageWidth.
+		{0x0101, 3, 1, le16(1)},       // ImageLength.
+		{0x0111, 4, 1, le32(off)},     // StripOffsets.
+		{0x0117, 4, 1, le32(4)},       // StripByteCounts.
+	}
+}
+
+// tiffFile returns a little-endian TIFF file holding a one-pixel image
+// in a strip and, if desc is not empty, an ImageDescription.
+func tiffFile(desc string) []byte {
+	fields := tiffImage(0, 8)
+	if desc != "" {
+		fields = append(fields, tiffField{0x010E, 2, len(desc) + 1, desc + "\x00"})
+	}
+	return []byte("II*\x00" + le32(12) + "PIXL" + tiffDir(12, fields...))
+}
+
+// checkStrip checks that the strip offset locates the image data.
+func checkStrip(out []byte) string {
+	t, err := parseTIFF(out)
+	if err != nil {
+		return err.Error()
+	}
+	d, err := t.ifd(t.first())
+	if err != nil {
+		return err.Error()
+	}
+	off := t.pointer(d, 0x0111)
+	if int(off)+4 > len(out) || string(out[off:off+4]) != "PIXL" {
+		return "strip offset does not locate the image data"
+	}
+	return ""
+}
+
+// dngFile returns a DNG file whose first directory holds a thumbnail
+// and locates two subsidiary directories, one holding the raw image
+// and the other a preview.
+func dngFile() []byte {
+	raw := tiffDir(20, tiffImage(0, 8)...)
+	preview := tiffDir(20+len(raw), tiffImage(1, 12)...)
+	ifd0 := 20 + len(raw) + len(preview)
+	fields := append(tiffImage(1, 16),
+		tiffField{0x010F, 2, 4, "Cam\x00"},                    // Make.
+		tiffField{0x014A, 4, 2, le32(20) + le32(20+len(raw))}, // SubIFDs.
+		tiffField{0xC612, 1, 4, "\x01\x04\x00\x00"},           // DNGVersion.
+		tiffField{0xC62F, 2, 7, "secret\x00"})                 // CameraSerialNumber.
+	return []byte("II*\x00" + le32(ifd0) + "RAWDPREVTHMB" + raw + preview + tiffDir(ifd0, fields...))
+}
+
+// checkDNG checks that the camera make and the raw image are kept, and
+// that the one subsidiary directory left locates the raw image.
+func checkDNG(out []byte) string {
+	if bytes.Contains(out, []byte("PREV")) || !bytes.Contains(out, []byte("Cam\x00")) {
+		return "wrong data kept"
+	}
+	t, err := parseTIFF(out)
+	if err != nil {
+		return err.Error()
+	}
+	d, err := t.ifd(t.first())
+	if err != nil {
+		return err.Error()
+	}
+	if d, err = t.ifd(t.pointer(d, 0x014A)); err != nil {
+		return err.Error()
+	}
+	off := t.pointer(d, 0x0111)
+	if int(off)+4 > len(out) || string(out[off:off+4]) != "RAWD" {
+		return "subsidiary directory does not locate the raw image"
+	}
+	return ""
+}
+
+// ISO media: HEIF, MP4, and JPEG XL.
+
+func newBox(typ string, contents ...string) string {
+	return string(appendBox(nil, typ, []byte(strings.Join(contents, ""))))
+}
+
+// heifFile returns a HEIF file of the brand with an image item of the
+// coding and an EXIF item that describes it, whose data follow one
+// another in mdat.
+func heifFile(brand, coding string) []byte {
+	ftyp := newBox("ftyp", brand, "\x00\x00\x00\x00", brand+"miaf")
+	infe := func(id int, typ string) string {
+		return newBox("infe", "\x02\x00\x00\x00", be16(id), "\x00\x00", typ, "\x00")
+	}
+	iinf := newBox("iinf", "\x00\x00\x00\x00", be16(2), infe(1, coding), infe(2, "Exif"))
+	iref := newBox("iref", "\x00\x00\x00\x00", newBox("cdsc", be16(2), be16(1), be16(1)))
+	meta := func(image, exif int) string {
+		iloc := newBox("iloc", "\x00\x00\x00\x00\x44\x00", be16(2),
+			be16(1), be16(0), be16(1), be32(image), be32(4),
+			be16(2), be16(0), be16(1), be32(exif), be32(6))
+		return newBox("meta", "\x00\x00\x00\x00", iinf, iref, iloc)
+	}
+	mdat := len(ftyp) + len(meta(0, 0)) + 8
+	return []byte(ftyp + meta(mdat, mdat+4) + newBox("mdat", "IMAGsecret"))
+}
+
+// checkHEIF checks that the one item left is located correctly.
+func checkHEIF(out []byte) string {
+	top, err := boxes(out, 0, int64(len(out)))
+	if err != nil {
+		return err.Error()
+	}
+	for _, b := range top {
+		if b.typ != "meta" {
+			continue
+		}
+		children, err := boxes(out, b.body+4, b.end)
+		if err != nil {
+			return err.Error()
+		}
+		for _, c := range children {
+			if c.typ != "iloc" {
+				continue
+			}
+			l, err := parseIloc(out[c.body:c.end])
+			if err != nil {
+				return err.Error()
+			}
+			if len(l.items) != 1 || len(l.items[0].extents) != 1 {
+				return "wrong items in iloc"
+			}
+			off := l.items[0].extents[0].offset
+			if off+4 > uint64(len(out)) || string(out[off:off+4]) != "IMAG" {
+				return "image item misplaced"
+			}
+			return ""
+		}
+	}
+	return "no iloc box"
+}
+
+// mp4File returns a movie whose chunk offset table locates the media
+// data after a moov box holding metadata.
+func mp4File() []byte {
+	ftyp := newBox("ftyp", "isom", "\x00\x00\x00\x00", "isom")
+	moov := func(off int) string {
+		stco := newBox("stco", "\x00\x00\x00\x00", be32(1), be32(off))
+		trak := newBox("trak", newBox("mdia", newBox("minf", newBox("stbl", stco))))
+		return newBox("moov", newBox("mvhd", "\x00\x00\x00\x00"), newBox("udta", "secret"), trak)
+	}
+	uuid := newBox("uuid", string(xmpUUID), "secret")
+	free := newBox("free", "\x00\x00\x00\x00")
+	mdat := len(ftyp) + len(moov(0)) + len(uuid) + len(free) + 8
+	return []byte(ftyp + moov(mdat) + uuid + free + newBox("mdat", "MEDIA"))
+}
+
+// checkMP4 checks that the chunk offset still locates the media data.
+func checkMP4(out []byte) string {
+	i := bytes.Index(out, []byte("stco"))
+	if i < 0 {
+		return "no stco box"
+	}
+	off := binary.BigEndian.Uint32(out[i+12:])
+	if int(off)+5 > len(out) || string(out[off:off+5]) != "MEDIA" {
+		return "chunk offset does not locate the media data"
+	}
+	return ""
+}
+
+func jxlFile() []byte {
+	return []byte(string(jxlContainer) +
+		newBox("ftyp", "jxl ", "\x00\x00\x00\x00", "jxl ") +
+		newBox("Exif", "\x00\x00\x00\x00", "secret") +
+		newBox("brob", "xml ", "secret") +
+		newBox("jxlc", "\xFF\x0Acodestream"))
+}
+
+// OpenEXR.
+
+// exrFile returns a single-part scan-line image with one chunk.
+func exrFile() []byte {
+	attr := func(name, typ, value string) string {
+		return name + "\x00" + typ + "\x00" + le32(len(value)) + value
+	}
+	hdr := string(exrMagic) + le32(2) +
+		attr("channels", "chlist", "Y\x00\x01\x00\x00\x00\x00\x00\x00\x00\x01\x00\x00\x00\x01\x00\x00\x00\x00") +
+		attr("owner", "string", "secret") +
+		attr("compression", "compression", "\x00") +
+		"\x00"
+	chunk := len(hdr) + 8
+	return []byte(hdr + le32(chunk) + le32(0) + "CHUNK")
+}
+
+// checkEXR checks that the offset table locates the chunk.
+func checkEXR(out []byte) string {
+	i := bytes.Index(out, []byte("\x00\x00CHUNK"))
+	if i < 8 {
+		return "no offset table"
+	}
+	i -= 6 // The offset is 8 bytes long; the chunk follows it.
+	off := binary.LittleEndian.Uint64(out[i:])
+	if int(off)+5 > len(out) || string(out[off:off+5]) != "CHUNK" {
+		return "chunk offset does not locate the chunk"
+	}
+	return ""
+}
+
+// Photoshop.
+
+func psdFile() []byte {
+	resource := func(id int, data string) string {
+		size := be32(len(data))
+		if len(data)%2 == 1 {
+			data += "\x00"
+		}
+		return "8BIM" + be16(id) + "\x00\x00" + size + data
+	}
+	res := resource(0x03ED, "\x00\x48\x00\x00\x00\x01\x00\x01\x00\x48\x00\x00\x00\x01\x00\x01") +
+		resource(0x0424, "secret") +
+		resource(0x0404, "secret!")
+	hdr := "8BPS" + be16(1) + "\x00\x00\x00\x00\x00\x00" + be16(3) + be32(1) + be32(1) + be16(8) + be16(3)
+	return []byte(hdr + be32(0) + be32(len(res)) + res + "\x00\x00\x00\x00" + "IMAGE")
+}
+
+// Audio.
+
+func flacFile() []byte {
+	block := func(typ byte, data string) string {
+		return string([]byte{typ, 0, 0, byte(len(data))}) + data
+	}
+	return []byte("fLaC" +
+		block(0, strings.Repeat("\x01", 34)) +
+		block(4, "\x03\x00\x00\x00enc\x01\x00\x00\x00\x0D\x00\x00\x00ARTIST=secret") +
+		block(6, "secret") +
+		block(1|flacLast, "\x00\x00\x00\x00") +
+		"\xFF\xF8frames")
+}
+
+// checkFLAC checks that STREAMINFO is now marked as the last block.
+func checkFLAC(out []byte) string {
+	if out[4] != flacLast {
+		return "STREAMINFO not marked last"
+	}
+	return ""
+}
+
+func mp3File() []byte {
+	id3v2 := "ID3\x04\x00\x00\x00\x00\x00\x06secret"
+	ape := "secret" + "APETAGEX" + le32(2000) + le32(6+32) + le32(1) + le32(0) + strings.Repeat("\x00", 8)
+	id3v1 := "TAG" + "secret" + strings.Repeat("\x00", 128-9)
+	return []byte(id3v2 + "\xFF\xFB\x90\x00audio" + ape + id3v1)
+}
+
+func oggFile(comments ...string) []byte {
+	tags := "OpusTags" + le32(3) + "enc" + le32(len(comments))
+	for _, c := range comments {
+		tags += le32(len(c)) + c
+	}
+	return []byte(newOggPage(oggFirst, 0, "OpusHead\x01\x02\x38\x01\x80\xBB\x00\x00\x00\x00\x00") +
+		newOggPage(0, 1, tags) +
+		newOggPage(0, 2, "audio"))
+}
+
+// newOggPage returns a page of stream 1 holding the packets.
+func newOggPage(flags byte, seq uint32, packets ...string) string {
+	var pkts [][]byte
+	for _, p := range packets {
+		pkts = append(pkts, []byte(p))
+	}
+	b := oggPages(1, seq, pkts)[0]
+	b[5] = flags
+	binary.LittleEndian.PutUint32(b[22:], 0)
+	binary.LittleEndian.PutUint32(b[22:], oggCRC(b))
+	return string(b)
+}
+
+// checkOgg checks that the pages are numbered in order and have
+// correct checksums.
+func checkOgg(out []byte) string {
+	r := bytes.NewReader(out)
+	for seq := uint32(0); r.Len() > 0; seq++ {
+		start := len(out) - r.Len()
+		p, err := readOggPage(r)
+		if err != nil {
+			return err.Error()
+		}
+		if p.seq() != seq {
+			return "pages out of order"
+		}
+		if !bytes.Equal(p.bytes(), out[start:len(out)-r.Len()]) {
+			return "bad page checksum"
+		}
+	}
+	return ""
+}
+
+// Matroska.
+
+// ebmlID returns the bytes of the element ID.
+func ebmlID(id uint32) string {
+	var b []byte
+	for s := 24; s >= 0; s -= 8 {
+		if c := byte(id >> uint(s)); c != 0 || len(b) > 0 {
+			b = append(b, c)
+		}
+	}
+	return string(b)
+}
+
+// ebml returns an element with a 1-byte size.
+func ebml(id uint32, body ...string) string {
+	b := strings.Join(body, "")
+	return ebmlID(id) + string([]byte{0x80 | byte(len(b))}) + b
+}
+
+// mkvFile returns a file whose segment holds metadata before and
+// after a cluster, which a seek head locates.
+func mkvFile() []byte {
+	void := ebml(ebmlVoid, "\x00\x00\x00\x00")
+	info := ebml(mkvInfo, ebml(0x2AD7B1, "\x0F\x42\x40"), ebml(0x7BA9, "secret"))
+	tags := ebml(mkvTags, ebml(0x7373, "secret"))
+	cluster := ebml(mkvCluster, ebml(0xE7, "\x00"), ebml(0xA3, "frame"))
+	seek := func(id uint32, pos int) string {
+		return ebml(mkvSeek, ebml(mkvSeekID, ebmlID(id)), ebml(mkvSeekPosition, be16(pos)))
+	}
+	head := func(info, tags, cluster int) string {
+		return ebml(mkvSeekHead, seek(mkvInfo, info), seek(mkvTags, tags), seek(mkvCluster, cluster))
+	}
+	p := len(head(0, 0, 0)) + len(void)
+	body := head(p, p+len(info), p+len(info)+len(tags)) + void + info + tags + cluster + tags
+	size := "\x01\x00\x00\x00\x00\x00\x00" + string([]byte{byte(len(body))})
+	return []byte(ebml(ebmlHeader, ebml(0x4282, "webm")) + ebmlID(mkvSegment) + size + body)
+}
+
+// checkMKV checks that the seek head still locates the Info element
+// and the cluster, and that the segment's size is right.
+func checkMKV(out []byte) string {
+	seg := bytes.Index(out, []byte(ebmlID(mkvSegment)))
+	if seg < 0 {
+		return "no segment"
+	}
+	start := seg + 4 + 8
+	if size := ebmlUint(out[seg+4+1 : start]); int(size) != len(out)-start {
+		return "wrong segment size"
+	}
+	for _, id := range []uint32{mkvInfo, mkvCluster} {
+		key := ebml(mkvSeekID, ebmlID(id)) + ebmlID(mkvSeekPosition) + "\x82"
+		i := bytes.Index(out, []byte(key))
+		if i < 0 {
+			return "no seek entry"
+		}
+		pos := start + int(binary.BigEndian.Uint16(out[i+len(key):]))
+		if !bytes.HasPrefix(out[pos:], []byte(ebmlID(id))) {
+			return "seek position does not locate the element"
+		}
+	}
+	return ""
+}
+
+// Icons.
+
+func icoFile() []byte {
+	png := pngFile(pngText)
+	return []byte("\x00\x00\x01\x00\x01\x00" +
+		"\x01\x01\x00\x00" + le16(1) + le16(32) + le32(len(png)) + le32(22) +
+		string(png))
+}
+
+// checkICO checks that the directory locates the scrubbed PNG image.
+func checkICO(out []byte) string {
+	size := binary.LittleEndian.Uint32(out[6+8:])
+	off := binary.LittleEndian.Uint32(out[6+12:])
+	if int(off+size) != len(out) || !IsPNG(out[off:]) {
+		return "directory does not locate the image"
+	}
+	return ""
+}
+
+// Documents.
+
+const svgFile = `<?xml version="1.0"?>
+<!-- Created with Inkscape by secret -->
+<svg xmlns="http://www.w3.org/2000/svg" xmlns:inkscape="http://www.inkscape.org/namespaces/inkscape" inkscape:version="secret" width="1" height="1">
+  <metadata><rdf:RDF>secret</rdf:RDF></metadata>
+  <sodipodi:namedview id="secret"/>
+  <rect width="1" height="1"/>
+</svg>
+`
+
+const pdfFile = `%PDF-1.4
+1 0 obj
+<</Type /Catalog /Pages 2 0 R /Metadata 4 0 R /AF [5 0 R]>>
+endobj
+2 0 obj
+<</Type /Pages /Kids [] /Count 0>>
+endobj
+3 0 obj
+<</Author (secret)>>
+endobj
+4 0 obj
+<</Type /Metadata /Subtype /XML /Length 6>>
+stream
+secret
+endstream
+endobj
+5 0 obj
+<</Type /EmbeddedFile /Params <</ModDate (D:secret)>> /Length 4>>
+stream
+file
+endstream
+endobj
+trailer
+<</Size 6 /Root 1 0 R /Info 3 0 R>>
+%%EOF
+`
+
+const opfFile = `<package xmlns="http://www.idpf.org/2007/opf" version="3.0">
+  <metadata xmlns:dc="http://purl.org/dc/elements/1.1/">
+    <dc:title>Title</dc:title>
+    <dc:creator>secret</dc:creator>
+    <meta property="dcterms:modified">2020-01-01T00:00:00Z</meta>
+  </metadata>
+</package>
+`
+
+// zipFile returns an archive holding the files, given as pairs of
+// names and contents, stored uncompressed.
+func zipFile(files ...string) []byte {
+	var b bytes.Buffer
+	zw := zip.NewWriter(&b)
+	for i := 0; i < len(files); i += 2 {
+		hdr := &zip.FileHeader{Name: files[i], Method: zip.Store, Comment: "secret"}
+		hdr.Modified = time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+		w, err := zw.CreateHeader(hdr)
+		if err != nil {
+			panic(err)
+		}
+		w.Write([]byte(files[i+1]))
+	}
+	zw.SetComment("secret")
+	zw.Close()
+	return b.Bytes()
+}
+
+// tarFile returns an archive holding an image, perhaps compressed.
+func tarFile(compress bool) []byte {
+	var b bytes.Buffer
+	var zw *gzip.Writer
+	var w io.Writer = &b
+	if compress {
+		zw = gzip.NewWriter(&b)
+		zw.Name = "secret"
+		w = zw
+	}
+	tw := tar.NewWriter(w)
+	img := soi + app1 + image + eoi
+	tw.WriteHeader(&tar.Header{
+		Typeflag: tar.TypeReg,
+		Name:     "photo.jpg",
+		Size:     int64(len(img)),
+		Mode:     0644,
+		Uname:    "secret",
+		ModTime:  time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC),
+	})
+	tw.Write([]byte(img))
+	tw.Close()
+	if zw != nil {
+		zw.Close()
+	}
+	return b.Bytes()
+}
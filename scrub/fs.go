@@ -0,0 +1,72 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"context"
+	"io"
+	"io/fs"
+	"path"
+	"strings"
+)
+
+// FS walks the file system fsys and scrubs every regular file whose
+// path satisfies match, writing the result to the WriteCloser returned
+// by create for that path. If match is nil, FS scrubs the files whose
+// names end in .jpg or .jpeg, ignoring case. FS stops at the first
+// error, which it returns as an *fs.PathError.
+func FS(fsys fs.FS, match func(path string) bool, create func(path string) (io.WriteCloser, error)) error {
+	return FSContext(context.Background(), fsys, match, create)
+}
+
+// FSContext is like FS but stops with the context's error
+// if the context is canceled or its deadline passes.
+func FSContext(ctx context.Context, fsys fs.FS, match func(path string) bool, create func(path string) (io.WriteCloser, error)) error {
+	if match == nil {
+		match = IsJPEGName
+	}
+	return fs.WalkDir(fsys, ".", func(path string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !d.Type().IsRegular() || !match(path) {
+			return nil
+		}
+		if err := scrubFS(ctx, fsys, path, create); err != nil {
+			return &fs.PathError{Op: "scrub", Path: path, Err: err}
+		}
+		return nil
+	})
+}
+
+func scrubFS(ctx context.Context, fsys fs.FS, path string, create func(path string) (io.WriteCloser, error)) error {
+	f, err := fsys.Open(path)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	w, err := create(path)
+	if err != nil {
+		return err
+	}
+	err = ScrubContext(ctx, f, w)
+	if cerr := w.Close(); err == nil {
+		err = cerr
+	}
+	return err
+}
+
+// IsJPEGName reports whether the file name has a JPEG extension,
+// .jpg or .jpeg in any case.
+func IsJPEGName(name string) bool {
+	switch strings.ToLower(path.Ext(name)) {
+	case ".jpg", ".jpeg":
+		return true
+	}
+	return false
+}
@@ -0,0 +1,159 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// A GIF file is a header and screen descriptor, then a sequence of
+// blocks: images and extensions, ending with a trailer byte. The data
+// of each is a series of sub-blocks, each a length byte and that many
+// bytes, ending with an empty one. Metadata is held in comment
+// extensions and in application extensions such as XMP; the one
+// application extension that matters, NETSCAPE2.0 (or its equivalent
+// ANIMEXTS1.0), says how many times an animation loops.
+
+// IsGIF reports whether the data begins with a GIF header.
+func IsGIF(data []byte) bool {
+	return len(data) >= 6 && (string(data[:6]) == "GIF87a" || string(data[:6]) == "GIF89a")
+}
+
+// GIF block introducers and extension labels.
+const (
+	gifExtension   = 0x21
+	gifImage       = 0x2C
+	gifTrailer     = 0x3B
+	gifComment     = 0xFE
+	gifApplication = 0xFF
+)
+
+// gifLooping lists the application extensions that control looping.
+var gifLooping = map[string]bool{
+	"NETSCAPE2.0": true,
+	"ANIMEXTS1.0": true,
+}
+
+// ScrubGIF reads a GIF file from r and writes it to w without its
+// comment extensions and application extensions other than the ones
+// that control looping. The images are copied unchanged. Like Scrub,
+// it works in constant space.
+func ScrubGIF(r io.Reader, w io.Writer) error {
+	return scrubGIF(r, w, nil)
+}
+
+func scrubGIF(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	var hdr [13]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return noEOF(err)
+	}
+	if !IsGIF(hdr[:]) {
+		return fmt.Errorf("not a GIF file")
+	}
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if err := copyColorTable(w, br, hdr[10]); err != nil {
+		return err
+	}
+	for {
+		c, err := br.ReadByte()
+		if err != nil {
+			return noEOF(err)
+		}
+		switch c {
+		case gifTrailer:
+			_, err := w.Write([]byte{c})
+			return err
+		case gifImage:
+			var desc [10]byte
+			desc[0] = c
+			if _, err := io.ReadFull(br, desc[1:]); err != nil {
+				return noEOF(err)
+			}
+			if _, err := w.Write(desc[:]); err != nil {
+				return err
+			}
+			if err := copyColorTable(w, br, desc[9]); err != nil {
+				return err
+			}
+			// The LZW code size, then the image data.
+			if _, err := io.CopyN(w, br, 1); err != nil {
+				return noEOF(err)
+			}
+			if _, err := copySubBlocks(w, br); err != nil {
+				return err
+			}
+		case gifExtension:
+			label, err := br.ReadByte()
+			if err != nil {
+				return noEOF(err)
+			}
+			keep := label != gifComment
+			what := "comment extension"
+			if label == gifApplication {
+				id, err := br.Peek(12)
+				if err != nil {
+					return noEOF(err)
+				}
+				keep = id[0] == 11 && gifLooping[string(id[1:12])]
+				what = fmt.Sprintf("%q application extension", id[1:12])
+			}
+			var out io.Writer = ioutil.Discard
+			if keep {
+				out = w
+				if _, err := w.Write([]byte{c, label}); err != nil {
+					return err
+				}
+			}
+			n, err := copySubBlocks(out, br)
+			if err != nil {
+				return err
+			}
+			if !keep {
+				report(removed, what, 2+n, 0)
+			}
+		default:
+			return fmt.Errorf("unknown GIF block type 0x%x", c)
+		}
+	}
+}
+
+// copyColorTable copies the color table that follows a screen or image
+// descriptor, if the descriptor's flags byte says there is one.
+func copyColorTable(w io.Writer, r io.Reader, flags byte) error {
+	if flags&0x80 == 0 {
+		return nil
+	}
+	_, err := io.CopyN(w, r, 3<<(flags&7+1))
+	return noEOF(err)
+}
+
+// copySubBlocks copies a series of sub-blocks, including the empty
+// one that ends it, and returns the number of bytes copied.
+func copySubBlocks(w io.Writer, r *bufio.Reader) (int64, error) {
+	var total int64
+	for {
+		n, err := r.ReadByte()
+		if err != nil {
+			return total, noEOF(err)
+		}
+		if _, err := w.Write([]byte{n}); err != nil {
+			return total, err
+		}
+		total++
+		if n == 0 {
+			return total, nil
+		}
+		if _, err := io.CopyN(w, r, int64(n)); err != nil {
+			return total, noEOF(err)
+		}
+		total += int64(n)
+	}
+}
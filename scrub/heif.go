@@ -0,0 +1,575 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+)
+
+// A HEIF file holds its images and its metadata as items, described by
+// boxes inside the top-level meta box: iinf gives each item's type, iloc
+// where its data is, usually in the mdat box, iref how items relate, and
+// iprp (in its ipma box) which properties apply to them. EXIF data is an
+// item of type Exif and XMP an item of MIME type application/rdf+xml.
+// Scrubbing removes those items from the boxes that mention them and
+// cuts their data out of mdat, moving the data of the other items.
+
+// heifBrands lists the brands that identify HEIF images. AVIF images
+// are HEIF images holding AV1 data, and have the same structure.
+var heifBrands = map[string]bool{
+	"avif": true,
+	"avis": true,
+	"heic": true,
+	"heix": true,
+	"heim": true,
+	"heis": true,
+	"hevc": true,
+	"hevx": true,
+	"mif1": true,
+	"msf1": true,
+}
+
+// IsHEIF reports whether the data begins with the file type box
+// of a HEIF image, such as the HEIC files written by phones or an AVIF.
+func IsHEIF(data []byte) bool {
+	for _, b := range ftyp(data) {
+		if heifBrands[b] {
+			return true
+		}
+	}
+	return false
+}
+
+// A cut is a range of the input left out of the output.
+type cut struct {
+	start, end int64
+}
+
+// heif holds the state of scrubbing a HEIF file.
+type heif struct {
+	data    []byte
+	meta    box
+	removed map[uint32]string // The items to remove, and their types.
+	cuts    []cut             // Their data in mdat, sorted.
+	idat    []cut             // Their data in idat, relative to its contents.
+	delta   int64             // The change in the size of the meta box.
+	notify  func(Removal)     // Told of each item removed.
+}
+
+// ScrubHEIF reads a HEIF or AVIF file from r and writes it to w without
+// its EXIF and XMP items. The image data is copied unchanged. It holds
+// the whole file in memory.
+func ScrubHEIF(r io.Reader, w io.Writer) error {
+	return scrubHEIF(r, w, nil)
+}
+
+func scrubHEIF(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	top, err := boxes(data, 0, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	h := &heif{data: data, notify: removed}
+	found := false
+	for _, b := range top {
+		if b.typ == "meta" {
+			h.meta, found = b, true
+			break
+		}
+	}
+	if !found {
+		_, err := w.Write(data)
+		return err
+	}
+	children, err := h.children(h.meta)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		if c.typ == "iinf" {
+			if h.removed, err = metadataItems(data[c.body:c.end]); err != nil {
+				return err
+			}
+		}
+	}
+	if len(h.removed) == 0 {
+		_, err := w.Write(data)
+		return err
+	}
+	for _, c := range children {
+		if c.typ == "iloc" {
+			if err := h.locate(top, data[c.body:c.end]); err != nil {
+				return err
+			}
+		}
+	}
+	// The offsets in iloc depend on the size of meta, which does not
+	// depend on them, so build it once to learn its size.
+	meta, err := h.buildMeta(children)
+	if err != nil {
+		return err
+	}
+	h.delta = int64(len(meta)) - (h.meta.end - h.meta.start)
+	if meta, err = h.buildMeta(children); err != nil {
+		return err
+	}
+	var out []byte
+	for _, b := range top {
+		switch {
+		case b.start == h.meta.start:
+			out = append(out, meta...)
+		case b.typ == "mdat":
+			out = h.appendMdat(out, b)
+		default:
+			out = append(out, data[b.start:b.end]...)
+		}
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// children returns the boxes inside the meta box b, which begins
+// with a version and flags.
+func (h *heif) children(b box) ([]box, error) {
+	if b.body+4 > b.end {
+		return nil, errBMFF
+	}
+	return boxes(h.data, b.body+4, b.end)
+}
+
+// metadataItems returns the IDs of the EXIF and XMP items listed in the
+// contents of an iinf box, with their types, "Exif" or "XMP".
+func metadataItems(iinf []byte) (map[uint32]string, error) {
+	c := &cursor{b: iinf}
+	if c.uint(4)>>24 == 0 {
+		c.uint(2)
+	} else {
+		c.uint(4)
+	}
+	if c.bad {
+		return nil, errBMFF
+	}
+	infes, err := boxes(iinf, int64(c.p), int64(len(iinf)))
+	if err != nil {
+		return nil, err
+	}
+	removed := make(map[uint32]string)
+	for _, b := range infes {
+		id, typ, contentType := parseInfe(iinf[b.body:b.end])
+		switch {
+		case typ == "Exif":
+			removed[id] = "Exif"
+		case typ == "mime" && contentType == "application/rdf+xml":
+			removed[id] = "XMP"
+		}
+	}
+	return removed, nil
+}
+
+// parseInfe returns the ID, type, and, for MIME items, content type of
+// the item described by the contents of an infe box. Only versions 2
+// and 3 give item types; for older ones it returns an empty type.
+func parseInfe(infe []byte) (id uint32, typ, contentType string) {
+	c := &cursor{b: infe}
+	switch c.uint(4) >> 24 {
+	case 2:
+		id = uint32(c.uint(2))
+	case 3:
+		id = uint32(c.uint(4))
+	default:
+		return 0, "", ""
+	}
+	c.uint(2) // Protection index.
+	typ = string(c.next(4))
+	c.cstring() // Name.
+	if typ == "mime" {
+		contentType = c.cstring()
+	}
+	if c.bad {
+		return 0, "", ""
+	}
+	return id, typ, contentType
+}
+
+// An iloc is the parsed contents of an iloc box.
+type iloc struct {
+	version    byte
+	flags      []byte
+	offsetSize int
+	lengthSize int
+	baseSize   int
+	indexSize  int
+	items      []ilocItem
+}
+
+type ilocItem struct {
+	id      uint32
+	method  uint16 // The construction method, in the low 4 bits.
+	dataRef uint16
+	base    uint64
+	extents []ilocExtent
+}
+
+type ilocExtent struct {
+	index, offset, length uint64
+}
+
+func parseIloc(b []byte) (*iloc, error) {
+	c := &cursor{b: b}
+	l := &iloc{}
+	vf := c.next(4)
+	l.version, l.flags = vf[0], vf[1:]
+	if l.version > 2 {
+		return nil, fmt.Errorf("unknown iloc version %d", l.version)
+	}
+	sizes := c.next(2)
+	l.offsetSize, l.lengthSize = int(sizes[0]>>4), int(sizes[0]&15)
+	l.baseSize = int(sizes[1] >> 4)
+	if l.version > 0 {
+		l.indexSize = int(sizes[1] & 15)
+	}
+	for _, n := range []int{l.offsetSize, l.lengthSize, l.baseSize, l.indexSize} {
+		if n != 0 && n != 4 && n != 8 {
+			return nil, errBMFF
+		}
+	}
+	idSize := 2
+	if l.version == 2 {
+		idSize = 4
+	}
+	count := c.uint(idSize)
+	for i := uint64(0); i < count && !c.bad; i++ {
+		var it ilocItem
+		it.id = uint32(c.uint(idSize))
+		if l.version > 0 {
+			it.method = uint16(c.uint(2))
+		}
+		it.dataRef = uint16(c.uint(2))
+		it.base = c.uint(l.baseSize)
+		n := c.uint(2)
+		for j := uint64(0); j < n && !c.bad; j++ {
+			var e ilocExtent
+			e.index = c.uint(l.indexSize)
+			e.offset = c.uint(l.offsetSize)
+			e.length = c.uint(l.lengthSize)
+			it.extents = append(it.extents, e)
+		}
+		l.items = append(l.items, it)
+	}
+	if c.bad {
+		return nil, errBMFF
+	}
+	return l, nil
+}
+
+// locate records where the data of the items being removed is,
+// given the contents of the iloc box and the top-level boxes.
+func (h *heif) locate(top []box, b []byte) error {
+	l, err := parseIloc(b)
+	if err != nil {
+		return err
+	}
+	for _, it := range l.items {
+		if h.removed[it.id] == "" {
+			continue
+		}
+		n := int64(0)
+		for _, e := range it.extents {
+			n += int64(e.length)
+		}
+		report(h.notify, h.removed[it.id]+" item", n, 0)
+		if it.dataRef != 0 {
+			continue
+		}
+		for _, e := range it.extents {
+			start := int64(it.base + e.offset)
+			end := start + int64(e.length)
+			if e.length == 0 || start < 0 || end < start {
+				continue
+			}
+			switch it.method & 15 {
+			case 0: // In the file; we only cut data out of mdat.
+				for _, b := range top {
+					if b.typ == "mdat" && b.body <= start && end <= b.end {
+						h.cuts = append(h.cuts, cut{start, end})
+					}
+				}
+			case 1: // In idat.
+				h.idat = append(h.idat, cut{start, end})
+			}
+		}
+	}
+	sort.Slice(h.cuts, func(i, j int) bool { return h.cuts[i].start < h.cuts[j].start })
+	// Items may share data; merge overlapping cuts.
+	var merged []cut
+	for _, c := range h.cuts {
+		if n := len(merged); n > 0 && c.start <= merged[n-1].end {
+			if c.end > merged[n-1].end {
+				merged[n-1].end = c.end
+			}
+			continue
+		}
+		merged = append(merged, c)
+	}
+	h.cuts = merged
+	return nil
+}
+
+// pos returns the position in the output of the byte at off in the input.
+func (h *heif) pos(off int64) int64 {
+	p := off
+	for _, c := range h.cuts {
+		switch {
+		case c.end <= off:
+			p -= c.end - c.start
+		case c.start <= off:
+			p -= off - c.start
+		}
+	}
+	if off >= h.meta.end {
+		p += h.delta
+	}
+	return p
+}
+
+// buildMeta returns the new meta box, given its children.
+func (h *heif) buildMeta(children []box) ([]byte, error) {
+	body := append([]byte(nil), h.data[h.meta.body:h.meta.body+4]...)
+	for _, c := range children {
+		contents := h.data[c.body:c.end]
+		var err error
+		switch c.typ {
+		case "iinf":
+			contents, err = h.iinf(contents)
+		case "iloc":
+			contents, err = h.iloc(contents)
+		case "iref":
+			contents, err = h.iref(contents)
+		case "iprp":
+			contents, err = h.iprp(contents)
+		case "idat":
+			contents = append([]byte(nil), contents...)
+			for _, z := range h.idat {
+				if z.end <= int64(len(contents)) {
+					zero(contents[z.start:z.end])
+				}
+			}
+		default:
+			body = append(body, h.data[c.start:c.end]...)
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		body = appendBox(body, c.typ, contents)
+	}
+	return appendBox(nil, "meta", body), nil
+}
+
+// iinf returns the contents of the iinf box without the removed items.
+func (h *heif) iinf(b []byte) ([]byte, error) {
+	c := &cursor{b: b}
+	vf := c.next(4)
+	countSize := 4
+	if vf[0] == 0 {
+		countSize = 2
+	}
+	c.uint(countSize)
+	if c.bad {
+		return nil, errBMFF
+	}
+	infes, err := boxes(b, int64(c.p), int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	var list []byte
+	n := 0
+	for _, e := range infes {
+		id, typ, _ := parseInfe(b[e.body:e.end])
+		if typ != "" && h.removed[id] != "" {
+			continue
+		}
+		list = append(list, b[e.start:e.end]...)
+		n++
+	}
+	out := appendUintN(append([]byte(nil), vf...), countSize, uint64(n))
+	return append(out, list...), nil
+}
+
+// iloc returns the contents of the iloc box without the removed items,
+// and with the offsets of the others moved to where their data now is.
+func (h *heif) iloc(b []byte) ([]byte, error) {
+	l, err := parseIloc(b)
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte{l.version}, l.flags...)
+	out = append(out, byte(l.offsetSize<<4|l.lengthSize), byte(l.baseSize<<4|l.indexSize))
+	idSize := 2
+	if l.version == 2 {
+		idSize = 4
+	}
+	var items []ilocItem
+	for _, it := range l.items {
+		if h.removed[it.id] == "" {
+			items = append(items, it)
+		}
+	}
+	out = appendUintN(out, idSize, uint64(len(items)))
+	for _, it := range items {
+		inFile := it.method&15 == 0 && it.dataRef == 0
+		base := it.base
+		if inFile && l.baseSize > 0 {
+			base = uint64(h.pos(int64(it.base)))
+		}
+		out = appendUintN(out, idSize, uint64(it.id))
+		if l.version > 0 {
+			out = appendUintN(out, 2, uint64(it.method))
+		}
+		out = appendUintN(out, 2, uint64(it.dataRef))
+		out = appendUintN(out, l.baseSize, base)
+		out = appendUintN(out, 2, uint64(len(it.extents)))
+		for _, e := range it.extents {
+			offset := e.offset
+			if inFile && l.offsetSize > 0 {
+				offset = uint64(h.pos(int64(it.base+e.offset))) - base
+			}
+			out = appendUintN(out, l.indexSize, e.index)
+			out = appendUintN(out, l.offsetSize, offset)
+			out = appendUintN(out, l.lengthSize, e.length)
+		}
+	}
+	return out, nil
+}
+
+// iref returns the contents of the iref box without references
+// from or to the removed items.
+func (h *heif) iref(b []byte) ([]byte, error) {
+	if len(b) < 4 {
+		return nil, errBMFF
+	}
+	idSize := 2
+	if b[0] == 1 {
+		idSize = 4
+	}
+	refs, err := boxes(b, 4, int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), b[:4]...)
+	for _, r := range refs {
+		c := &cursor{b: b[r.body:r.end]}
+		from := uint32(c.uint(idSize))
+		n := c.uint(2)
+		var to []uint32
+		for i := uint64(0); i < n; i++ {
+			if id := uint32(c.uint(idSize)); h.removed[id] == "" {
+				to = append(to, id)
+			}
+		}
+		if c.bad {
+			return nil, errBMFF
+		}
+		if h.removed[from] != "" || len(to) == 0 {
+			continue
+		}
+		ref := appendUintN(nil, idSize, uint64(from))
+		ref = appendUintN(ref, 2, uint64(len(to)))
+		for _, id := range to {
+			ref = appendUintN(ref, idSize, uint64(id))
+		}
+		out = appendBox(out, r.typ, ref)
+	}
+	return out, nil
+}
+
+// iprp returns the contents of the iprp box, with the associations of
+// the removed items deleted from its ipma boxes.
+func (h *heif) iprp(b []byte) ([]byte, error) {
+	children, err := boxes(b, 0, int64(len(b)))
+	if err != nil {
+		return nil, err
+	}
+	var out []byte
+	for _, c := range children {
+		if c.typ != "ipma" {
+			out = append(out, b[c.start:c.end]...)
+			continue
+		}
+		ipma, err := h.ipma(b[c.body:c.end])
+		if err != nil {
+			return nil, err
+		}
+		out = appendBox(out, "ipma", ipma)
+	}
+	return out, nil
+}
+
+// ipma returns the contents of an ipma box without the removed items.
+func (h *heif) ipma(b []byte) ([]byte, error) {
+	c := &cursor{b: b}
+	vf := c.next(4)
+	idSize, assocSize := 2, 1
+	if vf[0] >= 1 {
+		idSize = 4
+	}
+	if vf[3]&1 != 0 {
+		assocSize = 2
+	}
+	count := c.uint(4)
+	var list []byte
+	n := 0
+	for i := uint64(0); i < count && !c.bad; i++ {
+		start := c.p
+		id := uint32(c.uint(idSize))
+		c.next(assocSize * int(c.next(1)[0]))
+		if h.removed[id] == "" && !c.bad {
+			list = append(list, b[start:c.p]...)
+			n++
+		}
+	}
+	if c.bad {
+		return nil, errBMFF
+	}
+	out := append([]byte(nil), vf...)
+	out = appendUintN(out, 4, uint64(n))
+	return append(out, list...), nil
+}
+
+// appendMdat appends to out the mdat box b without the data cut from it.
+func (h *heif) appendMdat(out []byte, b box) []byte {
+	hdr := append([]byte(nil), h.data[b.start:b.body]...)
+	body := h.data[b.body:b.end]
+	var kept []byte
+	p := b.body
+	for _, c := range h.cuts {
+		if c.start >= b.body && c.end <= b.end {
+			kept = append(kept, h.data[p:c.start]...)
+			p = c.end
+		}
+	}
+	if len(kept) == 0 && p == b.body {
+		kept = body
+	} else {
+		kept = append(kept, h.data[p:b.end]...)
+	}
+	size := uint64(len(hdr) + len(kept))
+	switch {
+	case binary.BigEndian.Uint32(hdr) == 0:
+		// Extends to the end of the file.
+	case len(hdr) == 16:
+		binary.BigEndian.PutUint64(hdr[8:], size)
+	default:
+		binary.BigEndian.PutUint32(hdr, uint32(size))
+	}
+	return append(append(out, hdr...), kept...)
+}
@@ -0,0 +1,117 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// Middleware returns a handler that scrubs JPEG images in the request
+// body before passing the request on to next. A body with content type
+// image/jpeg is scrubbed as a whole. In a multipart body, such as a form
+// upload, every part that is a JPEG, by declared type or by content, is
+// scrubbed and the other parts are passed through untouched. The body is
+// scrubbed as the wrapped handler reads it; if the image is malformed,
+// the handler sees an error from the read. Scrubbing stops if the
+// request's context is canceled.
+func Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Body == nil || r.Body == http.NoBody {
+			next.ServeHTTP(w, r)
+			return
+		}
+		mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		body := r.Body
+		ctx := r.Context()
+		switch {
+		case err != nil:
+			next.ServeHTTP(w, r)
+			return
+		case isJPEGType(mediaType):
+			r.Body = pipe(func(w io.Writer) error {
+				return ScrubContext(ctx, body, w)
+			})
+		case strings.HasPrefix(mediaType, "multipart/") && params["boundary"] != "":
+			r.Body = pipe(func(w io.Writer) error {
+				return scrubMultipart(ctx, body, w, params["boundary"])
+			})
+		default:
+			next.ServeHTTP(w, r)
+			return
+		}
+		defer r.Body.Close()
+		r.ContentLength = -1
+		r.Header.Del("Content-Length")
+		next.ServeHTTP(w, r)
+	})
+}
+
+// isJPEGType reports whether the media type is that of a JPEG image.
+func isJPEGType(mediaType string) bool {
+	return mediaType == "image/jpeg" || mediaType == "image/pjpeg"
+}
+
+// pipe returns a reader that delivers what f writes.
+func pipe(f func(w io.Writer) error) io.ReadCloser {
+	pr, pw := io.Pipe()
+	go func() {
+		pw.CloseWithError(f(pw))
+	}()
+	return pr
+}
+
+// scrubMultipart copies the multipart body in r to w,
+// scrubbing each part that holds a JPEG.
+func scrubMultipart(ctx context.Context, r io.Reader, w io.Writer, boundary string) error {
+	mr := multipart.NewReader(r, boundary)
+	mw := multipart.NewWriter(w)
+	if err := mw.SetBoundary(boundary); err != nil {
+		return err
+	}
+	for {
+		p, err := mr.NextRawPart()
+		if err == io.EOF {
+			return mw.Close()
+		}
+		if err != nil {
+			return err
+		}
+		br := bufio.NewReader(p)
+		jpeg := isJPEGPart(p.Header, br)
+		if jpeg {
+			p.Header.Del("Content-Length")
+		}
+		pw, err := mw.CreatePart(p.Header)
+		if err != nil {
+			return err
+		}
+		if jpeg {
+			err = ScrubContext(ctx, br, pw)
+		} else {
+			_, err = io.Copy(pw, br)
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// isJPEGPart reports whether the multipart part with the given header,
+// whose contents are buffered in br, holds a JPEG image.
+func isJPEGPart(h textproto.MIMEHeader, br *bufio.Reader) bool {
+	if mediaType, _, err := mime.ParseMediaType(h.Get("Content-Type")); err == nil && isJPEGType(mediaType) {
+		return true
+	}
+	magic, _ := br.Peek(3)
+	return bytes.Equal(magic, []byte{0xFF, SOI, 0xFF})
+}
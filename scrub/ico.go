@@ -0,0 +1,81 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// An icon or cursor file is a directory of images, each entry giving
+// an image's size and depth and the location of its data, followed by
+// the images, each a bitmap without its file header or a complete PNG
+// file. Bitmaps hold no metadata; the PNG files are scrubbed, and the
+// directory rebuilt to locate them.
+
+// IsICO reports whether the data begins like an icon or cursor file.
+// Since the header is mostly zeros, the first directory entry is
+// checked too.
+func IsICO(data []byte) bool {
+	if len(data) < 6+16 {
+		return false
+	}
+	typ := binary.LittleEndian.Uint16(data[2:])
+	count := binary.LittleEndian.Uint16(data[4:])
+	return data[0] == 0 && data[1] == 0 && (typ == 1 || typ == 2) && count > 0 && data[6+3] == 0
+}
+
+var errICO = errors.New("malformed icon file")
+
+// ScrubICO reads an icon or cursor file from r and writes it to w with
+// the PNG images in it scrubbed. It holds the whole file in memory.
+func ScrubICO(r io.Reader, w io.Writer) error {
+	return scrubICO(r, w, nil)
+}
+
+func scrubICO(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !IsICO(data) {
+		return fmt.Errorf("not an icon file")
+	}
+	count := int(binary.LittleEndian.Uint16(data[4:]))
+	dirEnd := 6 + 16*count
+	if dirEnd > len(data) {
+		return errICO
+	}
+	dir := append([]byte(nil), data[:dirEnd]...)
+	var images bytes.Buffer
+	for i := 0; i < count; i++ {
+		e := dir[6+16*i:]
+		size := int64(binary.LittleEndian.Uint32(e[8:]))
+		off := int64(binary.LittleEndian.Uint32(e[12:]))
+		if off < int64(dirEnd) || off+size > int64(len(data)) {
+			return errICO
+		}
+		img := data[off : off+size]
+		start := images.Len()
+		if IsPNG(img) {
+			if err := scrubPNG(bytes.NewReader(img), &images, removed); err != nil {
+				return err
+			}
+		} else {
+			images.Write(img)
+		}
+		binary.LittleEndian.PutUint32(e[8:], uint32(images.Len()-start))
+		binary.LittleEndian.PutUint32(e[12:], uint32(dirEnd+start))
+	}
+	if _, err := w.Write(dir); err != nil {
+		return err
+	}
+	_, err = w.Write(images.Bytes())
+	return err
+}
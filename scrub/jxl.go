@@ -0,0 +1,102 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// A JPEG XL image is either a bare codestream, which has no place for
+// metadata, or a container of boxes like those of ISO media files.
+// In the container, metadata is held in Exif, xml (XMP), and jumb
+// (JUMBF) boxes, any of which may instead be compressed in a brob box
+// naming the type it holds. The jbrd box, which allows an original
+// JPEG file to be rebuilt, depends on the metadata boxes and is dropped
+// with them; the image itself is unaffected.
+
+var (
+	jxlCodestream = []byte{0xFF, 0x0A}
+	jxlContainer  = []byte("\x00\x00\x00\x0cJXL \r\n\x87\n")
+)
+
+// IsJXL reports whether the data begins like a JPEG XL image,
+// either a bare codestream or a container.
+func IsJXL(data []byte) bool {
+	return bytes.HasPrefix(data, jxlCodestream) || bytes.HasPrefix(data, jxlContainer)
+}
+
+// jxlMetadata lists the boxes that hold metadata.
+var jxlMetadata = map[string]bool{
+	"Exif": true,
+	"xml ": true,
+	"jumb": true,
+	"jbrd": true,
+}
+
+// ScrubJXL reads a JPEG XL image from r and writes it to w without its
+// metadata boxes. A bare codestream is copied unchanged. Like Scrub,
+// it works in constant space.
+func ScrubJXL(r io.Reader, w io.Writer) error {
+	return scrubJXL(r, w, nil)
+}
+
+func scrubJXL(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	sig, err := br.Peek(len(jxlCodestream))
+	if err != nil {
+		return noEOF(err)
+	}
+	if bytes.Equal(sig, jxlCodestream) {
+		_, err := io.Copy(w, br)
+		return err
+	}
+	for first := true; ; first = false {
+		hdr, typ, size, err := readBox(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return noEOF(err)
+		}
+		if first && typ != "JXL " {
+			return fmt.Errorf("not a JPEG XL file")
+		}
+		drop := jxlMetadata[typ]
+		what := strings.TrimSpace(typ) + " box"
+		if typ == "brob" {
+			// The type of the compressed box comes first.
+			inner, err := br.Peek(4)
+			if err != nil {
+				return noEOF(err)
+			}
+			drop = jxlMetadata[string(inner)]
+			what = "compressed " + strings.TrimSpace(string(inner)) + " box"
+		}
+		out := w
+		if drop {
+			out = ioutil.Discard
+		} else if _, err := w.Write(hdr); err != nil {
+			return err
+		}
+		if size < 0 {
+			n, err := io.Copy(out, br)
+			if drop {
+				report(removed, what, int64(len(hdr))+n, 0)
+			}
+			return err
+		}
+		if _, err := io.CopyN(out, br, size); err != nil {
+			return noEOF(err)
+		}
+		if drop {
+			report(removed, what, int64(len(hdr))+size, 0)
+		}
+	}
+}
@@ -0,0 +1,455 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// A Matroska or WebM file is a tree of EBML elements, each an ID, a
+// size, and the contents: a header, then a segment holding everything
+// else. In the segment, the Info element gives the title, the date,
+// and the file's name; Tags hold anything at all; and Void elements
+// reserve space that can hold the remains of earlier metadata. The
+// media is in clusters, located by their positions in the segment, in
+// the seek heads and the cues.
+//
+// Scrubbing copies the clusters straight through, holding only the
+// elements before them in memory, and moves the positions to allow for
+// what it removes. Tags after the first cluster are overwritten by a
+// Void element of the same size, so nothing moves.
+
+// EBML element IDs.
+const (
+	ebmlHeader = 0x1A45DFA3
+	ebmlVoid   = 0xEC
+	ebmlCRC    = 0xBF
+
+	mkvSegment       = 0x18538067
+	mkvSeekHead      = 0x114D9B74
+	mkvSeek          = 0x4DBB
+	mkvSeekID        = 0x53AB
+	mkvSeekPosition  = 0x53AC
+	mkvInfo          = 0x1549A966
+	mkvCluster       = 0x1F43B675
+	mkvCues          = 0x1C53BB6B
+	mkvCuePoint      = 0xBB
+	mkvCueTrack      = 0xB7
+	mkvCueCluster    = 0xF1
+	mkvCueCodecState = 0xEA
+	mkvTags          = 0x1254C367
+)
+
+// mkvNames holds the names of the elements that hold metadata.
+var mkvNames = map[uint32]string{
+	ebmlVoid: "Void",
+	mkvInfo:  "Info",
+	mkvTags:  "Tags",
+}
+
+// mkvInfoMetadata lists the elements of Info that are dropped.
+var mkvInfoMetadata = map[uint32]bool{
+	0x7BA9:   true, // Title.
+	0x4461:   true, // DateUTC.
+	0x7384:   true, // SegmentFilename.
+	0x3C83AB: true, // PrevFilename.
+	0x3E83BB: true, // NextFilename.
+	ebmlCRC:  true, // No longer correct.
+}
+
+// maxMKVElement is the size of the largest element, other than a
+// cluster, that ScrubMKV will hold in memory.
+const maxMKVElement = 1 << 30
+
+var errEBML = errors.New("malformed EBML data")
+
+// IsMKV reports whether the data begins with an EBML header, as do
+// Matroska and WebM files.
+func IsMKV(data []byte) bool {
+	return len(data) >= 4 && ebmlUint(data[:4]) == ebmlHeader
+}
+
+// mkv holds the state of scrubbing a segment.
+type mkv struct {
+	pos     int64    // Position in the input, relative to the segment's contents.
+	header  []byte   // The segment's header, or nil once written.
+	size    int64    // The size of the segment, or -1 if unknown.
+	pending [][]byte // Elements read but not yet written.
+	cuts    []mkvCut // Removed data.
+	fixes   [][]byte // Positions in pending elements to be moved.
+	removed func(Removal)
+}
+
+// An mkvCut records that n bytes were removed before pos in the input.
+type mkvCut struct {
+	pos, n int64
+}
+
+// ScrubMKV reads a Matroska or WebM file from r and writes it to w
+// without its tags, the title, date, and file names in its Info
+// element, and Void elements. The media is copied unchanged.
+func ScrubMKV(r io.Reader, w io.Writer) error {
+	return scrubMKV(r, w, nil)
+}
+
+func scrubMKV(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	for first := true; ; first = false {
+		hdr, id, size, err := readEBML(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return noEOF(err)
+		}
+		if first && id != ebmlHeader {
+			return fmt.Errorf("not a Matroska file")
+		}
+		if id == mkvSegment {
+			m := &mkv{header: hdr, size: size, removed: removed}
+			if err := m.segment(br, w); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := w.Write(hdr); err != nil {
+			return err
+		}
+		if size < 0 {
+			return errEBML
+		}
+		if _, err := io.CopyN(w, br, size); err != nil {
+			return noEOF(err)
+		}
+	}
+}
+
+// segment scrubs the contents of a segment.
+func (m *mkv) segment(r *bufio.Reader, w io.Writer) error {
+	for m.size < 0 || m.pos < m.size {
+		hdr, id, size, err := readEBML(r)
+		if err == io.EOF && m.size < 0 {
+			break
+		}
+		if err != nil {
+			return noEOF(err)
+		}
+		n := int64(len(hdr))
+		m.pos += n
+		clustered := m.header == nil
+		switch {
+		case id == mkvCluster:
+			if err := m.flush(w); err != nil {
+				return err
+			}
+			if _, err := w.Write(hdr); err != nil {
+				return err
+			}
+			if size < 0 {
+				// A live stream; copy the rest.
+				_, err := io.Copy(w, r)
+				return err
+			}
+			if _, err := io.CopyN(w, r, size); err != nil {
+				return noEOF(err)
+			}
+			m.pos += size
+			continue
+		case size < 0:
+			return errEBML
+		case size > maxMKVElement:
+			return fmt.Errorf("EBML element 0x%X too large: %d bytes", id, size)
+		case !clustered && (id == mkvTags || id == ebmlVoid):
+			if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+				return noEOF(err)
+			}
+			m.pos += size
+			m.cuts = append(m.cuts, mkvCut{m.pos, n + size})
+			report(m.removed, mkvNames[id]+" element", n+size, 0)
+			continue
+		}
+		data, err := readN(r, hdr, size)
+		if err != nil {
+			return err
+		}
+		m.pos += size
+		out := data
+		switch id {
+		case mkvTags:
+			// After the first cluster: keep the space.
+			out = voidEBML(len(data))
+		case mkvInfo:
+			out, err = rewriteEBML(data, int(n), func(id uint32, body []byte) bool {
+				return mkvInfoMetadata[id]
+			})
+		case mkvSeekHead:
+			out, err = rewriteEBML(data, int(n), func(id uint32, body []byte) bool {
+				return id == ebmlCRC || !clustered && id == mkvSeek && seeksTags(body)
+			})
+		case mkvCues:
+			out, err = rewriteEBML(data, int(n), func(id uint32, body []byte) bool {
+				return id == ebmlCRC
+			})
+		}
+		if err != nil {
+			return err
+		}
+		edited := id == mkvTags || id == mkvInfo && len(out) != len(data)
+		if clustered && len(out) != len(data) {
+			// Removing data after a cluster would move the end of
+			// the segment; overwrite it instead.
+			out = append(out, voidEBML(len(data)-len(out))...)
+		}
+		if edited {
+			report(m.removed, mkvNames[id]+" element", int64(len(data)), int64(len(out)))
+		}
+		if d := int64(len(data) - len(out)); d > 0 {
+			m.cuts = append(m.cuts, mkvCut{m.pos, d})
+		}
+		if err := m.record(id, out, int(n)); err != nil {
+			return err
+		}
+		m.pending = append(m.pending, out)
+		if clustered {
+			if err := m.flush(w); err != nil {
+				return err
+			}
+		}
+	}
+	return m.flush(w)
+}
+
+// seeksTags reports whether the contents of a Seek element locate Tags.
+func seeksTags(body []byte) bool {
+	children, err := ebmlChildren(body)
+	if err != nil {
+		return false
+	}
+	for _, c := range children {
+		if c.id == mkvSeekID {
+			b := body[c.body:c.end]
+			return ebmlUint(b) == mkvTags
+		}
+	}
+	return false
+}
+
+// record records the positions in the segment held in the element,
+// whose header is n bytes long.
+func (m *mkv) record(id uint32, data []byte, n int) error {
+	var path []uint32
+	switch id {
+	case mkvSeekHead:
+		path = []uint32{mkvSeek, mkvSeekPosition}
+	case mkvCues:
+		path = []uint32{mkvCuePoint, mkvCueTrack, mkvCueCluster}
+	default:
+		return nil
+	}
+	return m.recordPath(data[n:], path)
+}
+
+// recordPath records the positions found by following the path of
+// element IDs through the elements in b. The last step of a cue path
+// also matches the codec state position.
+func (m *mkv) recordPath(b []byte, path []uint32) error {
+	children, err := ebmlChildren(b)
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		switch {
+		case len(path) == 1 && (c.id == path[0] || path[0] == mkvCueCluster && c.id == mkvCueCodecState):
+			if c.end-c.body > 8 {
+				return errEBML
+			}
+			if c.id == mkvCueCodecState && ebmlUint(b[c.body:c.end]) == 0 {
+				continue // Not in use.
+			}
+			m.fixes = append(m.fixes, b[c.body:c.end])
+		case len(path) > 1 && c.id == path[0]:
+			if err := m.recordPath(b[c.body:c.end], path[1:]); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// flush moves the recorded positions to allow for the data removed
+// before them, and writes the segment's header, if it has not been
+// written, and the pending elements.
+func (m *mkv) flush(w io.Writer) error {
+	for _, f := range m.fixes {
+		off := int64(ebmlUint(f))
+		moved := off
+		for _, c := range m.cuts {
+			if c.pos <= off {
+				moved -= c.n
+			}
+		}
+		putUint(f, uint64(moved))
+	}
+	m.fixes = nil
+	if m.header != nil {
+		if m.size >= 0 {
+			removed := int64(0)
+			for _, c := range m.cuts {
+				removed += c.n
+			}
+			putVint(m.header[vintLen(m.header):], uint64(m.size-removed))
+		}
+		if _, err := w.Write(m.header); err != nil {
+			return err
+		}
+		m.header = nil
+	}
+	for _, b := range m.pending {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	m.pending = nil
+	return nil
+}
+
+// An ebmlElement locates an element within a buffer.
+type ebmlElement struct {
+	id               uint32
+	start, body, end int
+}
+
+// ebmlChildren returns the elements that fill b.
+func ebmlChildren(b []byte) ([]ebmlElement, error) {
+	var elems []ebmlElement
+	for p := 0; p < len(b); {
+		id, size, n, ok := parseEBML(b[p:])
+		if !ok || size < 0 || int64(len(b)-p-n) < size {
+			return nil, errEBML
+		}
+		end := p + n + int(size)
+		elems = append(elems, ebmlElement{id, p, p + n, end})
+		p = end
+	}
+	return elems, nil
+}
+
+// rewriteEBML returns the master element, whose header is n bytes
+// long, without the children for which drop returns true. The size
+// keeps its width, since it can only shrink.
+func rewriteEBML(data []byte, n int, drop func(id uint32, body []byte) bool) ([]byte, error) {
+	children, err := ebmlChildren(data[n:])
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), data[:n]...)
+	for _, c := range children {
+		if !drop(c.id, data[n+c.body:n+c.end]) {
+			out = append(out, data[n+c.start:n+c.end]...)
+		}
+	}
+	putVint(out[vintLen(data):n], uint64(len(out)-n))
+	return out, nil
+}
+
+// voidEBML returns a Void element n bytes long, n > 1.
+func voidEBML(n int) []byte {
+	w := 8
+	if n < 9 {
+		w = n - 1
+	}
+	b := make([]byte, n)
+	b[0] = ebmlVoid
+	putVint(b[1:1+w], uint64(n-1-w))
+	return b
+}
+
+// readEBML reads the header of an element from r, returning the header
+// and the element's ID and size, which is -1 if unknown.
+func readEBML(r *bufio.Reader) (hdr []byte, id uint32, size int64, err error) {
+	b, err := r.Peek(12)
+	if len(b) == 0 {
+		return nil, 0, 0, err
+	}
+	id, size, n, ok := parseEBML(b)
+	if !ok {
+		if err != nil {
+			return nil, 0, 0, noEOF(err)
+		}
+		return nil, 0, 0, errEBML
+	}
+	hdr = append([]byte(nil), b[:n]...)
+	r.Discard(n)
+	return hdr, id, size, nil
+}
+
+// parseEBML parses the header of the element at the start of b,
+// returning its ID, its size, or -1 if unknown, and the length of the
+// header.
+func parseEBML(b []byte) (id uint32, size int64, n int, ok bool) {
+	idLen := vintLen(b)
+	if idLen == 0 || idLen > 4 || idLen >= len(b) {
+		return 0, 0, 0, false
+	}
+	id = uint32(ebmlUint(b[:idLen]))
+	s := b[idLen:]
+	sizeLen := vintLen(s)
+	if sizeLen == 0 || sizeLen > len(s) {
+		return 0, 0, 0, false
+	}
+	v := uint64(s[0]) & (0xFF >> uint(sizeLen))
+	unknown := v == 0xFF>>uint(sizeLen)
+	for _, c := range s[1:sizeLen] {
+		v = v<<8 | uint64(c)
+		unknown = unknown && c == 0xFF
+	}
+	size = int64(v)
+	if unknown {
+		size = -1
+	}
+	return id, size, idLen + sizeLen, true
+}
+
+// vintLen returns the length of the EBML variable-length integer
+// at the start of b, or 0 if it is invalid.
+func vintLen(b []byte) int {
+	if len(b) == 0 || b[0] == 0 {
+		return 0
+	}
+	n := 1
+	for c := b[0]; c&0x80 == 0; c <<= 1 {
+		n++
+	}
+	return n
+}
+
+// putVint stores v in b as an EBML variable-length integer
+// of length len(b).
+func putVint(b []byte, v uint64) {
+	putUint(b, v)
+	b[0] |= 0x80 >> uint(len(b)-1)
+}
+
+// ebmlUint returns the value of the big-endian unsigned integer b,
+// which is at most 8 bytes long.
+func ebmlUint(b []byte) uint64 {
+	var v uint64
+	for _, c := range b {
+		v = v<<8 | uint64(c)
+	}
+	return v
+}
+
+// putUint stores v in b as a big-endian unsigned integer.
+func putUint(b []byte, v uint64) {
+	for i := len(b) - 1; i >= 0; i-- {
+		b[i] = byte(v)
+		v >>= 8
+	}
+}
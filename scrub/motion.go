@@ -0,0 +1,144 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io/ioutil"
+	"regexp"
+)
+
+// A motion photo is a JPEG image followed, after the EOI marker, by a
+// short MP4 video, with XMP data saying so. Google's phones record in
+// the Camera namespace either MicroVideo="1" and the video's offset
+// from the end of the file or, in later versions, MotionPhoto="1" and
+// a Container directory giving its length. Samsung's phones write the
+// same XMP but keep the video in their own trailer, after the marker
+// MotionPhoto_Data and before the trailer's directory.
+
+// motionRE matches the XMP property that marks a motion photo, as an
+// attribute or an element.
+var motionRE = regexp.MustCompile(`(MotionPhoto|MicroVideo)(\s*=\s*["']|>)1["'<]`)
+
+// IsMotionPhoto reports whether the payload of an APP1 segment holds an
+// XMP packet marking the image as a motion photo.
+func IsMotionPhoto(payload []byte) bool {
+	return IsXMP(payload) && motionRE.Match(payload)
+}
+
+// SplitMotionPhoto returns the still image and the video of the motion
+// photo held in data, a whole JPEG file. The image is everything before
+// the video; what follows the video, such as the rest of a Samsung
+// trailer, is in neither. If data is not a motion photo, video is nil.
+func SplitMotionPhoto(data []byte) (image, video []byte, err error) {
+	motion := false
+	s := NewScanner(bytes.NewReader(data), ioutil.Discard)
+	s.Filter(func(marker byte, payload []byte) bool {
+		if marker == APPn+1 && IsMotionPhoto(payload) {
+			motion = true
+		}
+		return true
+	})
+	end := int64(-1)
+	for s.Scan() {
+		if seg := s.Segment(); seg.Marker == EOI {
+			end = seg.Offset + int64(seg.Length)
+		}
+	}
+	if s.Err() != nil {
+		return nil, nil, s.Err()
+	}
+	if !motion || end < 0 {
+		return data, nil, nil
+	}
+	start, stop := findVideo(data[end:])
+	if start < 0 {
+		return data, nil, fmt.Errorf("motion photo holds no video")
+	}
+	return data[:end+int64(start)], data[end+int64(start) : end+int64(stop)], nil
+}
+
+// findVideo returns the location of the MP4 video in the trailer, the
+// first run of boxes beginning with an ftyp box, or -1 if there is none.
+func findVideo(t []byte) (start, end int) {
+	for i := 0; ; {
+		j := bytes.Index(t[i:], []byte("ftyp"))
+		if j < 0 {
+			return -1, -1
+		}
+		start = i + j - 4
+		if start >= 0 {
+			if end = mp4Boxes(t, start); end > start {
+				return start, end
+			}
+		}
+		i += j + 4
+	}
+}
+
+// mp4Boxes returns the end of the run of top-level MP4 boxes starting
+// at t[start:]. A box whose size does not fit or whose type is not
+// four printable characters ends the run.
+func mp4Boxes(t []byte, start int) int {
+	end := start
+	for len(t)-end >= 8 {
+		size := int64(binary.BigEndian.Uint32(t[end:]))
+		switch size {
+		case 0:
+			size = int64(len(t) - end)
+		case 1:
+			if len(t)-end < 16 {
+				return end
+			}
+			size = int64(binary.BigEndian.Uint64(t[end+8:]))
+		}
+		if size < 8 || size > int64(len(t)-end) {
+			return end
+		}
+		for _, c := range t[end+4 : end+8] {
+			if c < ' ' || c > '~' {
+				return end
+			}
+		}
+		end += int(size)
+	}
+	return end
+}
+
+// MotionPhotoXMP returns the payload of an APP1 segment holding a
+// minimal XMP packet that marks the image as a motion photo whose video,
+// of the given length, ends the file. It records both forms of Google's
+// description, so old and new readers find the video.
+func MotionPhotoXMP(videoLength int) []byte {
+	packet := fmt.Sprintf(`<x:xmpmeta xmlns:x="adobe:ns:meta/">
+<rdf:RDF xmlns:rdf="http://www.w3.org/1999/02/22-rdf-syntax-ns#">
+<rdf:Description rdf:about=""
+ xmlns:GCamera="http://ns.google.com/photos/1.0/camera/"
+ xmlns:Container="http://ns.google.com/photos/1.0/container/"
+ xmlns:Item="http://ns.google.com/photos/1.0/container/item/"
+ GCamera:MotionPhoto="1"
+ GCamera:MotionPhotoVersion="1"
+ GCamera:MotionPhotoPresentationTimestampUs="-1"
+ GCamera:MicroVideo="1"
+ GCamera:MicroVideoVersion="1"
+ GCamera:MicroVideoOffset="%[1]d"
+ GCamera:MicroVideoPresentationTimestampUs="-1">
+<Container:Directory>
+<rdf:Seq>
+<rdf:li rdf:parseType="Resource">
+<Container:Item Item:Mime="image/jpeg" Item:Semantic="Primary" Item:Length="0" Item:Padding="0"/>
+</rdf:li>
+<rdf:li rdf:parseType="Resource">
+<Container:Item Item:Mime="video/mp4" Item:Semantic="MotionPhoto" Item:Length="%[1]d" Item:Padding="0"/>
+</rdf:li>
+</rdf:Seq>
+</Container:Directory>
+</rdf:Description>
+</rdf:RDF>
+</x:xmpmeta>`, videoLength)
+	return append(append([]byte(nil), xmpHeader...), packet...)
+}
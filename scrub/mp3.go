@@ -0,0 +1,105 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// An MP3 file is a sequence of audio frames, with tags before and
+// after them. ID3v2 tags come first, and occasionally last, and hold
+// titles, artists, comments, pictures, and anything else as frames.
+// The fixed ID3v1 tag, sometimes extended, fills the last 128 bytes,
+// and an APEv2 tag may come before it. None of them is needed to play
+// the audio, so all are removed.
+
+// IsMP3 reports whether the data begins like an MP3 file: an ID3v2
+// tag, or an MPEG audio frame header.
+func IsMP3(data []byte) bool {
+	if len(data) >= 4 && string(data[:3]) == "ID3" && data[3] != 0xFF {
+		return true
+	}
+	if len(data) < 4 || data[0] != 0xFF || data[1]&0xE0 != 0xE0 {
+		return false
+	}
+	version := data[1] >> 3 & 3
+	layer := data[1] >> 1 & 3
+	bitrate := data[2] >> 4
+	rate := data[2] >> 2 & 3
+	return version != 1 && layer != 0 && bitrate != 15 && rate != 3
+}
+
+var errID3 = errors.New("malformed ID3 tag")
+
+// ScrubMP3 reads an MP3 file from r and writes it to w without its ID3
+// and APE tags. The audio frames are copied unchanged.
+func ScrubMP3(r io.Reader, w io.Writer) error {
+	return scrubMP3(r, w, nil)
+}
+
+func scrubMP3(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	for bytes.HasPrefix(data, []byte("ID3")) {
+		if len(data) < 10 {
+			return errID3
+		}
+		n := 10 + syncsafe(data[6:10])
+		if data[5]&0x10 != 0 {
+			n += 10 // Footer.
+		}
+		if n > len(data) {
+			return errID3
+		}
+		report(removed, "ID3v2 tag", int64(n), 0)
+		data = data[n:]
+	}
+	for {
+		switch end := len(data); {
+		case end >= 128 && string(data[end-128:end-125]) == "TAG":
+			data = data[:end-128]
+			report(removed, "ID3v1 tag", 128, 0)
+			// The extended tag precedes the standard one.
+			if end >= 355 && string(data[end-355:end-351]) == "TAG+" {
+				data = data[:end-355]
+				report(removed, "extended ID3v1 tag", 227, 0)
+			}
+		case end >= 32 && string(data[end-32:end-24]) == "APETAGEX":
+			// The size includes the footer but not the header.
+			n := int(binary.LittleEndian.Uint32(data[end-20:]))
+			if binary.LittleEndian.Uint32(data[end-12:])&(1<<31) != 0 {
+				n += 32 // Header.
+			}
+			if n < 32 || n > end {
+				return errors.New("malformed APE tag")
+			}
+			report(removed, "APE tag", int64(n), 0)
+			data = data[:end-n]
+		case end >= 10 && string(data[end-10:end-7]) == "3DI":
+			// An ID3v2 tag appended to the file ends with a footer.
+			n := 20 + syncsafe(data[end-4:end])
+			if n > end {
+				return errID3
+			}
+			report(removed, "ID3v2 tag", int64(n), 0)
+			data = data[:end-n]
+		default:
+			_, err := w.Write(data)
+			return err
+		}
+	}
+}
+
+// syncsafe returns the value of a 4-byte ID3v2 size, which holds
+// seven bits in each byte.
+func syncsafe(b []byte) int {
+	return int(b[0]&0x7F)<<21 | int(b[1]&0x7F)<<14 | int(b[2]&0x7F)<<7 | int(b[3]&0x7F)
+}
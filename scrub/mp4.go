@@ -0,0 +1,270 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// An MP4 or QuickTime movie is a sequence of boxes: the media data in
+// mdat, which may be huge, and the description of the tracks in moov.
+// Metadata lives in udta and meta boxes, at the top level or inside
+// moov and its tracks: locations, the camera, the encoder, and Apple's
+// QuickTime keys. XMP is held in a uuid box. The tracks locate their
+// data by file offset, in the chunk offset tables (stco and co64) and,
+// in fragmented movies, the track fragment headers (tfhd), so when
+// boxes before the media data are removed those offsets must move.
+//
+// Scrubbing copies mdat straight through, holding only the other boxes
+// in memory. It also drops free space, which can hold the remains of
+// earlier metadata.
+
+// IsMP4 reports whether the data begins like an MP4 or QuickTime movie.
+// Old QuickTime movies have no file type box.
+func IsMP4(data []byte) bool {
+	if len(data) < 8 {
+		return false
+	}
+	switch string(data[4:8]) {
+	case "ftyp", "moov", "mdat", "wide", "free", "skip", "pnot":
+		return true
+	}
+	return false
+}
+
+// xmpUUID identifies the uuid box that holds XMP.
+var xmpUUID = []byte("\xbe\x7a\xcf\xcb\x97\xa9\x42\xe8\x9c\x71\x99\x94\x91\xe3\xaf\xac")
+
+// mp4Metadata lists the boxes that are dropped wherever they are.
+var mp4Metadata = map[string]bool{
+	"udta": true,
+	"meta": true,
+	"free": true,
+	"skip": true,
+}
+
+// mp4Containers lists the boxes that hold boxes that may be dropped
+// or that locate media data.
+var mp4Containers = map[string]bool{
+	"moov": true,
+	"trak": true,
+	"mdia": true,
+	"minf": true,
+	"stbl": true,
+	"edts": true,
+	"dinf": true,
+	"mvex": true,
+	"moof": true,
+	"traf": true,
+}
+
+// maxMP4Box is the size of the largest box, other than mdat, that
+// ScrubMP4 will hold in memory.
+const maxMP4Box = 1 << 30
+
+// mp4 holds the state of scrubbing a movie.
+type mp4 struct {
+	pos     int64    // Position in the input.
+	pending [][]byte // Top-level boxes read but not yet written.
+	cuts    []mp4Cut // Removed data.
+	fixes   []mp4Fix // Offsets in pending boxes to be moved.
+}
+
+// An mp4Cut records that n bytes were removed before pos in the input.
+type mp4Cut struct {
+	pos, n int64
+}
+
+// An mp4Fix locates an offset, of the given size, in data.
+type mp4Fix struct {
+	data []byte
+	size int
+}
+
+// ScrubMP4 reads an MP4 or QuickTime movie from r and writes it to w
+// without its udta and meta boxes, XMP, and free space. The media data
+// is copied unchanged.
+func ScrubMP4(r io.Reader, w io.Writer) error {
+	return scrubMP4(r, w, nil)
+}
+
+func scrubMP4(r io.Reader, w io.Writer, removed func(Removal)) error {
+	m := &mp4{}
+	for {
+		hdr, typ, size, err := readBox(r)
+		if err == io.EOF {
+			return m.flush(w)
+		}
+		if err != nil {
+			return noEOF(err)
+		}
+		n := len(hdr)
+		m.pos += int64(n)
+		switch {
+		case typ == "mdat":
+			if err := m.flush(w); err != nil {
+				return err
+			}
+			if _, err := w.Write(hdr); err != nil {
+				return err
+			}
+			if size < 0 {
+				// The media data extends to the end of the file.
+				_, err := io.Copy(w, r)
+				return err
+			}
+			if _, err := io.CopyN(w, r, size); err != nil {
+				return noEOF(err)
+			}
+			m.pos += size
+		case size < 0:
+			return errBMFF
+		case mp4Metadata[typ]:
+			if _, err := io.CopyN(ioutil.Discard, r, size); err != nil {
+				return noEOF(err)
+			}
+			m.pos += size
+			m.cuts = append(m.cuts, mp4Cut{m.pos, int64(n) + size})
+			report(removed, typ+" box", int64(n)+size, 0)
+		case size > maxMP4Box:
+			return fmt.Errorf("%s box too large: %d bytes", typ, size)
+		default:
+			data, err := readN(r, hdr, size)
+			if err != nil {
+				return err
+			}
+			m.pos += size
+			if typ == "uuid" && bytes.HasPrefix(data[n:], xmpUUID) {
+				m.cuts = append(m.cuts, mp4Cut{m.pos, int64(len(data))})
+				report(removed, "XMP uuid box", int64(len(data)), 0)
+				continue
+			}
+			if mp4Containers[typ] {
+				out, err := rewriteMP4(data, n, removed)
+				if err != nil {
+					return err
+				}
+				if err := m.record(out, n); err != nil {
+					return err
+				}
+				m.cuts = append(m.cuts, mp4Cut{m.pos, int64(len(data) - len(out))})
+				data = out
+			}
+			m.pending = append(m.pending, data)
+		}
+	}
+}
+
+// rewriteMP4 returns the container box, whose header is n bytes long,
+// without the metadata inside it.
+func rewriteMP4(data []byte, n int, removed func(Removal)) ([]byte, error) {
+	children, err := boxes(data, int64(n), int64(len(data)))
+	if err != nil {
+		return nil, err
+	}
+	out := append([]byte(nil), data[:n]...)
+	for _, c := range children {
+		b := data[c.start:c.end]
+		switch {
+		case mp4Metadata[c.typ]:
+			report(removed, c.typ+" box", int64(len(b)), 0)
+			continue
+		case c.typ == "uuid" && bytes.HasPrefix(data[c.body:c.end], xmpUUID):
+			report(removed, "XMP uuid box", int64(len(b)), 0)
+			continue
+		case mp4Containers[c.typ]:
+			if b, err = rewriteMP4(b, int(c.body-c.start), removed); err != nil {
+				return nil, err
+			}
+		}
+		out = append(out, b...)
+	}
+	if n == 16 {
+		binary.BigEndian.PutUint64(out[8:], uint64(len(out)))
+	} else {
+		binary.BigEndian.PutUint32(out, uint32(len(out)))
+	}
+	return out, nil
+}
+
+// record records the file offsets held in the container box,
+// whose header is n bytes long, and in the containers within it.
+func (m *mp4) record(data []byte, n int) error {
+	children, err := boxes(data, int64(n), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	for _, c := range children {
+		var err error
+		if mp4Containers[c.typ] {
+			err = m.record(data[c.start:c.end], int(c.body-c.start))
+		} else {
+			err = m.offsets(c.typ, data[c.body:c.end])
+		}
+		if err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offsets records the file offsets held in the contents of a box
+// of the given type.
+func (m *mp4) offsets(typ string, b []byte) error {
+	size := 0
+	switch typ {
+	case "stco":
+		size = 4
+	case "co64":
+		size = 8
+	case "tfhd":
+		// The base data offset, if present, follows the track ID.
+		if len(b) >= 16 && b[3]&1 != 0 {
+			m.fixes = append(m.fixes, mp4Fix{b[8:16], 8})
+		}
+		return nil
+	default:
+		return nil
+	}
+	if len(b) < 8 {
+		return errBMFF
+	}
+	count := int64(binary.BigEndian.Uint32(b[4:]))
+	if 8+count*int64(size) > int64(len(b)) {
+		return errBMFF
+	}
+	for i := int64(0); i < count; i++ {
+		p := 8 + i*int64(size)
+		m.fixes = append(m.fixes, mp4Fix{b[p : p+int64(size)], size})
+	}
+	return nil
+}
+
+// flush moves the recorded offsets to allow for the data removed
+// before them, and writes the pending boxes.
+func (m *mp4) flush(w io.Writer) error {
+	for _, f := range m.fixes {
+		off := int64(uintN(f.data, f.size))
+		moved := off
+		for _, c := range m.cuts {
+			if c.pos <= off {
+				moved -= c.n
+			}
+		}
+		copy(f.data, appendUintN(nil, f.size, uint64(moved)))
+	}
+	m.fixes = nil
+	for _, b := range m.pending {
+		if _, err := w.Write(b); err != nil {
+			return err
+		}
+	}
+	m.pending = nil
+	return nil
+}
@@ -0,0 +1,273 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// An Ogg file is a sequence of pages, each holding segments of the
+// packets of one or more logical streams. A Vorbis or Opus stream
+// begins with header packets: an identification header on a page of
+// its own, then a comment header holding the tags, then, for Vorbis, a
+// setup header. The audio begins on a new page. The comment header
+// cannot be removed, so it is replaced by an empty one that keeps only
+// the name of the encoder. Since that may change the number of pages,
+// the later pages of the stream are renumbered, and every page written
+// has its checksum recomputed. Other streams are copied unchanged.
+
+var oggMagic = []byte("OggS")
+
+// IsOgg reports whether the data begins with an Ogg page.
+func IsOgg(data []byte) bool {
+	return bytes.HasPrefix(data, oggMagic)
+}
+
+var errOgg = errors.New("malformed Ogg file")
+
+// Ogg page flags.
+const (
+	oggContinued = 0x01
+	oggFirst     = 0x02
+)
+
+// An oggPage is a page: its 27-byte header, its segment table, and the
+// data.
+type oggPage struct {
+	hdr  [27]byte
+	segs []byte
+	data []byte
+}
+
+func (p *oggPage) serial() uint32 { return binary.LittleEndian.Uint32(p.hdr[14:]) }
+func (p *oggPage) seq() uint32    { return binary.LittleEndian.Uint32(p.hdr[18:]) }
+
+// An oggStream holds the state of a logical stream.
+type oggStream struct {
+	headers int      // Number of header packets after the first.
+	packets [][]byte // The header packets after the first.
+	partial bool     // Whether the last packet continues on the next page.
+	first   uint32   // Sequence number of the first page held.
+	held    int      // Number of pages held.
+	delta   uint32   // Change in the sequence numbers of later pages.
+}
+
+// ScrubOgg reads an Ogg Vorbis or Opus file from r and writes it to w
+// with empty comment headers. The audio is copied unchanged. Like
+// Scrub, it works in constant space.
+func ScrubOgg(r io.Reader, w io.Writer) error {
+	return scrubOgg(r, w, nil)
+}
+
+func scrubOgg(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	streams := make(map[uint32]*oggStream)
+	for {
+		p, err := readOggPage(br)
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		s := streams[p.serial()]
+		switch {
+		case p.hdr[5]&oggFirst != 0:
+			s = &oggStream{}
+			streams[p.serial()] = s
+			switch {
+			case bytes.HasPrefix(p.data, []byte("\x01vorbis")):
+				s.headers = 2
+			case bytes.HasPrefix(p.data, []byte("OpusHead")):
+				s.headers = 1
+			}
+		case s == nil:
+			return errOgg
+		case s.headers > 0:
+			if s.held == 0 {
+				s.first = p.seq()
+			}
+			s.held++
+			if err := s.collect(p); err != nil {
+				return err
+			}
+			if len(s.packets) < s.headers || s.partial {
+				continue
+			}
+			if len(s.packets) > s.headers {
+				return fmt.Errorf("Ogg audio shares a page with headers")
+			}
+			comment := emptyComment(s.packets[0])
+			if !bytes.Equal(comment, s.packets[0]) {
+				report(removed, "comment header", int64(len(s.packets[0])), int64(len(comment)))
+			}
+			s.packets[0] = comment
+			pages := oggPages(p.serial(), s.first, s.packets)
+			for _, b := range pages {
+				if _, err := w.Write(b); err != nil {
+					return err
+				}
+			}
+			s.delta = uint32(len(pages) - s.held)
+			s.headers, s.packets = 0, nil
+			continue
+		case s.delta != 0:
+			binary.LittleEndian.PutUint32(p.hdr[18:], p.seq()+s.delta)
+		}
+		if _, err := w.Write(p.bytes()); err != nil {
+			return err
+		}
+	}
+}
+
+// readOggPage reads a page from r.
+func readOggPage(r io.Reader) (*oggPage, error) {
+	p := &oggPage{}
+	if _, err := io.ReadFull(r, p.hdr[:]); err != nil {
+		if err == io.EOF {
+			return nil, err
+		}
+		return nil, noEOF(err)
+	}
+	if !IsOgg(p.hdr[:]) || p.hdr[4] != 0 {
+		return nil, errOgg
+	}
+	p.segs = make([]byte, p.hdr[26])
+	if _, err := io.ReadFull(r, p.segs); err != nil {
+		return nil, noEOF(err)
+	}
+	n := 0
+	for _, s := range p.segs {
+		n += int(s)
+	}
+	p.data = make([]byte, n)
+	if _, err := io.ReadFull(r, p.data); err != nil {
+		return nil, noEOF(err)
+	}
+	return p, nil
+}
+
+// bytes returns the page as written, with its checksum computed.
+func (p *oggPage) bytes() []byte {
+	b := append(append(p.hdr[:], p.segs...), p.data...)
+	binary.LittleEndian.PutUint32(b[22:], 0)
+	binary.LittleEndian.PutUint32(b[22:], oggCRC(b))
+	return b
+}
+
+// collect adds the packets on the page to those of the stream.
+func (s *oggStream) collect(p *oggPage) error {
+	if s.partial != (p.hdr[5]&oggContinued != 0) {
+		return errOgg
+	}
+	data := p.data
+	for _, n := range p.segs {
+		if !s.partial {
+			s.packets = append(s.packets, nil)
+		}
+		last := &s.packets[len(s.packets)-1]
+		*last = append(*last, data[:n]...)
+		data = data[n:]
+		s.partial = n == 255
+	}
+	return nil
+}
+
+// emptyComment returns a Vorbis or Opus comment header with no
+// comments, keeping the vendor string of the one given.
+func emptyComment(p []byte) []byte {
+	opus := bytes.HasPrefix(p, []byte("OpusTags"))
+	n := 7 // "\x03vorbis"
+	if opus {
+		n = 8
+	}
+	if len(p) < n {
+		n = len(p)
+	} else if len(p) >= n+4 {
+		vendor := int(binary.LittleEndian.Uint32(p[n:]))
+		if vendor <= len(p)-n-4 {
+			n += 4 + vendor
+		}
+	}
+	out := append([]byte(nil), p[:n]...)
+	out = append(out, 0, 0, 0, 0) // No comments.
+	if !opus {
+		out = append(out, 1) // Vorbis framing bit.
+	}
+	return out
+}
+
+// oggPages returns the pages, numbered from seq, that hold the packets.
+// The last packet ends the last page.
+func oggPages(serial, seq uint32, packets [][]byte) [][]byte {
+	var segs []byte
+	var data []byte
+	for _, pkt := range packets {
+		for n := len(pkt); ; n -= 255 {
+			if n < 255 {
+				segs = append(segs, byte(n))
+				break
+			}
+			segs = append(segs, 255)
+		}
+		data = append(data, pkt...)
+	}
+	var pages [][]byte
+	continued := false
+	for len(segs) > 0 {
+		p := &oggPage{}
+		copy(p.hdr[:], oggMagic)
+		if continued {
+			p.hdr[5] = oggContinued
+		}
+		binary.LittleEndian.PutUint32(p.hdr[14:], serial)
+		binary.LittleEndian.PutUint32(p.hdr[18:], seq)
+		seq++
+		n := len(segs)
+		if n > 255 {
+			n = 255
+		}
+		p.segs, segs = segs[:n], segs[n:]
+		p.hdr[26] = byte(n)
+		size := 0
+		for _, s := range p.segs {
+			size += int(s)
+		}
+		p.data, data = data[:size], data[size:]
+		continued = p.segs[n-1] == 255
+		pages = append(pages, p.bytes())
+	}
+	return pages
+}
+
+var oggTable = func() (t [256]uint32) {
+	for i := range t {
+		c := uint32(i) << 24
+		for j := 0; j < 8; j++ {
+			if c&(1<<31) != 0 {
+				c = c<<1 ^ 0x04C11DB7
+			} else {
+				c <<= 1
+			}
+		}
+		t[i] = c
+	}
+	return
+}()
+
+// oggCRC returns the checksum of an Ogg page, a CRC-32 that, unlike
+// the one in hash/crc32, is computed most significant bit first.
+func oggCRC(b []byte) uint32 {
+	var c uint32
+	for _, x := range b {
+		c = c<<8 ^ oggTable[byte(c>>24)^x]
+	}
+	return c
+}
@@ -0,0 +1,71 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"io"
+	"strings"
+)
+
+// Word, Excel, and PowerPoint documents (.docx, .xlsx, .pptx) are ZIP
+// archives of XML parts. The document properties are held in three
+// parts: core.xml, with the author, the last person to modify it, the
+// revision number, and dates; app.xml, with the company, the manager,
+// the template, and the total editing time; and custom.xml, with
+// anything else. Each is replaced by an empty one. Pictures, in the
+// media folders, and the thumbnail are scrubbed like any other image.
+
+// ooxmlFirst lists the names of the files that begin an Office document.
+var ooxmlFirst = []string{
+	"[Content_Types].xml",
+	"_rels/",
+	"docProps/",
+	"word/",
+	"xl/",
+	"ppt/",
+}
+
+// IsOOXML reports whether the data begins like an Office document.
+func IsOOXML(data []byte) bool {
+	name := zipName(data)
+	if name == "" {
+		return false
+	}
+	for _, f := range ooxmlFirst {
+		if strings.HasPrefix(name, f) {
+			return true
+		}
+	}
+	return false
+}
+
+// ooxmlProperties holds the empty replacements for the property parts.
+var ooxmlProperties = map[string]string{
+	"docProps/core.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<cp:coreProperties xmlns:cp="http://schemas.openxmlformats.org/package/2006/metadata/core-properties" xmlns:dc="http://purl.org/dc/elements/1.1/" xmlns:dcterms="http://purl.org/dc/terms/" xmlns:dcmitype="http://purl.org/dc/dcmitype/" xmlns:xsi="http://www.w3.org/2001/XMLSchema-instance"/>
+`,
+	"docProps/app.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/extended-properties" xmlns:vt="http://schemas.openxmlformats.org/officeDocument/2006/docPropsVTypes"/>
+`,
+	"docProps/custom.xml": `<?xml version="1.0" encoding="UTF-8" standalone="yes"?>
+<Properties xmlns="http://schemas.openxmlformats.org/officeDocument/2006/custom-properties" xmlns:vt="http://schemas.openxmlformats.org/officeDocument/2006/docPropsVTypes"/>
+`,
+}
+
+// ScrubOOXML reads an Office document from r and writes it to w with
+// empty document properties and its images scrubbed. It holds the
+// whole file in memory.
+func ScrubOOXML(r io.Reader, w io.Writer) error {
+	return scrubOOXML(r, w, nil)
+}
+
+func scrubOOXML(r io.Reader, w io.Writer, removed func(Removal)) error {
+	return rewriteZip(r, w, removed, func(name string, data []byte) ([]byte, error) {
+		if p, ok := ooxmlProperties[name]; ok {
+			return []byte(p), nil
+		}
+		return scrubImage(data)
+	})
+}
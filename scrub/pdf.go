@@ -0,0 +1,450 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"compress/zlib"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"regexp"
+	"sort"
+	"strconv"
+)
+
+// A PDF file is a sequence of numbered objects, located by a cross-
+// reference table, with a trailer naming the document catalog, the root
+// of everything shown, and the Info dictionary, which holds the author,
+// the creating software, and dates. XMP packets are held in metadata
+// streams, and attached files carry dates in their parameters. Editing
+// a PDF usually appends new versions of objects, leaving the old ones
+// in the file.
+//
+// Scrubbing writes a new file holding only the current versions of the
+// objects reachable from the catalog, with a new cross-reference table
+// and trailer. The Info dictionary is not reachable from the catalog,
+// so it goes; metadata streams are dropped, and the references to them
+// left dangling, which PDF defines to mean null; and the parameters of
+// attached files are deleted. Objects packed in compressed object
+// streams are unpacked, since the streams themselves are not copied.
+
+// IsPDF reports whether the data begins with a PDF header.
+func IsPDF(data []byte) bool {
+	return bytes.HasPrefix(data, []byte("%PDF-"))
+}
+
+var (
+	pdfVersionRE   = regexp.MustCompile(`^%PDF-[0-9.]+`)
+	pdfObjectRE    = regexp.MustCompile(`\b(\d+)\s+(\d+)\s+obj\b`)
+	pdfRefRE       = regexp.MustCompile(`\b(\d+)\s+(\d+)\s+R\b`)
+	pdfTrailerRE   = regexp.MustCompile(`trailer\s*<<`)
+	pdfRootRE      = regexp.MustCompile(`/Root\s+(\d+\s+\d+\s+R)\b`)
+	pdfInfoRE      = regexp.MustCompile(`/Info\s+(\d+\s+\d+\s+R)\b`)
+	pdfIDRE        = regexp.MustCompile(`/ID\s*\[[^\]]*\]`)
+	pdfLengthRE    = regexp.MustCompile(`/Length\s+(\d+)(\s+\d+\s+R\b)?`)
+	pdfParamsRE    = regexp.MustCompile(`/Params\s*(\d+\s+\d+\s+R\b)?`)
+	pdfStreamRE    = regexp.MustCompile(`^\s*stream(\r\n|\n|\r)`)
+	pdfEndStreamRE = regexp.MustCompile(`^\s*endstream\b`)
+)
+
+var errPDF = errors.New("malformed PDF data")
+
+// A pdfObject is the text of an object, without its number and the
+// keywords that surround it.
+type pdfObject struct {
+	gen  int
+	body []byte
+}
+
+// pdf holds the state of scrubbing a PDF file.
+type pdf struct {
+	objs    map[int]pdfObject
+	root    string // The reference to the catalog.
+	info    string // The reference to the Info dictionary, if any.
+	id      string // The /ID entry of the trailer, if any.
+	encrypt bool
+	removed func(Removal)
+}
+
+// ScrubPDF reads a PDF file from r and writes it to w without its
+// Info dictionary, its XMP metadata streams, and the parameters of its
+// attached files. It holds the whole file in memory.
+func ScrubPDF(r io.Reader, w io.Writer) error {
+	return scrubPDF(r, w, nil)
+}
+
+func scrubPDF(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !IsPDF(data) {
+		return fmt.Errorf("not a PDF file")
+	}
+	p := &pdf{objs: make(map[int]pdfObject), removed: removed}
+	if err := p.parse(data); err != nil {
+		return err
+	}
+	if p.encrypt {
+		return fmt.Errorf("cannot scrub encrypted PDF")
+	}
+	if p.root == "" {
+		return fmt.Errorf("PDF has no document catalog")
+	}
+	_, err = w.Write(p.write(pdfVersionRE.Find(data)))
+	return err
+}
+
+// parse reads the objects and trailers of the file, in order, so later
+// versions of objects replace earlier ones.
+func (p *pdf) parse(data []byte) error {
+	for pos := 0; ; {
+		m := pdfObjectRE.FindSubmatchIndex(data[pos:])
+		gap := data[pos:]
+		if m != nil {
+			gap = data[pos : pos+m[0]]
+		}
+		for _, t := range pdfTrailerRE.FindAllIndex(gap, -1) {
+			end := dictEnd(gap, t[1]-2)
+			if end < 0 {
+				return errPDF
+			}
+			p.trailer(gap[t[1]-2 : end])
+		}
+		if m == nil {
+			return nil
+		}
+		num, _ := strconv.Atoi(string(data[pos+m[2] : pos+m[3]]))
+		gen, _ := strconv.Atoi(string(data[pos+m[4] : pos+m[5]]))
+		start := pos + m[1]
+		end, next := objectEnd(data, start)
+		if end < 0 {
+			return fmt.Errorf("bad PDF object %d", num)
+		}
+		if err := p.add(num, gen, bytes.TrimSpace(data[start:end])); err != nil {
+			return err
+		}
+		pos = next
+	}
+}
+
+// trailer records what matters in the trailer dictionary.
+func (p *pdf) trailer(dict []byte) {
+	if m := pdfRootRE.FindSubmatch(dict); m != nil {
+		p.root = string(m[1])
+	}
+	if m := pdfInfoRE.FindSubmatch(dict); m != nil {
+		p.info = string(m[1])
+	}
+	if m := pdfIDRE.Find(dict); m != nil {
+		p.id = string(m)
+	}
+	if bytes.Contains(dict, []byte("/Encrypt")) {
+		p.encrypt = true
+	}
+}
+
+// add records the object. Cross-reference streams are trailers, and
+// object streams are unpacked.
+func (p *pdf) add(num, gen int, body []byte) error {
+	dict := pdfDict(body)
+	switch {
+	case pdfType(dict, "XRef"):
+		p.trailer(dict)
+	case pdfType(dict, "ObjStm"):
+		return p.unpack(body)
+	default:
+		p.objs[num] = pdfObject{gen, body}
+	}
+	return nil
+}
+
+// unpack adds the objects held in the object stream.
+func (p *pdf) unpack(body []byte) error {
+	data, err := pdfStreamData(body)
+	if err != nil {
+		return err
+	}
+	dict := pdfDict(body)
+	n, ok1 := pdfInt(dict, "N")
+	first, ok2 := pdfInt(dict, "First")
+	if !ok1 || !ok2 || first > len(data) {
+		return errPDF
+	}
+	fields := bytes.Fields(data[:first])
+	if len(fields) < 2*n {
+		return errPDF
+	}
+	for i := 0; i < n; i++ {
+		num, err1 := strconv.Atoi(string(fields[2*i]))
+		off, err2 := strconv.Atoi(string(fields[2*i+1]))
+		end := len(data) - first
+		if i+1 < n {
+			end, _ = strconv.Atoi(string(fields[2*i+3]))
+		}
+		if err1 != nil || err2 != nil || off < 0 || off > end || first+end > len(data) {
+			return errPDF
+		}
+		p.objs[num] = pdfObject{0, bytes.TrimSpace(data[first+off : first+end])}
+	}
+	return nil
+}
+
+// write returns the scrubbed file.
+func (p *pdf) write(version []byte) []byte {
+	keep := p.reachable()
+	var nums []int
+	for num := range keep {
+		nums = append(nums, num)
+	}
+	sort.Ints(nums)
+	var b bytes.Buffer
+	b.Write(version)
+	b.WriteString("\n%\xe2\xe3\xcf\xd3\n")
+	offsets := make(map[int]int)
+	if num, ok := pdfRefNum(p.info); ok && !keep[num] {
+		if o, ok := p.objs[num]; ok {
+			report(p.removed, "Info dictionary", int64(len(o.body)), 0)
+		}
+	}
+	for _, num := range nums {
+		o := p.objs[num]
+		offsets[num] = b.Len()
+		body := scrubPDFObject(o.body)
+		if len(body) != len(o.body) {
+			report(p.removed, "embedded file parameters", int64(len(o.body)-len(body)), 0)
+		}
+		fmt.Fprintf(&b, "%d %d obj\n%s\nendobj\n", num, o.gen, body)
+	}
+	size := 1
+	if len(nums) > 0 {
+		size = nums[len(nums)-1] + 1
+	}
+	xref := b.Len()
+	fmt.Fprintf(&b, "xref\n0 %d\n", size)
+	for num := 0; num < size; num++ {
+		if off, ok := offsets[num]; ok {
+			fmt.Fprintf(&b, "%010d %05d n\r\n", off, p.objs[num].gen)
+		} else {
+			b.WriteString("0000000000 65535 f\r\n")
+		}
+	}
+	fmt.Fprintf(&b, "trailer\n<</Size %d /Root %s %s>>\nstartxref\n%d\n%%%%EOF\n", size, p.root, p.id, xref)
+	return b.Bytes()
+}
+
+// reachable returns the numbers of the objects reachable from the
+// catalog, not counting metadata streams.
+func (p *pdf) reachable() map[int]bool {
+	keep := make(map[int]bool)
+	metadata := make(map[int]bool)
+	var visit func(ref []byte)
+	visit = func(ref []byte) {
+		num, _ := pdfRefNum(string(ref))
+		o, ok := p.objs[num]
+		if keep[num] || metadata[num] || !ok {
+			return
+		}
+		if pdfType(pdfDict(o.body), "Metadata") {
+			metadata[num] = true
+			report(p.removed, "metadata stream", int64(len(o.body)), 0)
+			return
+		}
+		keep[num] = true
+		for _, ref := range pdfRefRE.FindAll(pdfText(scrubPDFObject(o.body)), -1) {
+			visit(ref)
+		}
+	}
+	visit([]byte(p.root))
+	return keep
+}
+
+// scrubPDFObject returns the body of the object with any metadata
+// it holds itself deleted, which is to say the parameters of attached
+// files.
+func scrubPDFObject(body []byte) []byte {
+	dict := pdfDict(body)
+	if !pdfType(dict, "EmbeddedFile") {
+		return body
+	}
+	m := pdfParamsRE.FindSubmatchIndex(dict)
+	if m == nil {
+		return body
+	}
+	end := m[1]
+	if m[2] < 0 {
+		if end = dictEnd(dict, end); end < 0 {
+			return body
+		}
+	}
+	var b []byte
+	b = append(b, dict[:m[0]]...)
+	b = append(b, dict[end:]...)
+	return append(b, body[len(dict):]...)
+}
+
+// objectEnd returns the end of the body of the object starting at
+// data[start:], and the position after its endobj keyword, or -1 if
+// the object is malformed.
+func objectEnd(data []byte, start int) (end, next int) {
+	p := start
+	if q := skipSpace(data, p); bytes.HasPrefix(data[q:], []byte("<<")) {
+		if p = dictEnd(data, q); p < 0 {
+			return -1, -1
+		}
+		if m := pdfStreamRE.FindIndex(data[p:]); m != nil {
+			dataStart := p + m[1]
+			p = -1
+			if l := pdfLengthRE.FindSubmatch(data[q:dataStart]); l != nil && l[2] == nil {
+				n, _ := strconv.Atoi(string(l[1]))
+				if e := dataStart + n; n >= 0 && e <= len(data) && pdfEndStreamRE.Match(data[e:]) {
+					p = e
+				}
+			}
+			if p < 0 {
+				// The length is indirect or wrong; look for the keyword.
+				if p = bytes.Index(data[dataStart:], []byte("endstream")); p < 0 {
+					return -1, -1
+				}
+				p += dataStart
+			}
+			p += bytes.Index(data[p:], []byte("endstream")) + len("endstream")
+		}
+	}
+	i := bytes.Index(data[p:], []byte("endobj"))
+	if i < 0 {
+		return -1, -1
+	}
+	return p + i, p + i + len("endobj")
+}
+
+// dictEnd returns the position after the dictionary that begins at
+// data[start:] with <<, or -1 if it does not end.
+func dictEnd(data []byte, start int) int {
+	depth := 0
+	for p := start; p < len(data); p++ {
+		switch c := data[p]; c {
+		case '(':
+			// A string, in which parentheses nest unless escaped.
+			n := 0
+			for ; p < len(data); p++ {
+				switch data[p] {
+				case '\\':
+					p++
+				case '(':
+					n++
+				case ')':
+					n--
+				}
+				if n == 0 {
+					break
+				}
+			}
+		case '%':
+			for p < len(data) && data[p] != '\n' && data[p] != '\r' {
+				p++
+			}
+		case '<':
+			if p+1 < len(data) && data[p+1] == '<' {
+				depth++
+				p++
+			}
+		case '>':
+			if p+1 < len(data) && data[p+1] == '>' {
+				depth--
+				p++
+				if depth == 0 {
+					return p + 1
+				}
+			}
+		}
+	}
+	return -1
+}
+
+func skipSpace(data []byte, p int) int {
+	for p < len(data) && bytes.IndexByte([]byte(" \t\r\n\f\x00"), data[p]) >= 0 {
+		p++
+	}
+	return p
+}
+
+// pdfDict returns the dictionary at the start of the body of an object,
+// or nil if there is none.
+func pdfDict(body []byte) []byte {
+	if !bytes.HasPrefix(body, []byte("<<")) {
+		return nil
+	}
+	end := dictEnd(body, 0)
+	if end < 0 {
+		return nil
+	}
+	return body[:end]
+}
+
+// pdfText returns the body of the object without any stream data,
+// which holds no references to other objects.
+func pdfText(body []byte) []byte {
+	dict := pdfDict(body)
+	if dict != nil && pdfStreamRE.Match(body[len(dict):]) {
+		return dict
+	}
+	return body
+}
+
+// pdfType reports whether the dictionary has the given type. It looks
+// only for the key, which may also appear in a nested dictionary; for
+// the types scrubbing cares about, that does not happen.
+func pdfType(dict []byte, typ string) bool {
+	re := regexp.MustCompile(`/Type\s*/` + typ + `\b`)
+	return re.Match(dict)
+}
+
+// pdfRefNum returns the number of the object named by the reference.
+func pdfRefNum(ref string) (int, bool) {
+	m := pdfRefRE.FindStringSubmatch(ref)
+	if m == nil {
+		return 0, false
+	}
+	num, err := strconv.Atoi(m[1])
+	return num, err == nil
+}
+
+// pdfInt returns the integer value of the key in the dictionary.
+func pdfInt(dict []byte, key string) (int, bool) {
+	re := regexp.MustCompile(`/` + key + `\s+(\d+)\b`)
+	m := re.FindSubmatch(dict)
+	if m == nil {
+		return 0, false
+	}
+	n, err := strconv.Atoi(string(m[1]))
+	return n, err == nil
+}
+
+// pdfStreamData returns the decoded data of the stream object.
+func pdfStreamData(body []byte) ([]byte, error) {
+	dict := pdfDict(body)
+	m := pdfStreamRE.FindIndex(body[len(dict):])
+	end := bytes.LastIndex(body, []byte("endstream"))
+	if dict == nil || m == nil || end < len(dict)+m[1] {
+		return nil, errPDF
+	}
+	data := body[len(dict)+m[1] : end]
+	switch {
+	case bytes.Contains(dict, []byte("/DecodeParms")):
+		return nil, fmt.Errorf("unsupported PDF stream parameters")
+	case bytes.Contains(dict, []byte("/FlateDecode")):
+		zr, err := zlib.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return nil, err
+		}
+		return ioutil.ReadAll(zr)
+	case bytes.Contains(dict, []byte("/Filter")):
+		return nil, fmt.Errorf("unsupported PDF stream filter")
+	}
+	return data, nil
+}
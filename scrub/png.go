@@ -0,0 +1,92 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// A PNG file is a signature followed by chunks, each a length, a type,
+// the data, and a CRC of the type and data. Metadata lives in its own
+// ancillary chunks, so scrubbing is a matter of dropping them.
+
+var pngHeader = []byte("\x89PNG\r\n\x1a\n")
+
+// IsPNG reports whether the data begins with the PNG signature.
+func IsPNG(data []byte) bool {
+	return bytes.HasPrefix(data, pngHeader)
+}
+
+// pngMetadata lists the chunks that hold metadata: text, EXIF data,
+// and the modification time.
+var pngMetadata = map[string]bool{
+	"tEXt": true,
+	"zTXt": true,
+	"iTXt": true,
+	"eXIf": true,
+	"tIME": true,
+}
+
+// ScrubPNG reads a PNG file from r and writes it to w without its
+// metadata chunks. The chunks that remain are written with freshly
+// computed CRCs. Like Scrub, it works in constant space.
+func ScrubPNG(r io.Reader, w io.Writer) error {
+	return scrubPNG(r, w, nil)
+}
+
+func scrubPNG(r io.Reader, w io.Writer, removed func(Removal)) error {
+	header := make([]byte, len(pngHeader))
+	if _, err := io.ReadFull(r, header); err != nil {
+		return noEOF(err)
+	}
+	if !IsPNG(header) {
+		return fmt.Errorf("not a PNG file")
+	}
+	if _, err := w.Write(header); err != nil {
+		return err
+	}
+	var buf [8]byte
+	for {
+		if _, err := io.ReadFull(r, buf[:8]); err != nil {
+			return noEOF(err)
+		}
+		n := int64(binary.BigEndian.Uint32(buf[:4]))
+		typ := string(buf[4:8])
+		if n > 0x7FFFFFFF {
+			return fmt.Errorf("bad PNG chunk length %d", n)
+		}
+		if pngMetadata[typ] {
+			// Skip the data and the CRC.
+			if _, err := io.CopyN(ioutil.Discard, r, n+4); err != nil {
+				return noEOF(err)
+			}
+			report(removed, typ+" chunk", n+12, 0)
+			continue
+		}
+		if _, err := w.Write(buf[:8]); err != nil {
+			return err
+		}
+		crc := crc32.NewIEEE()
+		crc.Write(buf[4:8])
+		if _, err := io.CopyN(io.MultiWriter(w, crc), r, n); err != nil {
+			return noEOF(err)
+		}
+		if _, err := io.ReadFull(r, buf[:4]); err != nil {
+			return noEOF(err)
+		}
+		binary.BigEndian.PutUint32(buf[:4], crc.Sum32())
+		if _, err := w.Write(buf[:4]); err != nil {
+			return err
+		}
+		if typ == "IEND" {
+			return nil
+		}
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+)
+
+// A Photoshop file is a header, color mode data, a section of image
+// resources, the layers and masks, and the composite image. The image
+// resources are the blocks also found in JPEG APP13 segments; besides
+// settings such as the resolution and color profile they hold EXIF,
+// XMP, IPTC, thumbnails, and slices, whose names and URLs can reveal
+// much about a document's history. No offsets point past the resources,
+// so the rest of the file is copied unchanged. Large documents (PSB)
+// differ only in the later sections.
+
+var psdMagic = []byte("8BPS")
+
+// IsPSD reports whether the data begins like a Photoshop document.
+func IsPSD(data []byte) bool {
+	return bytes.HasPrefix(data, psdMagic)
+}
+
+// psdMetadata lists the image resources that hold metadata.
+var psdMetadata = map[uint16]bool{
+	0x03F0: true, // Caption.
+	0x0404: true, // IPTC.
+	0x0409: true, // Thumbnail, Photoshop 4.
+	0x040B: true, // URL.
+	0x040C: true, // Thumbnail.
+	0x041A: true, // Slices.
+	0x041E: true, // URL list.
+	0x0422: true, // EXIF data 1.
+	0x0423: true, // EXIF data 3.
+	0x0424: true, // XMP.
+	0x0425: true, // Caption digest.
+}
+
+// maxPSDResources is the size of the largest image resource section
+// that ScrubPSD will hold in memory.
+const maxPSDResources = 1 << 30
+
+var errPSD = errors.New("malformed Photoshop image resources")
+
+// ScrubPSD reads a Photoshop document from r and writes it to w without
+// the image resources that hold metadata. The layers and the composite
+// image are copied unchanged.
+func ScrubPSD(r io.Reader, w io.Writer) error {
+	return scrubPSD(r, w, nil)
+}
+
+func scrubPSD(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	// The header, then the length of the color mode data.
+	var hdr [30]byte
+	if _, err := io.ReadFull(br, hdr[:]); err != nil {
+		return noEOF(err)
+	}
+	if !IsPSD(hdr[:]) {
+		return fmt.Errorf("not a Photoshop file")
+	}
+	if v := binary.BigEndian.Uint16(hdr[4:]); v != 1 && v != 2 {
+		return fmt.Errorf("unknown Photoshop version %d", v)
+	}
+	if _, err := w.Write(hdr[:]); err != nil {
+		return err
+	}
+	if _, err := io.CopyN(w, br, int64(binary.BigEndian.Uint32(hdr[26:]))); err != nil {
+		return noEOF(err)
+	}
+	var size [4]byte
+	if _, err := io.ReadFull(br, size[:]); err != nil {
+		return noEOF(err)
+	}
+	n := binary.BigEndian.Uint32(size[:])
+	if n > maxPSDResources {
+		return fmt.Errorf("Photoshop image resources too large: %d bytes", n)
+	}
+	res, err := readN(br, nil, int64(n))
+	if err != nil {
+		return err
+	}
+	res, err = scrubPSDResources(res, removed)
+	if err != nil {
+		return err
+	}
+	binary.BigEndian.PutUint32(size[:], uint32(len(res)))
+	if _, err := w.Write(size[:]); err != nil {
+		return err
+	}
+	if _, err := w.Write(res); err != nil {
+		return err
+	}
+	_, err = io.Copy(w, br)
+	return err
+}
+
+// scrubPSDResources returns the image resource blocks in p without
+// those that hold metadata.
+func scrubPSDResources(p []byte, removed func(Removal)) ([]byte, error) {
+	var out []byte
+	for len(p) > 0 {
+		if len(p) < 12 {
+			return nil, errPSD
+		}
+		id := binary.BigEndian.Uint16(p[4:])
+		// The name is a Pascal string padded to an even length.
+		n := 6 + (1+int(p[6])+1)&^1
+		if n+4 > len(p) {
+			return nil, errPSD
+		}
+		size := int(binary.BigEndian.Uint32(p[n:]))
+		n += 4 + (size+1)&^1
+		if size < 0 || n > len(p) {
+			return nil, errPSD
+		}
+		if psdMetadata[id] {
+			report(removed, fmt.Sprintf("image resource 0x%04X", id), int64(n), 0)
+		} else {
+			out = append(out, p[:n]...)
+		}
+		p = p[n:]
+	}
+	return out, nil
+}
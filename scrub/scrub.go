@@ -0,0 +1,814 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+// Package scrub removes metadata from JPEG files. It deletes any App,
+// JPEG, or comment segment, leaving the image data intact. It also
+// removes the metadata from files in other formats, which Detect
+// recognizes by their contents.
+package scrub // import "robpike.io/cmd/scrub/scrub"
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"crypto/sha256"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sync"
+)
+
+const (
+	/* Constants all preceded by byte 0xFF */
+	TEM  = 0x01 /* Temporary private use in arithmetic coding */
+	SOF  = 0xC0 /* Start of Frame */
+	SOF2 = 0xC2 /* Start of Frame; progressive Huffman */
+	JPG  = 0xC8 /* Reserved for JPEG extensions */
+	DHT  = 0xC4 /* Define Huffman Tables */
+	DAC  = 0xCC /* Arithmetic coding conditioning */
+	RST  = 0xD0 /* Restart interval termination */
+	RST7 = 0xD7 /* Restart interval termination (highest value) */
+	SOI  = 0xD8 /* Start of Image */
+	EOI  = 0xD9 /* End of Image */
+	SOS  = 0xDA /* Start of Scan */
+	DQT  = 0xDB /* Define quantization tables */
+	DNL  = 0xDC /* Define number of lines */
+	DRI  = 0xDD /* Define restart interval */
+	DHP  = 0xDE /* Define hierarchical progression */
+	EXP  = 0xDF /* Expand reference components */
+	APPn = 0xE0 /* Reserved for application segments */
+	JPGn = 0xF0 /* Reserved for JPEG extensions */
+	COM  = 0xFE /* Comment */
+)
+
+// Scrub reads a JPEG file from r and writes it to w after deleting
+// any App, JPEG, or comment segment.
+func Scrub(r io.Reader, w io.Writer) error {
+	s := scanners.Get().(*Scanner)
+	s.Reset(r, w)
+	for s.Scan() {
+	}
+	err := s.Err()
+	s.Reset(nil, nil) // Don't hold on to r and w.
+	scanners.Put(s)
+	return err
+}
+
+// scanners holds Scanners for reuse by Scrub, saving allocation
+// when many images are scrubbed.
+var scanners = sync.Pool{
+	New: func() interface{} {
+		return NewScanner(nil, nil)
+	},
+}
+
+// ScrubContext is like Scrub but stops with the context's error
+// if the context is canceled or its deadline passes.
+func ScrubContext(ctx context.Context, r io.Reader, w io.Writer) error {
+	return Scrub(&ctxReader{ctx, r}, w)
+}
+
+// ctxReader is a Reader that fails once its context is done.
+type ctxReader struct {
+	ctx context.Context
+	r   io.Reader
+}
+
+func (c *ctxReader) Read(p []byte) (int, error) {
+	if err := c.ctx.Err(); err != nil {
+		return 0, err
+	}
+	return c.r.Read(p)
+}
+
+// Dimensions returns the width and height of the JPEG image read from r,
+// as recorded in its start-of-frame segment or, if that leaves the height
+// to be given after the image data, in the DNL segment following the
+// first scan.
+func Dimensions(r io.Reader) (width, height int, err error) {
+	s := NewScanner(r, nil)
+	sof := false
+	for s.Scan() {
+		seg := s.Segment()
+		switch {
+		case IsSOF(seg.Marker) && len(seg.Payload) >= 5 && !sof:
+			width, height = int2(seg.Payload[3:]), int2(seg.Payload[1:])
+			if height != 0 {
+				return width, height, nil
+			}
+			sof = true
+		case seg.Marker == DNL && sof && len(seg.Payload) >= 2:
+			return width, int2(seg.Payload), nil
+		}
+	}
+	if s.Err() != nil {
+		return 0, 0, s.Err()
+	}
+	if sof {
+		return 0, 0, fmt.Errorf("no DNL segment to give the height")
+	}
+	return 0, 0, fmt.Errorf("no start-of-frame segment")
+}
+
+// ImageDigest returns a SHA-256 hash of the parts of the JPEG stream
+// read from r that make up the image itself: the tables, the frame and
+// scan headers, and the entropy-coded data, but not the metadata, the
+// EOI marker, or any data after it. Scrubbing a file does not change its
+// digest, even if an EOI marker is added to a stream that lacks one.
+func ImageDigest(r io.Reader) ([sha256.Size]byte, error) {
+	var sum [sha256.Size]byte
+	h := sha256.New()
+	s := NewScanner(r, h)
+	s.Filter(func(marker byte, payload []byte) bool {
+		return KeepImage(marker, payload) && marker != EOI
+	})
+	s.DropTrailer(false)
+	for s.Scan() {
+	}
+	h.Sum(sum[:0])
+	return sum, s.Err()
+}
+
+// IsSOF reports whether the marker is one of the start-of-frame markers,
+// SOF0 through SOF15, which share their range with DHT, JPG, and DAC.
+func IsSOF(marker byte) bool {
+	return SOF <= marker && marker <= SOF+15 && marker != DHT && marker != JPG && marker != DAC
+}
+
+var markerNames = map[byte]string{
+	TEM: "TEM",
+	DHT: "DHT",
+	JPG: "JPG",
+	DAC: "DAC",
+	SOI: "SOI",
+	EOI: "EOI",
+	SOS: "SOS",
+	DQT: "DQT",
+	DNL: "DNL",
+	DRI: "DRI",
+	DHP: "DHP",
+	EXP: "EXP",
+	COM: "COM",
+}
+
+// MarkerName returns the conventional name of the marker,
+// such as SOI, SOF2, or APP1.
+func MarkerName(marker byte) string {
+	switch {
+	case IsSOF(marker):
+		return fmt.Sprintf("SOF%d", marker-SOF)
+	case RST <= marker && marker <= RST7:
+		return fmt.Sprintf("RST%d", marker-RST)
+	case APPn <= marker && marker < JPGn:
+		return fmt.Sprintf("APP%d", marker-APPn)
+	case JPGn <= marker && marker < COM:
+		return fmt.Sprintf("JPG%d", marker-JPGn)
+	}
+	if name, ok := markerNames[marker]; ok {
+		return name
+	}
+	return fmt.Sprintf("0x%.2X", marker)
+}
+
+// A Segment describes one marker segment of a JPEG stream.
+type Segment struct {
+	Marker  byte   // The marker code, the byte following 0xFF.
+	Offset  int64  // Offset in the input of the first byte of the segment.
+	Length  int    // Length in bytes of the segment, including the marker.
+	Payload []byte // Contents of the segment after the length field.
+	// Data is the number of bytes following the segment that belong
+	// to no segment: the entropy-coded data after SOS, or anything
+	// trailing the EOI marker.
+	Data int64
+	// Written is the number of bytes of the segment and its data
+	// written to the output: 0 if it was dropped, and otherwise
+	// Length+Data unless it was edited or its data was dropped.
+	Written int64
+	// Edited reports whether the segment or its data was written with
+	// different contents, as by the edit function, even if its length
+	// is unchanged.
+	Edited bool
+	// Inserted is the number of new segments, added by Insert, that
+	// were written before the segment.
+	Inserted int
+}
+
+// The errors a Scanner returns for malformed input. Each is wrapped in a
+// FormatError giving where it was found, so callers test for them with
+// errors.Is.
+var (
+	ErrTruncated = errors.New("unexpected end of input")
+	ErrBadMarker = errors.New("bad marker")
+	ErrBadLength = errors.New("bad segment length")
+)
+
+// A FormatError describes malformed input found by a Scanner.
+type FormatError struct {
+	Err    error  // ErrTruncated, ErrBadMarker, or ErrBadLength.
+	Offset int64  // Offset in the input of the segment or byte at fault.
+	Detail string // What was found, if more is known.
+}
+
+func (e *FormatError) Error() string {
+	if e.Detail == "" {
+		return fmt.Sprintf("%v at offset 0x%x", e.Err, e.Offset)
+	}
+	return fmt.Sprintf("%v at offset 0x%x: %s", e.Err, e.Offset, e.Detail)
+}
+
+func (e *FormatError) Unwrap() error {
+	return e.Err
+}
+
+// A Scanner reads a JPEG stream incrementally and writes the segments
+// it keeps to its output as it goes. Only one segment is held in memory
+// at a time, so arbitrarily large inputs are processed in constant space.
+// If another image follows the EOI marker, as in the concatenated frames
+// of an MJPEG file, its segments are scanned in turn, unless the image
+// before has a Multi-Picture Format index, which locates the images
+// after it by their offsets and so requires them to be left as they are.
+type Scanner struct {
+	r      *bufio.Reader
+	w      io.Writer
+	buf    []byte // bytes of the segment being read
+	offset int64  // offset in the input of the next byte
+	seg    Segment
+	filter func(marker byte, payload []byte) bool
+	edit   func(marker byte, payload []byte) []byte
+	keep   bool     // whether the current segment is being written
+	insert [][]byte // segments to write before the next one
+	warn   func(msg string)
+	trail  trailer // samples of the data after EOI
+	drop   bool    // whether to drop the data after EOI
+	zero   bool    // whether to write it as zeros instead
+	addEOI bool    // whether to add a missing EOI marker
+	resync bool    // whether to recover from damage by finding the next marker
+	strict bool    // whether problems are errors rather than warnings
+	soi    bool    // whether an image is to begin with the next marker
+	mpf    bool    // whether the current image has an MPF index
+	done   bool
+	err    error
+}
+
+// NewScanner returns a Scanner that reads from r and writes to w.
+// If w is nil, the output is discarded, which is useful when the
+// Scanner is used only to examine the segments. If w has a Flush
+// method, as a bufio.Writer does, it is called after each EOI marker
+// is written, so a stream of frames is passed on a frame at a time.
+func NewScanner(r io.Reader, w io.Writer) *Scanner {
+	s := &Scanner{
+		r:   bufio.NewReader(r),
+		buf: make([]byte, 0, 4096),
+	}
+	s.Reset(r, w)
+	return s
+}
+
+// Reset discards the Scanner's state and makes it read from r and
+// write to w, as if it were newly made by NewScanner, but reusing its
+// buffers. It allows a Scanner to process many images without allocation.
+func (s *Scanner) Reset(r io.Reader, w io.Writer) {
+	if w == nil {
+		w = ioutil.Discard
+	}
+	s.r.Reset(r)
+	*s = Scanner{
+		r:      s.r,
+		w:      w,
+		buf:    s.buf[:0],
+		filter: KeepImage,
+		soi:    true,
+	}
+}
+
+// Warn sets the function that reports problems the Scanner works
+// around, such as stray bytes between segments. By default they are
+// ignored.
+func (s *Scanner) Warn(f func(msg string)) {
+	s.warn = f
+}
+
+// Filter sets the function that decides which segments to keep.
+// It is called with the marker and payload of every segment, including
+// SOI, SOS, and EOI, and the segment is written to the output only if
+// it returns true. If the SOS segment is dropped, so is the entropy-coded
+// data that follows it. The payload must not be retained after the call.
+// The default filter is KeepImage.
+func (s *Scanner) Filter(f func(marker byte, payload []byte) bool) {
+	s.filter = f
+}
+
+// Edit sets a function that rewrites the segments the filter keeps.
+// It is called with the marker and payload of each kept segment that
+// has a length field, and returns the payload to write in its place,
+// or nil to drop the segment. The payload argument must not be retained
+// after the call. By default there is no editing.
+func (s *Scanner) Edit(f func(marker byte, payload []byte) []byte) {
+	s.edit = f
+}
+
+// Insert arranges for a new segment with the given marker and payload
+// to be written to the output before the next segment is. If called from
+// the filter or edit function, the new segment precedes the one being
+// considered; otherwise it follows the one most recently returned by Scan.
+func (s *Scanner) Insert(marker byte, payload []byte) error {
+	b, err := encodeSegment(marker, payload)
+	if err != nil {
+		return err
+	}
+	s.insert = append(s.insert, b)
+	return nil
+}
+
+// DropTrailer arranges for the data following the EOI marker, such as
+// the secondary images of a multi-picture file, to be dropped from the
+// output, or, if zero is true, overwritten with zeros of the same length.
+func (s *Scanner) DropTrailer(zero bool) {
+	s.drop = true
+	s.zero = zero
+}
+
+// AddEOI arranges for an EOI marker to be added to the output if the
+// input ends without one, as truncated files often do. Either way, the
+// missing marker is reported as a warning.
+func (s *Scanner) AddEOI() {
+	s.addEOI = true
+}
+
+// Resync makes the Scanner recover from damage to the structure of the
+// stream rather than stop with an error: garbage where a marker should
+// be is skipped up to the next marker, a segment with an impossible
+// length is dropped, and a segment whose length field is cut short by
+// the end of the input ends the stream. Each recovery is reported as a
+// warning.
+func (s *Scanner) Resync() {
+	s.resync = true
+}
+
+// Strict makes the Scanner stop with an error at any departure from
+// the standard, including those it would otherwise work around with a
+// warning, such as zero bytes between segments, a segment whose length
+// runs past the end of the input, or a missing EOI marker.
+func (s *Scanner) Strict() {
+	s.strict = true
+}
+
+// KeepImage is a filter that keeps the segments needed to display the
+// image and drops any App, JPEG, or comment segment.
+func KeepImage(marker byte, payload []byte) bool {
+	return marker < APPn
+}
+
+// Scan advances the Scanner to the next segment, which will then be
+// available through the Segment method, and writes it to the output
+// if the filter keeps it. It returns false when
+// the scan stops, either by reaching the end of the input or an error.
+func (s *Scanner) Scan() bool {
+	if s.done {
+		return false
+	}
+	s.err = s.segment()
+	if s.err != nil {
+		if s.err == io.EOF {
+			s.err = nil // The input ended between segments, without EOI.
+		}
+		s.done = true
+		return false
+	}
+	return true
+}
+
+// Segment returns the most recent segment read by a call to Scan.
+// The Payload is valid only until the next call to Scan.
+func (s *Scanner) Segment() Segment {
+	return s.seg
+}
+
+// Err returns the first error encountered by the Scanner. Malformed
+// input is reported by a *FormatError; the Scanner never panics.
+func (s *Scanner) Err() error {
+	return s.err
+}
+
+func (s *Scanner) ReadByte() (byte, error) {
+	c, err := s.r.ReadByte()
+	if err != nil {
+		return 0, s.truncated(err)
+	}
+	s.buf = append(s.buf, c)
+	s.offset++
+	return c, nil
+}
+
+func (s *Scanner) Read(n int) (data []byte, err error) {
+	start := len(s.buf)
+	if cap(s.buf)-start < n {
+		buf := make([]byte, start, start+n)
+		copy(buf, s.buf)
+		s.buf = buf
+	}
+	s.buf = s.buf[:start+n]
+	m, err := io.ReadFull(s.r, s.buf[start:])
+	s.offset += int64(m)
+	if err != nil {
+		s.buf = s.buf[:start+m]
+		return nil, s.truncated(err)
+	}
+	return s.buf[start:], nil
+}
+
+// noEOF converts io.EOF to io.ErrUnexpectedEOF, since the stream
+// should end only where the scanner expects it to.
+func noEOF(err error) error {
+	if err == io.EOF {
+		return io.ErrUnexpectedEOF
+	}
+	return err
+}
+
+// readN returns prefix followed by the next n bytes of r. It reads them
+// a piece at a time, so a length from a damaged header costs no more
+// memory than the input holds.
+func readN(r io.Reader, prefix []byte, n int64) ([]byte, error) {
+	buf := bytes.NewBuffer(append([]byte(nil), prefix...))
+	if _, err := io.CopyN(buf, r, n); err != nil {
+		return nil, noEOF(err)
+	}
+	return buf.Bytes(), nil
+}
+
+// truncated is noEOF for the Scanner, which reports where the input
+// ended with ErrTruncated.
+func (s *Scanner) truncated(err error) error {
+	if err == io.EOF || err == io.ErrUnexpectedEOF {
+		return &FormatError{ErrTruncated, s.offset, ""}
+	}
+	return err
+}
+
+// copy advances past b, which has been read from the input, writing it
+// to the output if the current segment is being kept.
+func (s *Scanner) copy(b []byte) error {
+	s.offset += int64(len(b))
+	return s.write(b)
+}
+
+// drain copies the rest of the input to the output
+// and returns the number of bytes copied.
+func (s *Scanner) drain() (int64, error) {
+	head, _ := s.r.Peek(trailerSample)
+	s.trail.head = append(s.trail.head[:0], head...)
+	r := io.TeeReader(s.r, &s.trail)
+	if !s.keep || s.drop && !s.zero {
+		n, err := io.Copy(ioutil.Discard, r)
+		s.offset += n
+		return n, err
+	}
+	w := s.w
+	if s.drop {
+		w = zeroWriter{w}
+	}
+	n, err := io.Copy(w, r)
+	s.offset += n
+	s.seg.Written += n
+	s.seg.Edited = s.drop && n > 0
+	return n, err
+}
+
+// zeroWriter writes zeros in place of the bytes written to it.
+type zeroWriter struct {
+	w io.Writer
+}
+
+func (z zeroWriter) Write(p []byte) (int, error) {
+	return z.w.Write(make([]byte, len(p)))
+}
+
+// entropy copies the entropy-coded data following an SOS segment,
+// stopping at the next marker, and returns the number of bytes copied.
+// Stuffed zero bytes and restart markers are part of the data. The next
+// marker may be that of a DNL segment, giving the height of an image
+// whose frame header left it out, which is then read as any other.
+func (s *Scanner) entropy() (int64, error) {
+	start := s.offset
+	for {
+		b, err := s.r.ReadSlice(0xFF)
+		switch err {
+		case nil:
+			// The 0xFF may begin a marker; leave it for now.
+			if err := s.copy(b[:len(b)-1]); err != nil {
+				return s.offset - start, err
+			}
+			s.r.UnreadByte()
+		case bufio.ErrBufferFull:
+			if err := s.copy(b); err != nil {
+				return s.offset - start, err
+			}
+			continue
+		case io.EOF:
+			// No EOI; the data runs to the end of the input.
+			s.done = true
+			return s.offset - start, s.copy(b)
+		default:
+			return s.offset - start, err
+		}
+		next, err := s.r.Peek(2)
+		if err != nil {
+			if err != io.EOF {
+				return s.offset - start, err
+			}
+			s.done = true
+			s.r.Discard(len(next))
+			return s.offset - start, s.copy(next)
+		}
+		if next[1] == 0xFF {
+			// A fill byte, which may precede a marker; look again
+			// after it.
+			if err := s.copy(next[:1]); err != nil {
+				return s.offset - start, err
+			}
+			s.r.Discard(1)
+			continue
+		}
+		if c := next[1]; c != 0 && (c < RST || RST7 < c) {
+			return s.offset - start, nil
+		}
+		// Stuffed zero or restart marker: all data.
+		if err := s.copy(next); err != nil {
+			return s.offset - start, err
+		}
+		s.r.Discard(2)
+	}
+}
+
+// header reads the marker of the next segment and, if it has them, its
+// length and payload. After a resync, a segment whose length is
+// impossible is dropped and the next one read in its place.
+func (s *Scanner) header() (byte, error) {
+	for {
+		s.buf = s.buf[:0]
+		s.seg = Segment{Offset: s.offset}
+		first := s.soi
+		c, err := s.marker()
+		if errors.Is(err, ErrTruncated) && !first && (s.offset == s.seg.Offset || s.resync) {
+			if err := s.missingEOI(); err != nil {
+				return 0, err
+			}
+			return 0, io.EOF
+		}
+		if err != nil {
+			return 0, err
+		}
+		if first && c != SOI {
+			return 0, &FormatError{ErrBadMarker, s.seg.Offset, fmt.Sprintf("expected SOI, found 0x%.2x", c)}
+		}
+		s.soi = false
+		s.seg.Marker = c
+		switch {
+		case standalone(c):
+			// No length or payload.
+		case c == 0:
+			return 0, &FormatError{ErrBadMarker, s.offset - 1, "found 0x00"}
+		default:
+			buf, err := s.Read(2)
+			if err != nil {
+				if errors.Is(err, ErrTruncated) && s.resync {
+					if err := s.problem(&FormatError{ErrTruncated, s.seg.Offset, MarkerName(c) + " segment cut short"}, "dropped"); err != nil {
+						return 0, err
+					}
+					if err := s.missingEOI(); err != nil {
+						return 0, err
+					}
+					return 0, io.EOF
+				}
+				return 0, err
+			}
+			n := int2(buf[0:2])
+			if n < 2 {
+				if s.resync {
+					if err := s.problem(&FormatError{ErrBadLength, s.seg.Offset, fmt.Sprintf("%s segment of length %d", MarkerName(c), n)}, "dropped"); err != nil {
+						return 0, err
+					}
+					continue // Look for the next marker.
+				}
+				return 0, &FormatError{ErrBadLength, s.seg.Offset, fmt.Sprintf("%s segment of length %d", MarkerName(c), n)}
+			}
+			hdr := len(s.buf) - 2
+			if s.seg.Payload, err = s.Read(n - 2); err != nil {
+				if !errors.Is(err, ErrTruncated) {
+					return 0, err
+				}
+				// The length runs past the end of the input. Keep what
+				// there is, with the length to match; the stream ends here.
+				s.seg.Payload = s.buf[hdr+2:]
+				m := len(s.seg.Payload) + 2
+				if err := s.problem(&FormatError{ErrTruncated, s.seg.Offset, fmt.Sprintf("%s segment of length %d runs past the end", MarkerName(c), n)}, fmt.Sprintf("length clamped to %d", m)); err != nil {
+					return 0, err
+				}
+				s.buf[hdr], s.buf[hdr+1] = byte(m>>8), byte(m)
+			}
+		}
+		return c, nil
+	}
+}
+
+// segment reads the next segment and copies it to the output
+// if the filter keeps it.
+func (s *Scanner) segment() error {
+	c, err := s.header()
+	if err != nil {
+		return err
+	}
+	s.seg.Length = len(s.buf)
+	if c == APPn+2 && IsMPF(s.seg.Payload) {
+		s.mpf = true
+	}
+	s.keep = s.filter(c, s.seg.Payload)
+	out := s.buf
+	if s.keep && s.edit != nil && s.seg.Payload != nil {
+		payload := s.edit(c, s.seg.Payload)
+		if payload == nil {
+			s.keep = false
+		} else if out, err = encodeSegment(c, payload); err != nil {
+			return err
+		}
+		s.seg.Edited = payload != nil && !bytes.Equal(payload, s.seg.Payload)
+	}
+	s.seg.Inserted = len(s.insert)
+	for _, b := range s.insert {
+		if _, err := s.w.Write(b); err != nil {
+			return err
+		}
+	}
+	s.insert = s.insert[:0]
+	if err := s.write(out); err != nil {
+		return err
+	}
+	switch c {
+	case SOS:
+		// This is real data; copy it through.
+		s.seg.Data, err = s.entropy()
+		if err == nil && s.done {
+			err = s.missingEOI()
+		}
+	case EOI:
+		// Flush before looking for another image, which may not
+		// come for a while if the input is a live stream.
+		if err := s.flush(); err != nil {
+			return err
+		}
+		if s.another() {
+			s.soi, s.mpf = true, false
+			break
+		}
+		s.done = true
+		s.seg.Data, err = s.drain()
+	}
+	return err
+}
+
+// flush flushes the output, if it can be flushed.
+func (s *Scanner) flush() error {
+	if f, ok := s.w.(interface{ Flush() error }); ok {
+		return f.Flush()
+	}
+	return nil
+}
+
+// another reports whether another image, to be scanned, follows the
+// EOI marker just read.
+func (s *Scanner) another() bool {
+	if s.drop || s.mpf {
+		return false
+	}
+	b, _ := s.r.Peek(3)
+	return len(b) == 3 && b[0] == 0xFF && b[1] == SOI && b[2] == 0xFF
+}
+
+// missingEOI reports that the input ended without an EOI marker and,
+// if AddEOI was called, adds one.
+func (s *Scanner) missingEOI() error {
+	if err := s.problem(&FormatError{ErrTruncated, s.offset, "missing EOI marker"}, ""); err != nil {
+		return err
+	}
+	if !s.addEOI {
+		return nil
+	}
+	_, err := s.w.Write([]byte{0xFF, EOI})
+	return err
+}
+
+// problem reports a departure from the standard that the Scanner can
+// work around, and how it did, as a warning, or, if Strict was called,
+// returns it as an error.
+func (s *Scanner) problem(err *FormatError, fix string) error {
+	if s.strict {
+		return err
+	}
+	msg := err.Error()
+	if fix != "" {
+		msg += "; " + fix
+	}
+	if s.warn != nil {
+		s.warn(msg)
+	}
+	return nil
+}
+
+// standalone reports whether the marker stands alone, without a length
+// or payload: SOI, EOI, TEM, and the restart markers, which, though they
+// belong in the entropy-coded data, can be found between segments.
+func standalone(marker byte) bool {
+	return marker == SOI || marker == EOI || marker == TEM || RST <= marker && marker <= RST7
+}
+
+// write writes b to the output if the current segment is being kept.
+func (s *Scanner) write(b []byte) error {
+	if !s.keep {
+		return nil
+	}
+	n, err := s.w.Write(b)
+	s.seg.Written += int64(n)
+	return err
+}
+
+func (s *Scanner) marker() (byte, error) {
+	var c byte
+	var err error
+	for {
+		c, err = s.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+		if c != 0 {
+			break
+		}
+		if err := s.problem(&FormatError{ErrBadMarker, s.offset - 1, "found 0x00"}, "skipped"); err != nil {
+			return 0, err
+		}
+	}
+	if c != 0xFF {
+		if !s.resync {
+			return 0, &FormatError{ErrBadMarker, s.offset - 1, fmt.Sprintf("found 0x%.2x", c)}
+		}
+		if err := s.skipGarbage(); err != nil {
+			return 0, err
+		}
+		c = 0xFF
+	}
+	for c == 0xFF {
+		c, err = s.ReadByte()
+		if err != nil {
+			return 0, err
+		}
+	}
+	return c, nil
+}
+
+// skipGarbage skips the bytes up to and including the 0xFF that begins
+// the next marker, which cannot be one that stands alone, other than
+// EOI, since the byte pairs of garbage often look like those. The
+// skipped bytes are dropped from the segment.
+func (s *Scanner) skipGarbage() error {
+	start := s.offset - 1
+	for {
+		c, err := s.ReadByte()
+		if err != nil {
+			return err
+		}
+		if c != 0xFF {
+			continue
+		}
+		next, err := s.r.Peek(1)
+		if err != nil {
+			return s.truncated(err)
+		}
+		if m := next[0]; m != 0 && m != 0xFF && (!standalone(m) || m == EOI) {
+			break
+		}
+	}
+	if err := s.problem(&FormatError{ErrBadMarker, start, fmt.Sprintf("%d bytes of garbage", s.offset-1-start)}, "skipped"); err != nil {
+		return err
+	}
+	s.buf = append(s.buf[:0], 0xFF)
+	s.seg.Offset = s.offset - 1
+	return nil
+}
+
+// encodeSegment returns the bytes of a segment with the given
+// marker and payload.
+func encodeSegment(marker byte, payload []byte) ([]byte, error) {
+	n := len(payload) + 2
+	if n > 0xFFFF {
+		return nil, fmt.Errorf("0x%.2x segment too long: %d bytes", marker, n)
+	}
+	b := make([]byte, 0, 2+n)
+	b = append(b, 0xFF, marker, byte(n>>8), byte(n))
+	return append(b, payload...), nil
+}
+
+func int2(b []byte) int {
+	return int(b[0])<<8 + int(b[1])
+}
@@ -0,0 +1,346 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+	"testing"
+)
+
+// seg returns a JPEG segment with the marker and payload.
+func seg(marker byte, payload string) string {
+	n := len(payload) + 2
+	return string([]byte{0xFF, marker, byte(n >> 8), byte(n)}) + payload
+}
+
+// The pieces of a small JPEG stream. The entropy-coded data is not real,
+// but the Scanner does not decode it.
+var (
+	soi  = "\xFF\xD8"
+	eoi  = "\xFF\xD9"
+	app0 = seg(APPn, "JFIF\x00\x01\x01\x00\x00\x01\x00\x01\x00\x00")
+	app1 = seg(APPn+1, "Exif\x00\x00secret")
+	com  = seg(COM, "secret")
+	dqt  = seg(DQT, "\x00"+strings.Repeat("\x01", 64))
+	sof  = seg(SOF, "\x08\x00\x10\x00\x20\x01\x01\x11\x00") // 32×16.
+	sos  = seg(SOS, "\x01\x01\x00\x00\x3F\x00")
+	mpf  = seg(APPn+2, "MPF\x00II*\x00")
+	// image is the part of a file that scrubbing keeps.
+	image = dqt + sof + sos + "data"
+)
+
+var scrubTests = []struct {
+	name string
+	in   string
+	out  string
+}{
+	{"clean", soi + image + eoi, soi + image + eoi},
+	{"metadata", soi + app0 + app1 + image + com + eoi, soi + image + eoi},
+	{"comment after scan", soi + image + com + sos + "more" + eoi, soi + image + sos + "more" + eoi},
+	{"fill bytes before marker", soi + image + "\xFF\xFF\xFF" + eoi, soi + image + "\xFF\xFF\xFF" + eoi},
+	{"fill bytes between segments", soi + "\xFF" + app1 + image + eoi, soi + image + eoi},
+	{"stuffed zero", soi + image + "\xFF\x00x" + eoi, soi + image + "\xFF\x00x" + eoi},
+	{"restart markers", soi + image + "\xFF\xD0x\xFF\xD7y" + eoi, soi + image + "\xFF\xD0x\xFF\xD7y" + eoi},
+	{"restart marker between segments", soi + "\xFF\xD0" + image + eoi, soi + "\xFF\xD0" + image + eoi},
+	{"concatenated", soi + com + image + eoi + soi + app1 + image + eoi, soi + image + eoi + soi + image + eoi},
+	{"trailer", soi + image + eoi + "trailer", soi + image + eoi + "trailer"},
+	// The MPF index locates the second image by its offset, so it is kept whole.
+	{"multi-picture", soi + mpf + image + eoi + soi + com + image + eoi, soi + image + eoi + soi + com + image + eoi},
+	// A missing EOI is a warning by default.
+	{"no EOI", soi + image, soi + image},
+}
+
+func TestScrub(t *testing.T) {
+	for _, test := range scrubTests {
+		var out bytes.Buffer
+		if err := Scrub(strings.NewReader(test.in), &out); err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if out.String() != test.out {
+			t.Errorf("%s: got %q; want %q", test.name, out.String(), test.out)
+		}
+	}
+}
+
+var scannerTests = []struct {
+	name  string
+	in    string
+	setup func(s *Scanner)
+	out   string
+	err   error // Matched with errors.Is.
+	warn  bool  // Whether a warning is expected.
+}{
+	{"trailer dropped", soi + image + eoi + "trailer", func(s *Scanner) { s.DropTrailer(false) }, soi + image + eoi, nil, false},
+	{"trailer zeroed", soi + image + eoi + "trailer", func(s *Scanner) { s.DropTrailer(true) }, soi + image + eoi + "\x00\x00\x00\x00\x00\x00\x00", nil, false},
+	{"multi-picture dropped", soi + mpf + image + eoi + soi + image + eoi, func(s *Scanner) { s.DropTrailer(false) }, soi + image + eoi, nil, false},
+	{"no EOI", soi + image, nil, soi + image, nil, true},
+	{"no EOI added", soi + image, (*Scanner).AddEOI, soi + image + eoi, nil, true},
+	{"no EOI after segment", soi + dqt, nil, soi + dqt, nil, true},
+	{"not a JPEG", "GIF89a", nil, "", ErrBadMarker, false},
+	{"garbage", soi + "junk" + image + eoi, nil, soi, ErrBadMarker, false},
+	{"bad length", soi + "\xFF\xE1\x00\x01" + image + eoi, nil, soi, ErrBadLength, false},
+	{"length cut short", soi + dqt + "\xFF\xDB\x00", nil, soi + dqt, ErrTruncated, false},
+	{"garbage resync", soi + "junk" + image + eoi, (*Scanner).Resync, soi + image + eoi, nil, true},
+	{"bad length resync", soi + "\xFF\xE1\x00\x01" + image + eoi, (*Scanner).Resync, soi + image + eoi, nil, true},
+	{"length cut short resync", soi + dqt + "\xFF\xDB\x00", (*Scanner).Resync, soi + dqt, nil, true},
+	{"zero between segments", soi + "\x00" + image + eoi, nil, soi + "\x00" + image + eoi, nil, true},
+	{"zero between segments strict", soi + "\x00" + image + eoi, (*Scanner).Strict, soi, ErrBadMarker, false},
+	{"no EOI strict", soi + image, (*Scanner).Strict, soi + image, ErrTruncated, false},
+	{"segment runs past end", soi + "\xFF\xDB\x00\x10abc", nil, soi + "\xFF\xDB\x00\x05abc", nil, true},
+	{"segment runs past end strict", soi + "\xFF\xDB\x00\x10abc", (*Scanner).Strict, soi, ErrTruncated, false},
+}
+
+func TestScanner(t *testing.T) {
+	for _, test := range scannerTests {
+		var out bytes.Buffer
+		s := NewScanner(strings.NewReader(test.in), &out)
+		warned := false
+		s.Warn(func(string) { warned = true })
+		if test.setup != nil {
+			test.setup(s)
+		}
+		for s.Scan() {
+		}
+		err := s.Err()
+		switch {
+		case test.err == nil && err != nil:
+			t.Errorf("%s: unexpected error %v", test.name, err)
+		case test.err != nil && !errors.Is(err, test.err):
+			t.Errorf("%s: got error %v; want %v", test.name, err, test.err)
+		}
+		var fe *FormatError
+		if err != nil && !errors.As(err, &fe) {
+			t.Errorf("%s: error %v is not a *FormatError", test.name, err)
+		}
+		if out.String() != test.out {
+			t.Errorf("%s: got %q; want %q", test.name, out.String(), test.out)
+		}
+		if warned != test.warn {
+			t.Errorf("%s: warned=%t; want %t", test.name, warned, test.warn)
+		}
+	}
+}
+
+func TestSegments(t *testing.T) {
+	in := soi + app1 + image + eoi
+	want := []Segment{
+		{Marker: SOI, Offset: 0, Length: 2},
+		{Marker: APPn + 1, Offset: 2, Length: len(app1), Payload: []byte(app1[4:])},
+		{Marker: DQT, Offset: int64(2 + len(app1)), Length: len(dqt)},
+		{Marker: SOF, Offset: int64(2 + len(app1) + len(dqt)), Length: len(sof)},
+		{Marker: SOS, Offset: int64(2 + len(app1) + len(dqt) + len(sof)), Length: len(sos), Data: 4},
+		{Marker: EOI, Offset: int64(len(in) - 2), Length: 2},
+	}
+	s := NewScanner(strings.NewReader(in), nil)
+	i := 0
+	for ; s.Scan(); i++ {
+		if i >= len(want) {
+			continue
+		}
+		// The payload is valid only until the next Scan.
+		g, w := s.Segment(), want[i]
+		if g.Marker != w.Marker || g.Offset != w.Offset || g.Length != w.Length || g.Data != w.Data {
+			t.Errorf("segment %d: got %s at %d, length %d, data %d; want %s at %d, length %d, data %d", i,
+				MarkerName(g.Marker), g.Offset, g.Length, g.Data, MarkerName(w.Marker), w.Offset, w.Length, w.Data)
+		}
+		if w.Payload != nil && !bytes.Equal(g.Payload, w.Payload) {
+			t.Errorf("segment %d: payload %q; want %q", i, g.Payload, w.Payload)
+		}
+	}
+	if s.Err() != nil {
+		t.Fatal(s.Err())
+	}
+	if i != len(want) {
+		t.Errorf("got %d segments; want %d", i, len(want))
+	}
+}
+
+func TestTrailer(t *testing.T) {
+	tests := []struct {
+		trailer string
+		kind    string
+	}{
+		{"", ""},
+		{soi + image + eoi, "JPEG image"},
+		{"PK\x03\x04archive", "ZIP archive"},
+		{"\x00\x00\x00\x18ftypmp42 and the rest of a video", "MP4 video"},
+		{strings.Repeat("x", 100) + "SEFH....SEFT", "Samsung trailer"},
+		{strings.Repeat("\x00", 100), "zero padding"},
+		{"junk", "unknown data"},
+	}
+	for _, test := range tests {
+		s := NewScanner(strings.NewReader(soi+image+eoi+test.trailer), nil)
+		s.DropTrailer(false)
+		for s.Scan() {
+		}
+		if s.Err() != nil {
+			t.Errorf("%q: %v", test.trailer, s.Err())
+		}
+		if kind := s.Trailer(); kind != test.kind {
+			t.Errorf("%q: got %q; want %q", test.trailer, kind, test.kind)
+		}
+	}
+}
+
+// TestSegmentStatus checks what the Segment reports about how it was
+// written, in particular that an edit that keeps the length is reported.
+func TestSegmentStatus(t *testing.T) {
+	same := "Exif\x00\x00SECRET" // The same length as app1's payload.
+	tests := []struct {
+		name    string
+		in      string
+		setup   func(s *Scanner)
+		marker  byte // The segment to check.
+		written int64
+		edited  bool
+		insert  int
+	}{
+		{"kept", soi + app1 + image + eoi, keepAll, APPn + 1, int64(len(app1)), false, 0},
+		{"dropped", soi + app1 + image + eoi, nil, APPn + 1, 0, false, 0},
+		{"edited same length", soi + app1 + image + eoi, editTo(same), APPn + 1, int64(len(app1)), true, 0},
+		{"edited unchanged", soi + app1 + image + eoi, editTo(app1[4:]), APPn + 1, int64(len(app1)), false, 0},
+		{"edited shorter", soi + app1 + image + eoi, editTo("Exif\x00\x00"), APPn + 1, int64(len(app1)) - 6, true, 0},
+		{"edited away", soi + app1 + image + eoi, editTo(""), APPn + 1, 0, false, 0},
+		{"inserted", soi + image + eoi, insertBefore(SOF), SOF, int64(len(sof)), false, 1},
+		{"scan data", soi + image + eoi, nil, SOS, int64(len(sos) + len("data")), false, 0},
+		{"trailer kept", soi + image + eoi + "trailer", nil, EOI, 2 + 7, false, 0},
+		{"trailer dropped", soi + image + eoi + "trailer", func(s *Scanner) { s.DropTrailer(false) }, EOI, 2, false, 0},
+		{"trailer zeroed", soi + image + eoi + "trailer", func(s *Scanner) { s.DropTrailer(true) }, EOI, 2 + 7, true, 0},
+	}
+	for _, test := range tests {
+		s := NewScanner(strings.NewReader(test.in), nil)
+		if test.setup != nil {
+			test.setup(s)
+		}
+		found := false
+		for s.Scan() {
+			seg := s.Segment()
+			if seg.Marker != test.marker {
+				continue
+			}
+			found = true
+			if seg.Written != test.written || seg.Edited != test.edited || seg.Inserted != test.insert {
+				t.Errorf("%s: Written=%d Edited=%t Inserted=%d; want %d %t %d", test.name,
+					seg.Written, seg.Edited, seg.Inserted, test.written, test.edited, test.insert)
+			}
+		}
+		if s.Err() != nil {
+			t.Errorf("%s: %v", test.name, s.Err())
+		}
+		if !found {
+			t.Errorf("%s: no %s segment", test.name, MarkerName(test.marker))
+		}
+	}
+}
+
+func keepAll(s *Scanner) {
+	s.Filter(func(byte, []byte) bool { return true })
+}
+
+// editTo returns a setup that keeps every segment and replaces the
+// payload of any APP1 segment; an empty payload drops it.
+func editTo(payload string) func(s *Scanner) {
+	return func(s *Scanner) {
+		keepAll(s)
+		s.Edit(func(marker byte, p []byte) []byte {
+			switch {
+			case marker != APPn+1:
+				return p
+			case payload == "":
+				return nil
+			}
+			return []byte(payload)
+		})
+	}
+}
+
+// insertBefore returns a setup that inserts a comment before the
+// segment with the marker.
+func insertBefore(marker byte) func(s *Scanner) {
+	return func(s *Scanner) {
+		s.Filter(func(m byte, p []byte) bool {
+			if m == marker {
+				s.Insert(COM, []byte("new"))
+			}
+			return KeepImage(m, p)
+		})
+	}
+}
+
+func TestDimensions(t *testing.T) {
+	noHeight := seg(SOF, "\x08\x00\x00\x00\x20\x01\x01\x11\x00")
+	tests := []struct {
+		name string
+		in   string
+		w, h int
+		ok   bool
+	}{
+		{"SOF", soi + image + eoi, 32, 16, true},
+		{"DNL", soi + dqt + noHeight + sos + "data" + seg(DNL, "\x00\x30") + eoi, 32, 48, true},
+		{"DNL after fill", soi + dqt + noHeight + sos + "data\xFF" + seg(DNL, "\x00\x30") + eoi, 32, 48, true},
+		{"no DNL", soi + dqt + noHeight + sos + "data" + eoi, 0, 0, false},
+		{"no SOF", soi + dqt + eoi, 0, 0, false},
+	}
+	for _, test := range tests {
+		w, h, err := Dimensions(strings.NewReader(test.in))
+		if (err == nil) != test.ok || w != test.w || h != test.h {
+			t.Errorf("%s: got %d×%d, %v; want %d×%d", test.name, w, h, err, test.w, test.h)
+		}
+	}
+}
+
+func TestImageDigest(t *testing.T) {
+	plain, err := ImageDigest(strings.NewReader(soi + image + eoi))
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, in := range []string{
+		soi + app1 + image + com + eoi,
+		soi + image + eoi + "trailer",
+		soi + image, // No EOI, which AddEOI would add.
+	} {
+		sum, err := ImageDigest(strings.NewReader(in))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if sum != plain {
+			t.Errorf("digest of %q differs from that of the plain image", in)
+		}
+	}
+	sum, _ := ImageDigest(strings.NewReader(soi + dqt + sof + sos + "other" + eoi))
+	if sum == plain {
+		t.Errorf("digest does not depend on the image data")
+	}
+}
+
+func TestSplitMotionPhoto(t *testing.T) {
+	video := newBox("ftyp", "mp42\x00\x00\x00\x00isom") + newBox("mdat", "frames")
+	xmp := seg(APPn+1, string(MotionPhotoXMP(len(video))))
+	tests := []struct {
+		name  string
+		in    string
+		image string
+		video string
+		err   bool
+	}{
+		{"still", soi + image + eoi + video, soi + image + eoi + video, "", false},
+		{"Google", soi + xmp + image + eoi + video, soi + xmp + image + eoi, video, false},
+		{"Samsung", soi + xmp + image + eoi + "MotionPhoto_Data" + video + "SEFT", soi + xmp + image + eoi + "MotionPhoto_Data", video, false},
+		{"no video", soi + xmp + image + eoi, soi + xmp + image + eoi, "", true},
+	}
+	for _, test := range tests {
+		image, video, err := SplitMotionPhoto([]byte(test.in))
+		if (err != nil) != test.err {
+			t.Errorf("%s: got error %v; want error %t", test.name, err, test.err)
+			continue
+		}
+		if string(image) != test.image || string(video) != test.video {
+			t.Errorf("%s: got %q, %q; want %q, %q", test.name, image, video, test.image, test.video)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// An SVG image is an XML document. Editors fill it with things that
+// do not affect the drawing: a metadata element holding RDF with the
+// author and license, Inkscape and Sodipodi elements and attributes
+// recording the document's name, the paths it was exported to, and
+// the editor's window, and comments naming the program that wrote it.
+// Namespaces are recognized by their usual prefixes.
+
+// IsSVG reports whether the data begins like an SVG image: an svg
+// element, perhaps after an XML declaration, comments, and a document
+// type declaration.
+func IsSVG(data []byte) bool {
+	p := bytes.TrimPrefix(data, []byte("\xef\xbb\xbf"))
+	for {
+		p = bytes.TrimLeft(p, " \t\r\n")
+		var end string
+		switch {
+		case bytes.HasPrefix(p, []byte("<svg")):
+			return len(p) > 4 && strings.IndexByte(" \t\r\n>/", p[4]) >= 0
+		case bytes.HasPrefix(p, []byte("<!--")):
+			end = "-->"
+		case bytes.HasPrefix(p, []byte("<?")), bytes.HasPrefix(p, []byte("<!DOCTYPE svg")):
+			end = ">"
+		default:
+			return false
+		}
+		i := bytes.Index(p, []byte(end))
+		if i < 0 {
+			return false
+		}
+		p = p[i+len(end):]
+	}
+}
+
+// svgEditors lists the namespace prefixes of editors' private elements
+// and attributes.
+var svgEditors = map[string]bool{
+	"inkscape": true,
+	"sodipodi": true,
+}
+
+// ScrubSVG reads an SVG image from r and writes it to w without its
+// comments, metadata and RDF elements, and editor-specific elements
+// and attributes.
+func ScrubSVG(r io.Reader, w io.Writer) error {
+	return scrubSVG(r, w, nil)
+}
+
+func scrubSVG(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	out, err := filterXML(data, svgDropElement, svgDropAttr, removed)
+	if err != nil {
+		return err
+	}
+	_, err = w.Write(out)
+	return err
+}
+
+// svgDropElement reports whether the named element is to be dropped,
+// with its contents.
+func svgDropElement(parent, name string, tag []byte) bool {
+	prefix, local := xmlPrefix(name)
+	return local == "metadata" || prefix == "rdf" && local == "RDF" || svgEditors[prefix]
+}
+
+// svgDropAttr reports whether the attribute is to be dropped.
+func svgDropAttr(name string) bool {
+	prefix, local := xmlPrefix(name)
+	return svgEditors[prefix] || prefix == "xmlns" && svgEditors[local]
+}
@@ -0,0 +1,214 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import "fmt"
+
+// tagNames gives the names of the EXIF tags, as used by ExifTool, which
+// differ for a few from the standard's, such as CreateDate for
+// DateTimeDigitized. Tags in IFD1 share the names of IFD0.
+var tagNames = map[Tag]string{
+	// IFD0.
+	{IFD0, 0x000B}: "ProcessingSoftware",
+	{IFD0, 0x00FE}: "SubfileType",
+	{IFD0, 0x0100}: "ImageWidth",
+	{IFD0, 0x0101}: "ImageHeight",
+	{IFD0, 0x0102}: "BitsPerSample",
+	{IFD0, 0x0103}: "Compression",
+	{IFD0, 0x0106}: "PhotometricInterpretation",
+	{IFD0, 0x010D}: "DocumentName",
+	{IFD0, 0x010E}: "ImageDescription",
+	{IFD0, 0x010F}: "Make",
+	{IFD0, 0x0110}: "Model",
+	{IFD0, 0x0111}: "StripOffsets",
+	{IFD0, 0x0112}: "Orientation",
+	{IFD0, 0x0115}: "SamplesPerPixel",
+	{IFD0, 0x0116}: "RowsPerStrip",
+	{IFD0, 0x0117}: "StripByteCounts",
+	{IFD0, 0x011A}: "XResolution",
+	{IFD0, 0x011B}: "YResolution",
+	{IFD0, 0x011C}: "PlanarConfiguration",
+	{IFD0, 0x011D}: "PageName",
+	{IFD0, 0x0128}: "ResolutionUnit",
+	{IFD0, 0x0129}: "PageNumber",
+	{IFD0, 0x012D}: "TransferFunction",
+	{IFD0, 0x0131}: "Software",
+	{IFD0, 0x0132}: "ModifyDate",
+	{IFD0, 0x013B}: "Artist",
+	{IFD0, 0x013C}: "HostComputer",
+	{IFD0, 0x013E}: "WhitePoint",
+	{IFD0, 0x013F}: "PrimaryChromaticities",
+	{IFD0, 0x0201}: "ThumbnailOffset",
+	{IFD0, 0x0202}: "ThumbnailLength",
+	{IFD0, 0x0211}: "YCbCrCoefficients",
+	{IFD0, 0x0212}: "YCbCrSubSampling",
+	{IFD0, 0x0213}: "YCbCrPositioning",
+	{IFD0, 0x0214}: "ReferenceBlackWhite",
+	{IFD0, 0x02BC}: "XMP",
+	{IFD0, 0x4746}: "Rating",
+	{IFD0, 0x4749}: "RatingPercent",
+	{IFD0, 0x8298}: "Copyright",
+	{IFD0, 0x83BB}: "IPTC",
+	{IFD0, 0x8649}: "PhotoshopSettings",
+	{IFD0, 0x8769}: "ExifOffset",
+	{IFD0, 0x8773}: "ICCProfile",
+	{IFD0, 0x8825}: "GPSInfo",
+	{IFD0, 0x9C9B}: "XPTitle",
+	{IFD0, 0x9C9C}: "XPComment",
+	{IFD0, 0x9C9D}: "XPAuthor",
+	{IFD0, 0x9C9E}: "XPKeywords",
+	{IFD0, 0x9C9F}: "XPSubject",
+	{IFD0, 0xA480}: "GDALMetadata",
+	{IFD0, 0xC4A5}: "PrintIM",
+
+	// Exif IFD.
+	{ExifIFD, 0x829A}: "ExposureTime",
+	{ExifIFD, 0x829D}: "FNumber",
+	{ExifIFD, 0x8822}: "ExposureProgram",
+	{ExifIFD, 0x8824}: "SpectralSensitivity",
+	{ExifIFD, 0x8827}: "ISO",
+	{ExifIFD, 0x8830}: "SensitivityType",
+	{ExifIFD, 0x8832}: "RecommendedExposureIndex",
+	{ExifIFD, 0x9000}: "ExifVersion",
+	{ExifIFD, 0x9003}: "DateTimeOriginal",
+	{ExifIFD, 0x9004}: "CreateDate",
+	{ExifIFD, 0x9010}: "OffsetTime",
+	{ExifIFD, 0x9011}: "OffsetTimeOriginal",
+	{ExifIFD, 0x9012}: "OffsetTimeDigitized",
+	{ExifIFD, 0x9101}: "ComponentsConfiguration",
+	{ExifIFD, 0x9102}: "CompressedBitsPerPixel",
+	{ExifIFD, 0x9201}: "ShutterSpeedValue",
+	{ExifIFD, 0x9202}: "ApertureValue",
+	{ExifIFD, 0x9203}: "BrightnessValue",
+	{ExifIFD, 0x9204}: "ExposureCompensation",
+	{ExifIFD, 0x9205}: "MaxApertureValue",
+	{ExifIFD, 0x9206}: "SubjectDistance",
+	{ExifIFD, 0x9207}: "MeteringMode",
+	{ExifIFD, 0x9208}: "LightSource",
+	{ExifIFD, 0x9209}: "Flash",
+	{ExifIFD, 0x920A}: "FocalLength",
+	{ExifIFD, 0x9214}: "SubjectArea",
+	{ExifIFD, 0x927C}: "MakerNote",
+	{ExifIFD, 0x9286}: "UserComment",
+	{ExifIFD, 0x9290}: "SubSecTime",
+	{ExifIFD, 0x9291}: "SubSecTimeOriginal",
+	{ExifIFD, 0x9292}: "SubSecTimeDigitized",
+	{ExifIFD, 0xA000}: "FlashpixVersion",
+	{ExifIFD, 0xA001}: "ColorSpace",
+	{ExifIFD, 0xA002}: "ExifImageWidth",
+	{ExifIFD, 0xA003}: "ExifImageHeight",
+	{ExifIFD, 0xA004}: "RelatedSoundFile",
+	{ExifIFD, 0xA005}: "InteropOffset",
+	{ExifIFD, 0xA20E}: "FocalPlaneXResolution",
+	{ExifIFD, 0xA20F}: "FocalPlaneYResolution",
+	{ExifIFD, 0xA210}: "FocalPlaneResolutionUnit",
+	{ExifIFD, 0xA215}: "ExposureIndex",
+	{ExifIFD, 0xA217}: "SensingMethod",
+	{ExifIFD, 0xA300}: "FileSource",
+	{ExifIFD, 0xA301}: "SceneType",
+	{ExifIFD, 0xA302}: "CFAPattern",
+	{ExifIFD, 0xA401}: "CustomRendered",
+	{ExifIFD, 0xA402}: "ExposureMode",
+	{ExifIFD, 0xA403}: "WhiteBalance",
+	{ExifIFD, 0xA404}: "DigitalZoomRatio",
+	{ExifIFD, 0xA405}: "FocalLengthIn35mmFormat",
+	{ExifIFD, 0xA406}: "SceneCaptureType",
+	{ExifIFD, 0xA407}: "GainControl",
+	{ExifIFD, 0xA408}: "Contrast",
+	{ExifIFD, 0xA409}: "Saturation",
+	{ExifIFD, 0xA40A}: "Sharpness",
+	{ExifIFD, 0xA40C}: "SubjectDistanceRange",
+	{ExifIFD, 0xA420}: "ImageUniqueID",
+	{ExifIFD, 0xA430}: "OwnerName",
+	{ExifIFD, 0xA431}: "SerialNumber",
+	{ExifIFD, 0xA432}: "LensInfo",
+	{ExifIFD, 0xA433}: "LensMake",
+	{ExifIFD, 0xA434}: "LensModel",
+	{ExifIFD, 0xA435}: "LensSerialNumber",
+	{ExifIFD, 0xA460}: "CompositeImage",
+	{ExifIFD, 0xA500}: "Gamma",
+
+	// GPS IFD.
+	{GPSIFD, 0x0000}: "GPSVersionID",
+	{GPSIFD, 0x0001}: "GPSLatitudeRef",
+	{GPSIFD, 0x0002}: "GPSLatitude",
+	{GPSIFD, 0x0003}: "GPSLongitudeRef",
+	{GPSIFD, 0x0004}: "GPSLongitude",
+	{GPSIFD, 0x0005}: "GPSAltitudeRef",
+	{GPSIFD, 0x0006}: "GPSAltitude",
+	{GPSIFD, 0x0007}: "GPSTimeStamp",
+	{GPSIFD, 0x0008}: "GPSSatellites",
+	{GPSIFD, 0x0009}: "GPSStatus",
+	{GPSIFD, 0x000A}: "GPSMeasureMode",
+	{GPSIFD, 0x000B}: "GPSDOP",
+	{GPSIFD, 0x000C}: "GPSSpeedRef",
+	{GPSIFD, 0x000D}: "GPSSpeed",
+	{GPSIFD, 0x000E}: "GPSTrackRef",
+	{GPSIFD, 0x000F}: "GPSTrack",
+	{GPSIFD, 0x0010}: "GPSImgDirectionRef",
+	{GPSIFD, 0x0011}: "GPSImgDirection",
+	{GPSIFD, 0x0012}: "GPSMapDatum",
+	{GPSIFD, 0x0013}: "GPSDestLatitudeRef",
+	{GPSIFD, 0x0014}: "GPSDestLatitude",
+	{GPSIFD, 0x0015}: "GPSDestLongitudeRef",
+	{GPSIFD, 0x0016}: "GPSDestLongitude",
+	{GPSIFD, 0x0017}: "GPSDestBearingRef",
+	{GPSIFD, 0x0018}: "GPSDestBearing",
+	{GPSIFD, 0x0019}: "GPSDestDistanceRef",
+	{GPSIFD, 0x001A}: "GPSDestDistance",
+	{GPSIFD, 0x001B}: "GPSProcessingMethod",
+	{GPSIFD, 0x001C}: "GPSAreaInformation",
+	{GPSIFD, 0x001D}: "GPSDateStamp",
+	{GPSIFD, 0x001E}: "GPSDifferential",
+	{GPSIFD, 0x001F}: "GPSHPositioningError",
+
+	// Interoperability IFD.
+	{InteropIFD, 0x0001}: "InteropIndex",
+	{InteropIFD, 0x0002}: "InteropVersion",
+}
+
+// tagsByName is the inverse of tagNames.
+var tagsByName = make(map[string]Tag)
+
+func init() {
+	for tag, name := range tagNames {
+		tagsByName[name] = tag
+	}
+}
+
+// LookupTag returns the tag with the given ExifTool name, such as
+// "DateTimeOriginal".
+func LookupTag(name string) (Tag, bool) {
+	tag, ok := tagsByName[name]
+	return tag, ok
+}
+
+// String returns the name of the tag, or a description of it if
+// the tag is unknown.
+func (t Tag) String() string {
+	named := t
+	if named.IFD == IFD1 {
+		named.IFD = IFD0
+	}
+	if name, ok := tagNames[named]; ok {
+		return name
+	}
+	return fmt.Sprintf("%s:0x%.4X", t.IFD, t.ID)
+}
+
+var ifdNames = [...]string{
+	IFD0:       "IFD0",
+	IFD1:       "IFD1",
+	ExifIFD:    "ExifIFD",
+	GPSIFD:     "GPS",
+	InteropIFD: "InteropIFD",
+}
+
+func (i IFD) String() string {
+	if 0 <= i && int(i) < len(ifdNames) {
+		return ifdNames[i]
+	}
+	return fmt.Sprintf("IFD(%d)", int(i))
+}
@@ -0,0 +1,122 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"archive/tar"
+	"bufio"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"time"
+)
+
+// A tar archive is a sequence of files, each a header and the data.
+// Besides the name, the header records the owner, by number and name,
+// and when the file was modified; extended headers can record much
+// more. Rewriting the archive gives each file a plain header with only
+// its name, type, size, and permissions, and scrubs the images. An
+// archive compressed by gzip is compressed again, without the name
+// and time that the gzip header can hold.
+
+var gzipMagic = []byte{0x1F, 0x8B}
+
+// IsTar reports whether the data begins like a tar archive, perhaps
+// compressed by gzip.
+func IsTar(data []byte) bool {
+	if bytes.HasPrefix(data, gzipMagic) {
+		zr, err := gzip.NewReader(bytes.NewReader(data))
+		if err != nil {
+			return false
+		}
+		data = make([]byte, 512)
+		n, _ := io.ReadFull(zr, data)
+		data = data[:n]
+	}
+	return len(data) >= 262 && string(data[257:262]) == "ustar"
+}
+
+// tarTime is the modification time given to every file rewritten.
+var tarTime = time.Unix(0, 0)
+
+// ScrubTar reads a tar archive, perhaps compressed by gzip, from r and
+// writes it to w with plain headers and the images in it scrubbed.
+// Only one image at a time is held in memory.
+func ScrubTar(r io.Reader, w io.Writer) error {
+	return scrubTar(r, w, nil)
+}
+
+func scrubTar(r io.Reader, w io.Writer, removed func(Removal)) error {
+	br := bufio.NewReader(r)
+	magic, _ := br.Peek(len(gzipMagic))
+	if bytes.Equal(magic, gzipMagic) {
+		zr, err := gzip.NewReader(br)
+		if err != nil {
+			return err
+		}
+		zw := gzip.NewWriter(w)
+		if err := rewriteTar(zr, zw, removed); err != nil {
+			return err
+		}
+		return zw.Close()
+	}
+	return rewriteTar(br, w, removed)
+}
+
+// rewriteTar rewrites the uncompressed tar archive read from r.
+// The images changed are reported to removed.
+func rewriteTar(r io.Reader, w io.Writer, removed func(Removal)) error {
+	tr := tar.NewReader(r)
+	tw := tar.NewWriter(w)
+	for {
+		h, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		hdr := &tar.Header{
+			Typeflag: h.Typeflag,
+			Name:     h.Name,
+			Linkname: h.Linkname,
+			Size:     h.Size,
+			Mode:     h.Mode,
+			ModTime:  tarTime,
+			Devmajor: h.Devmajor,
+			Devminor: h.Devminor,
+		}
+		var data io.Reader = tr
+		if h.Typeflag == tar.TypeReg {
+			fr := bufio.NewReader(tr)
+			data = fr
+			magic, _ := fr.Peek(MagicLen)
+			if isImage(magic) {
+				b, err := ioutil.ReadAll(fr)
+				if err != nil {
+					return err
+				}
+				orig := b
+				if b, err = scrubImage(b); err != nil {
+					return fmt.Errorf("%s: %v", h.Name, err)
+				}
+				if !bytes.Equal(b, orig) {
+					report(removed, h.Name, int64(len(orig)), int64(len(b)))
+				}
+				hdr.Size = int64(len(b))
+				data = bytes.NewReader(b)
+			}
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		if _, err := io.Copy(tw, data); err != nil {
+			return err
+		}
+	}
+	return tw.Close()
+}
@@ -0,0 +1,293 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"errors"
+	"io"
+	"io/ioutil"
+)
+
+// A TIFF file has the same structure as EXIF data, but the image data
+// lives in it too, in strips or tiles that a directory locates. A file
+// may hold many images, such as the pages of a scanned document or a
+// fax, each with its own directory in a chain that starts with the
+// first. The metadata is in tags of each directory and in the
+// directories some of them point to. Since everything is located by
+// offsets, scrubbing rewrites the file: the image data is copied
+// unchanged and every directory in the chain is rebuilt without the
+// metadata tags.
+
+// IsTIFF reports whether the data begins with a TIFF header.
+func IsTIFF(data []byte) bool {
+	if len(data) < 4 {
+		return false
+	}
+	switch string(data[:4]) {
+	case "II*\x00", "MM\x00*":
+		return true
+	}
+	return false
+}
+
+// tiffMetadata lists the tags of a TIFF directory that hold metadata.
+var tiffMetadata = map[uint16]bool{
+	0x010D:      true, // DocumentName.
+	0x010E:      true, // ImageDescription.
+	0x010F:      true, // Make.
+	0x0110:      true, // Model.
+	0x011D:      true, // PageName.
+	0x0131:      true, // Software.
+	0x0132:      true, // ModifyDate.
+	0x013B:      true, // Artist.
+	0x013C:      true, // HostComputer.
+	0x0151:      true, // TargetPrinter.
+	0x02BC:      true, // XMP.
+	0x800D:      true, // ImageID, the name of the original image.
+	0x8298:      true, // Copyright.
+	0x83BB:      true, // IPTC.
+	0x8649:      true, // PhotoshopSettings.
+	exifPointer: true,
+	gpsPointer:  true,
+	0x9C9B:      true, // XPTitle.
+	0x9C9C:      true, // XPComment.
+	0x9C9D:      true, // XPAuthor.
+	0x9C9E:      true, // XPKeywords.
+	0x9C9F:      true, // XPSubject.
+	0x0120:      true, // FreeOffsets; the free space is not copied.
+	0x0121:      true, // FreeByteCounts.
+}
+
+// A DNG raw file is a TIFF file whose first directory holds the tag
+// DNGVersion. The raw image is usually in a subsidiary directory, with
+// previews in others, and the first directory holds a thumbnail and
+// the tags that say how to render the raw data. Besides the usual
+// metadata, scrubbing a DNG removes the previews, serial numbers, and
+// private maker data, but keeps the camera make and model, which raw
+// processors use to pick their settings. The thumbnail is kept, since
+// DNG requires it.
+
+const dngVersion = 0xC612
+
+// dngMetadata lists the tags of a DNG directory that hold metadata,
+// beyond those in tiffMetadata.
+var dngMetadata = map[uint16]bool{
+	0xC62F: true, // CameraSerialNumber.
+	0xC634: true, // DNGPrivateData, which holds the maker note.
+	0xC68B: true, // OriginalRawFileName.
+	0xC68C: true, // OriginalRawFileData, the whole original raw file.
+	0xC68D: true, // OriginalRawFileDigest.
+}
+
+// dngKept lists the tags of tiffMetadata that are kept in a DNG.
+var dngKept = map[uint16]bool{
+	0x010F: true, // Make.
+	0x0110: true, // Model.
+}
+
+// newSubfileType is the tag that says what an image is. A value of 1
+// marks a reduced-resolution version of another image.
+const newSubfileType = 0x00FE
+
+// subIFDsPointer is the tag that points to subsidiary directories,
+// which hold such things as reduced-resolution versions of the image.
+const subIFDsPointer = 0x014A
+
+var errTIFFLoop = errors.New("TIFF directories form a loop")
+
+// ScrubTIFF reads a TIFF file, which may be a DNG raw file, from r and
+// writes it to w without its metadata. The image data is copied byte
+// for byte. Unlike Scrub, it holds the whole file in memory.
+func ScrubTIFF(r io.Reader, w io.Writer) error {
+	return scrubTIFF(r, w, nil)
+}
+
+func scrubTIFF(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	t, err := parseTIFF(data)
+	if err != nil {
+		return err
+	}
+	b := &tiffBuilder{order: t.order}
+	b.data = append(b.data, data[:4]...)
+	b.data = b.uint32(b.data, 0)
+	c := &tiffCopier{t: t, b: b, seen: make(map[uint32]bool), removed: removed}
+	if d0, err := t.ifd(t.first()); err == nil && d0.has(dngVersion) {
+		c.dng = true
+	}
+	link := 4 // Where to store the offset of the next directory written.
+	for off := t.first(); off != 0; {
+		d, err := c.ifd(off)
+		if err != nil {
+			return err
+		}
+		pos, err := c.copy(d)
+		if err != nil {
+			return err
+		}
+		b.order.PutUint32(b.data[link:], pos)
+		link = int(pos) + 2 + 12*len(d.entries)
+		off = d.next
+	}
+	_, err = w.Write(b.data)
+	return err
+}
+
+// has reports whether the directory holds the tag.
+func (d *ifd) has(tag uint16) bool {
+	for _, e := range d.entries {
+		if e.tag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// A tiffCopier copies directories and the image data they locate from
+// one TIFF file to another.
+type tiffCopier struct {
+	t       *tiff
+	b       *tiffBuilder
+	seen    map[uint32]bool
+	dng     bool // The file is a DNG raw file.
+	removed func(Removal)
+}
+
+// metadata reports whether the tag holds metadata.
+func (c *tiffCopier) metadata(tag uint16) bool {
+	if c.dng && (dngMetadata[tag] || dngKept[tag]) {
+		return dngMetadata[tag]
+	}
+	return tiffMetadata[tag]
+}
+
+// preview reports whether the directory, in a DNG, holds a preview.
+func (c *tiffCopier) preview(d *ifd) bool {
+	if !c.dng {
+		return false
+	}
+	for _, e := range d.entries {
+		if e.tag == newSubfileType {
+			v := c.t.uints(e)
+			return len(v) == 1 && v[0] == 1
+		}
+	}
+	return false
+}
+
+// imageSize returns the length of the directory and of the image data
+// it locates.
+func (c *tiffCopier) imageSize(d *ifd) int64 {
+	n := d.end() - int64(d.off)
+	for _, p := range dataPointers {
+		_, lengths := c.t.dataBlocks(d, p)
+		for _, l := range lengths {
+			n += int64(l)
+		}
+	}
+	return n
+}
+
+// ifd parses the directory at off, which must not have been seen before.
+func (c *tiffCopier) ifd(off uint32) (*ifd, error) {
+	if c.seen[off] {
+		return nil, errTIFFLoop
+	}
+	c.seen[off] = true
+	return c.t.ifd(off)
+}
+
+// copy writes the directory, without its metadata, after the image
+// data and subsidiary directories it locates, and returns its offset.
+// The directory is left with no successor, and d is updated to hold
+// only the entries written.
+func (c *tiffCopier) copy(d *ifd) (uint32, error) {
+	var entries []entry
+	for _, e := range d.entries {
+		if c.metadata(e.tag) {
+			n := int64(12)
+			if len(e.value) > 4 {
+				n += int64(len(e.value))
+			}
+			report(c.removed, Tag{IFD0, e.tag}.String()+" tag", n, 0)
+			continue
+		}
+		if e.value == nil {
+			continue
+		}
+		entries = append(entries, e)
+	}
+	for _, p := range dataPointers {
+		offsets, lengths := c.t.dataBlocks(d, p)
+		if offsets == nil {
+			continue
+		}
+		if len(offsets) != len(lengths) {
+			return 0, errTIFF
+		}
+		moved := make([]uint32, len(offsets))
+		for i := range offsets {
+			start, end := int64(offsets[i]), int64(offsets[i])+int64(lengths[i])
+			if end > int64(len(c.t.data)) {
+				return 0, errTIFF
+			}
+			moved[i] = uint32(len(c.b.data))
+			c.b.data = append(c.b.data, c.t.data[start:end]...)
+			if len(c.b.data)%2 == 1 {
+				c.b.data = append(c.b.data, 0)
+			}
+		}
+		c.set(entries, p.offsets, moved)
+	}
+	for _, e := range entries {
+		if e.tag != subIFDsPointer {
+			continue
+		}
+		var moved []uint32
+		for _, off := range c.t.uints(e) {
+			sub, err := c.ifd(off)
+			if err != nil {
+				return 0, err
+			}
+			if c.preview(sub) {
+				report(c.removed, "preview image", c.imageSize(sub), 0)
+				continue
+			}
+			pos, err := c.copy(sub)
+			if err != nil {
+				return 0, err
+			}
+			moved = append(moved, pos)
+		}
+		c.set(entries, subIFDsPointer, moved)
+	}
+	// Drop the pointer to subsidiary directories if none are left.
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.tag != subIFDsPointer || e.count > 0 {
+			kept = append(kept, e)
+		}
+	}
+	entries = kept
+	d.entries = entries
+	return c.b.ifd(entries), nil
+}
+
+// set sets the value of the entry with the tag to the offsets, as LONGs.
+func (c *tiffCopier) set(entries []entry, tag uint16, offsets []uint32) {
+	for i, e := range entries {
+		if e.tag != tag {
+			continue
+		}
+		var v []byte
+		for _, off := range offsets {
+			v = c.b.uint32(v, off)
+		}
+		entries[i] = entry{tag: tag, typ: 4, count: uint32(len(offsets)), value: v}
+	}
+}
@@ -0,0 +1,80 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import "bytes"
+
+// Data after the EOI marker is ignored by decoders, which makes it a
+// place to keep other things: the secondary images of a multi-picture
+// file, phone makers' private trailers, or whole archives.
+
+// trailerSample is how many bytes are kept of each end of the trailer.
+const trailerSample = 16
+
+// A trailer holds the first and last few bytes of the data after EOI,
+// enough to identify what it is. The tail is updated as the data is
+// written to it.
+type trailer struct {
+	head, tail []byte
+}
+
+func (t *trailer) Write(p []byte) (int, error) {
+	if len(p) >= trailerSample {
+		t.tail = append(t.tail[:0], p[len(p)-trailerSample:]...)
+		return len(p), nil
+	}
+	t.tail = append(t.tail, p...)
+	if n := len(t.tail); n > trailerSample {
+		t.tail = append(t.tail[:0], t.tail[n-trailerSample:]...)
+	}
+	return len(p), nil
+}
+
+// trailerKinds identifies trailers by the signatures at their start.
+var trailerKinds = []struct {
+	magic string
+	kind  string
+}{
+	{"\xFF\xD8\xFF", "JPEG image"},
+	{"PK\x03\x04", "ZIP archive"},
+	{"Rar!", "RAR archive"},
+	{"7z\xBC\xAF\x27\x1C", "7z archive"},
+	{"%PDF", "PDF document"},
+	{"\x89PNG", "PNG image"},
+}
+
+// Trailer describes the data following the EOI marker, once the Scanner
+// has read it: "JPEG image", for instance, for the secondary images of a
+// multi-picture file, or "Samsung trailer". It returns the empty string
+// if there is no such data and "unknown data" if it is unrecognized.
+func (s *Scanner) Trailer() string {
+	head, tail := s.trail.head, s.trail.tail
+	if len(tail) == 0 {
+		return ""
+	}
+	for _, k := range trailerKinds {
+		if bytes.HasPrefix(head, []byte(k.magic)) {
+			return k.kind
+		}
+	}
+	switch {
+	case len(head) >= 8 && string(head[4:8]) == "ftyp":
+		return "MP4 video"
+	case bytes.HasSuffix(tail, []byte("SEFT")):
+		return "Samsung trailer"
+	case allZero(head) && allZero(tail):
+		return "zero padding"
+	}
+	return "unknown data"
+}
+
+func allZero(b []byte) bool {
+	for _, c := range b {
+		if c != 0 {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,129 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// WAV and AIFF files are built like WebP: a header giving the size of
+// the rest, then chunks, each a type, a size, and the data, padded to
+// an even length. WAV is little-endian RIFF, AIFF big-endian IFF.
+// In WAV, metadata is held in LIST chunks of type INFO, bext chunks
+// (Broadcast Wave, naming the originator and often the place), iXML
+// chunks from field recorders, and ID3 and XMP chunks. In AIFF it is
+// held in the name, author, annotation, copyright, comment, and ID3
+// chunks.
+
+// IsWAV reports whether the data begins with a WAV header.
+func IsWAV(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WAVE"
+}
+
+// IsAIFF reports whether the data begins with an AIFF or AIFF-C header.
+func IsAIFF(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "FORM" &&
+		(string(data[8:12]) == "AIFF" || string(data[8:12]) == "AIFC")
+}
+
+// wavMetadata lists the WAV chunks that hold metadata.
+var wavMetadata = map[string]bool{
+	"bext": true,
+	"iXML": true,
+	"id3 ": true,
+	"ID3 ": true,
+	"_PMX": true, // XMP.
+}
+
+// aiffMetadata lists the AIFF chunks that hold metadata.
+var aiffMetadata = map[string]bool{
+	"NAME": true,
+	"AUTH": true,
+	"ANNO": true,
+	"(c) ": true,
+	"COMT": true,
+	"ID3 ": true,
+}
+
+// ScrubWAV reads a WAV file from r and writes it to w without its
+// metadata chunks. It holds the whole file in memory, since the size
+// of the result must be written first.
+func ScrubWAV(r io.Reader, w io.Writer) error {
+	return scrubWAV(r, w, nil)
+}
+
+func scrubWAV(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !IsWAV(data) {
+		return fmt.Errorf("not a WAV file")
+	}
+	return scrubChunks(w, data, binary.LittleEndian, removed, func(typ string, body []byte) bool {
+		return wavMetadata[typ] || typ == "LIST" && bytes.HasPrefix(body, []byte("INFO"))
+	})
+}
+
+// ScrubAIFF reads an AIFF or AIFF-C file from r and writes it to w
+// without its metadata chunks. It holds the whole file in memory,
+// since the size of the result must be written first.
+func ScrubAIFF(r io.Reader, w io.Writer) error {
+	return scrubAIFF(r, w, nil)
+}
+
+func scrubAIFF(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !IsAIFF(data) {
+		return fmt.Errorf("not an AIFF file")
+	}
+	return scrubChunks(w, data, binary.BigEndian, removed, func(typ string, body []byte) bool {
+		return aiffMetadata[typ]
+	})
+}
+
+// scrubChunks writes to w the RIFF or IFF file in data, with sizes in
+// the given byte order, without the chunks for which drop is true.
+func scrubChunks(w io.Writer, data []byte, order binary.ByteOrder, removed func(Removal), drop func(typ string, body []byte) bool) error {
+	size := int64(order.Uint32(data[4:])) + 8
+	if size > int64(len(data)) {
+		return io.ErrUnexpectedEOF
+	}
+	var out bytes.Buffer
+	out.Write(data[:12])
+	for p := int64(12); p < size; {
+		if p+8 > size {
+			return io.ErrUnexpectedEOF
+		}
+		typ := string(data[p : p+4])
+		n := int64(order.Uint32(data[p+4:]))
+		end := p + 8 + n + n&1
+		if end > size {
+			// A missing pad byte after the last chunk is common.
+			if p+8+n != size {
+				return io.ErrUnexpectedEOF
+			}
+			end = size
+		}
+		if drop(typ, data[p+8:p+8+n]) {
+			report(removed, strings.TrimSpace(typ)+" chunk", end-p, 0)
+		} else {
+			out.Write(data[p:end])
+		}
+		p = end
+	}
+	b := out.Bytes()
+	order.PutUint32(b[4:], uint32(len(b)-8))
+	_, err := w.Write(b)
+	return err
+}
@@ -0,0 +1,88 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"encoding/binary"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"strings"
+)
+
+// A WebP file is a RIFF container: a header giving the size of the
+// rest, then chunks, each a type, a little-endian size, and the data,
+// padded to an even length. Metadata is held in EXIF and XMP chunks,
+// whose presence is also flagged in the VP8X chunk, if there is one.
+
+// IsWebP reports whether the data begins with a WebP header.
+func IsWebP(data []byte) bool {
+	return len(data) >= 12 && string(data[:4]) == "RIFF" && string(data[8:12]) == "WEBP"
+}
+
+// webpMetadata lists the chunks that hold metadata.
+var webpMetadata = map[string]bool{
+	"EXIF": true,
+	"XMP ": true,
+}
+
+// The flags in the VP8X chunk that announce metadata chunks.
+const (
+	vp8xEXIF = 0x08
+	vp8xXMP  = 0x04
+)
+
+// ScrubWebP reads a WebP file from r and writes it to w without its
+// EXIF and XMP chunks. It holds the whole file in memory, since the
+// size of the result must be written first.
+func ScrubWebP(r io.Reader, w io.Writer) error {
+	return scrubWebP(r, w, nil)
+}
+
+func scrubWebP(r io.Reader, w io.Writer, removed func(Removal)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	if !IsWebP(data) {
+		return fmt.Errorf("not a WebP file")
+	}
+	size := int64(binary.LittleEndian.Uint32(data[4:])) + 8
+	if size > int64(len(data)) {
+		return io.ErrUnexpectedEOF
+	}
+	var out bytes.Buffer
+	out.Write(data[:12])
+	for p := int64(12); p < size; {
+		if p+8 > size {
+			return io.ErrUnexpectedEOF
+		}
+		typ := string(data[p : p+4])
+		n := int64(binary.LittleEndian.Uint32(data[p+4:]))
+		end := p + 8 + n + n&1
+		if end > size {
+			// A missing pad byte after the last chunk is common.
+			if p+8+n != size {
+				return io.ErrUnexpectedEOF
+			}
+			end = size
+		}
+		if webpMetadata[typ] {
+			report(removed, strings.TrimSpace(typ)+" chunk", end-p, 0)
+		} else {
+			chunk := out.Len()
+			out.Write(data[p:end])
+			if typ == "VP8X" && n > 0 {
+				out.Bytes()[chunk+8] &^= vp8xEXIF | vp8xXMP
+			}
+		}
+		p = end
+	}
+	b := out.Bytes()
+	binary.LittleEndian.PutUint32(b[4:], uint32(len(b)-8))
+	_, err = w.Write(b)
+	return err
+}
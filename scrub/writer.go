@@ -0,0 +1,47 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import "io"
+
+// writer is the WriteCloser returned by NewWriter. The scrubbing
+// runs in a goroutine reading from the other end of a pipe.
+type writer struct {
+	pw   *io.PipeWriter
+	done chan error
+	err  error
+}
+
+// NewWriter returns a WriteCloser that scrubs the JPEG stream written
+// to it and writes the result to w. The caller must call Close to
+// complete the output; Close reports whether the stream was well formed.
+// Closing the returned writer does not close w.
+func NewWriter(w io.Writer) io.WriteCloser {
+	pr, pw := io.Pipe()
+	wr := &writer{
+		pw:   pw,
+		done: make(chan error, 1),
+	}
+	go func() {
+		err := Scrub(pr, w)
+		// If scrubbing stopped early, make further writes fail.
+		pr.CloseWithError(err)
+		wr.done <- err
+	}()
+	return wr
+}
+
+func (w *writer) Write(p []byte) (int, error) {
+	return w.pw.Write(p)
+}
+
+func (w *writer) Close() error {
+	if w.done != nil {
+		w.pw.Close()
+		w.err = <-w.done
+		w.done = nil
+	}
+	return w.err
+}
@@ -0,0 +1,216 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"bytes"
+	"errors"
+	"strings"
+)
+
+// XML documents such as SVG images are scrubbed by scanning the text
+// rather than decoding and encoding it, so everything that is kept,
+// including the layout of the text, is unchanged.
+
+var errXML = errors.New("malformed XML")
+
+// filterXML returns the XML text in p without its comments, the
+// elements for which dropElement returns true, and the attributes for
+// which dropAttr returns true. DropElement is given the name of the
+// enclosing element, and the name and start tag of the element. What
+// is dropped is reported to removed.
+func filterXML(p []byte, dropElement func(parent, name string, tag []byte) bool, dropAttr func(name string) bool, removed func(Removal)) ([]byte, error) {
+	var out []byte
+	var stack []string // Names of the open elements.
+	skip := 0          // Depth within an element being dropped.
+	size := len(p)
+	var dropped string // The name of the element being dropped.
+	var start int      // Where it starts.
+	for len(p) > 0 {
+		i := bytes.IndexByte(p, '<')
+		if i < 0 {
+			i = len(p)
+		}
+		if skip == 0 {
+			out = append(out, p[:i]...)
+		}
+		p = p[i:]
+		if len(p) == 0 {
+			break
+		}
+		var end string
+		switch {
+		case bytes.HasPrefix(p, []byte("<!--")):
+			i := bytes.Index(p, []byte("-->"))
+			if i < 0 {
+				return nil, errXML
+			}
+			p = p[i+3:]
+			if skip == 0 {
+				out = trimLine(out)
+				report(removed, "comment", int64(i+3), 0)
+			}
+			continue
+		case bytes.HasPrefix(p, []byte("<![CDATA[")):
+			end = "]]>"
+		case bytes.HasPrefix(p, []byte("<?")):
+			end = "?>"
+		case bytes.HasPrefix(p, []byte("<!")):
+			// A document type declaration, which may hold
+			// declarations of its own in brackets.
+			end = ">"
+			if j, k := bytes.IndexByte(p, '['), bytes.IndexByte(p, '>'); j >= 0 && j < k {
+				end = "]>"
+			}
+		case bytes.HasPrefix(p, []byte("</")):
+			i := bytes.IndexByte(p, '>')
+			if i < 0 {
+				return nil, errXML
+			}
+			if skip == 0 {
+				out = append(out, p[:i+1]...)
+				if len(stack) > 0 {
+					stack = stack[:len(stack)-1]
+				}
+			} else {
+				skip--
+			}
+			p = p[i+1:]
+			if dropped != "" && skip == 0 {
+				report(removed, dropped+" element", int64(size-len(p)-start), 0)
+				dropped = ""
+			}
+			continue
+		default:
+			var attrs []Removal
+			name, tag, n, empty, err := xmlTag(p, dropAttr, func(r Removal) {
+				attrs = append(attrs, r)
+			})
+			if err != nil {
+				return nil, err
+			}
+			p = p[n:]
+			parent := ""
+			if len(stack) > 0 {
+				parent = stack[len(stack)-1]
+			}
+			switch {
+			case skip > 0:
+				if !empty {
+					skip++
+				}
+			case dropElement(parent, name, tag):
+				out = trimLine(out)
+				if empty {
+					report(removed, name+" element", int64(n), 0)
+				} else {
+					skip = 1
+					dropped, start = name, size-len(p)-n
+				}
+			default:
+				out = append(out, tag...)
+				if !empty {
+					stack = append(stack, name)
+				}
+				for _, r := range attrs {
+					report(removed, r.What, r.Length, r.Written)
+				}
+			}
+			continue
+		}
+		i = bytes.Index(p, []byte(end))
+		if i < 0 {
+			return nil, errXML
+		}
+		if skip == 0 {
+			out = append(out, p[:i+len(end)]...)
+		}
+		p = p[i+len(end):]
+	}
+	if skip > 0 {
+		return nil, errXML
+	}
+	return out, nil
+}
+
+// xmlTag scans the start tag at the beginning of p. It returns the
+// element's name, the tag without the attributes for which dropAttr
+// returns true, the length of the tag in p, and whether the tag ends
+// its element. The attributes dropped are reported to removed.
+func xmlTag(p []byte, dropAttr func(name string) bool, removed func(Removal)) (name string, tag []byte, n int, empty bool, err error) {
+	i := 1
+	for i < len(p) && !xmlSpace(p[i]) && p[i] != '>' && p[i] != '/' {
+		i++
+	}
+	name = string(p[1:i])
+	tag = append(tag, p[:i]...)
+	for {
+		start := i
+		for i < len(p) && xmlSpace(p[i]) {
+			i++
+		}
+		if i >= len(p) {
+			return "", nil, 0, false, errXML
+		}
+		switch {
+		case p[i] == '>':
+			return name, append(tag, p[start:i+1]...), i + 1, false, nil
+		case bytes.HasPrefix(p[i:], []byte("/>")):
+			return name, append(tag, p[start:i+2]...), i + 2, true, nil
+		}
+		// An attribute: name = "value".
+		a := i
+		for i < len(p) && !xmlSpace(p[i]) && p[i] != '=' && p[i] != '>' {
+			i++
+		}
+		attr := string(p[a:i])
+		for i < len(p) && xmlSpace(p[i]) {
+			i++
+		}
+		if i >= len(p) || p[i] != '=' {
+			return "", nil, 0, false, errXML
+		}
+		i++
+		for i < len(p) && xmlSpace(p[i]) {
+			i++
+		}
+		if i >= len(p) || p[i] != '"' && p[i] != '\'' {
+			return "", nil, 0, false, errXML
+		}
+		j := bytes.IndexByte(p[i+1:], p[i])
+		if j < 0 {
+			return "", nil, 0, false, errXML
+		}
+		i += j + 2
+		if dropAttr(attr) {
+			report(removed, attr+" attribute", int64(i-start), 0)
+		} else {
+			tag = append(tag, p[start:i]...)
+		}
+	}
+}
+
+// xmlSpace reports whether c is XML white space.
+func xmlSpace(c byte) bool {
+	return c == ' ' || c == '\t' || c == '\r' || c == '\n'
+}
+
+// xmlPrefix returns the namespace prefix and local part of the name.
+func xmlPrefix(name string) (prefix, local string) {
+	if i := strings.IndexByte(name, ':'); i >= 0 {
+		return name[:i], name[i+1:]
+	}
+	return "", name
+}
+
+// trimLine returns out without its last line, if that holds only
+// white space, so that dropping what followed leaves no blank line.
+func trimLine(out []byte) []byte {
+	i := bytes.LastIndexByte(out, '\n')
+	if i < 0 || len(bytes.TrimLeft(out[i:], " \t\r\n")) > 0 {
+		return out
+	}
+	return out[:i]
+}
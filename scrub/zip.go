@@ -0,0 +1,164 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package scrub
+
+import (
+	"archive/zip"
+	"bytes"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"io/ioutil"
+)
+
+// Documents such as Office files and EPUB books are ZIP archives, as
+// are the exports of photo libraries. Besides the metadata in the files
+// themselves, the archive records when each file in it was modified,
+// and may hold comments and extra fields naming the owner. Rewriting
+// the archive drops them, keeping the order of the files and how each
+// is compressed, since some formats depend on both.
+
+// zipDate is the modification date, in MS-DOS form, given to every
+// file rewritten: January 1, 1980, the earliest a ZIP archive can
+// record. It is set directly, rather than through the Modified field
+// of zip.FileHeader, which would add an extra field.
+const zipDate = 1<<5 | 1
+
+// zipName returns the name of the first file in the ZIP archive that
+// data begins with, or "" if it does not begin with one.
+func zipName(data []byte) string {
+	if len(data) < 30 || string(data[:4]) != "PK\x03\x04" {
+		return ""
+	}
+	n := 30 + (int(data[26]) | int(data[27])<<8)
+	if n > len(data) {
+		return ""
+	}
+	return string(data[30:n])
+}
+
+// IsZIP reports whether the data begins like a ZIP archive.
+func IsZIP(data []byte) bool {
+	return zipName(data) != ""
+}
+
+// ScrubZIP reads a ZIP archive from r and writes it to w with the
+// images in it scrubbed. It holds the whole file in memory.
+func ScrubZIP(r io.Reader, w io.Writer) error {
+	return scrubZIP(r, w, nil)
+}
+
+func scrubZIP(r io.Reader, w io.Writer, removed func(Removal)) error {
+	return rewriteZip(r, w, removed, func(name string, data []byte) ([]byte, error) {
+		return scrubImage(data)
+	})
+}
+
+// rewriteZip reads a ZIP archive from r and writes it to w, passing
+// each file's name and contents through fix, which returns the new
+// contents, or nil to drop the file. The files changed are reported to
+// removed. The archive is held in memory.
+func rewriteZip(r io.Reader, w io.Writer, removed func(Removal), fix func(name string, data []byte) ([]byte, error)) error {
+	data, err := ioutil.ReadAll(r)
+	if err != nil {
+		return err
+	}
+	zr, err := zip.NewReader(bytes.NewReader(data), int64(len(data)))
+	if err != nil {
+		return err
+	}
+	zw := zip.NewWriter(w)
+	for _, f := range zr.File {
+		if f.Mode().IsDir() {
+			if _, err := zw.CreateHeader(&zip.FileHeader{Name: f.Name, ModifiedDate: zipDate}); err != nil {
+				return err
+			}
+			continue
+		}
+		rc, err := f.Open()
+		if err != nil {
+			return err
+		}
+		b, err := ioutil.ReadAll(rc)
+		rc.Close()
+		if err != nil {
+			return err
+		}
+		orig := b
+		if b, err = fix(f.Name, b); err != nil {
+			return fmt.Errorf("%s: %v", f.Name, err)
+		}
+		if b == nil || !bytes.Equal(b, orig) {
+			report(removed, f.Name, int64(len(orig)), int64(len(b)))
+		}
+		if b == nil {
+			continue
+		}
+		hdr := &zip.FileHeader{
+			Name:         f.Name,
+			Method:       f.Method,
+			ModifiedDate: zipDate,
+		}
+		var fw io.Writer
+		if f.Method == zip.Store {
+			// Write stored files without a data descriptor,
+			// as EPUB requires of the first.
+			hdr.CRC32 = crc32.ChecksumIEEE(b)
+			hdr.CompressedSize64 = uint64(len(b))
+			hdr.UncompressedSize64 = uint64(len(b))
+			fw, err = zw.CreateRaw(hdr)
+		} else {
+			fw, err = zw.CreateHeader(hdr)
+		}
+		if err != nil {
+			return err
+		}
+		if _, err := fw.Write(b); err != nil {
+			return err
+		}
+	}
+	return zw.Close()
+}
+
+// imageScrubbers lists the functions that scrub the images that may be
+// found inside documents, with the functions that recognize them.
+var imageScrubbers = []struct {
+	is    func([]byte) bool
+	scrub func(io.Reader, io.Writer) error
+}{
+	{IsJPEG, Scrub},
+	{IsPNG, ScrubPNG},
+	{IsGIF, ScrubGIF},
+	{IsTIFF, ScrubTIFF},
+	{IsWebP, ScrubWebP},
+	{IsHEIF, ScrubHEIF},
+	{IsJXL, ScrubJXL},
+}
+
+// isImage reports whether the data begins like an image that
+// scrubImage can scrub.
+func isImage(data []byte) bool {
+	for _, s := range imageScrubbers {
+		if s.is(data) {
+			return true
+		}
+	}
+	return false
+}
+
+// scrubImage returns the contents of a file held in a document,
+// scrubbed if the file is an image, otherwise unchanged.
+func scrubImage(data []byte) ([]byte, error) {
+	for _, s := range imageScrubbers {
+		if s.is(data) {
+			var out bytes.Buffer
+			if err := s.scrub(bytes.NewReader(data), &out); err != nil {
+				return nil, err
+			}
+			return out.Bytes(), nil
+		}
+	}
+	return data, nil
+}
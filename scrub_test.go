@@ -0,0 +1,223 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// A file's status is the number of bytes removed and whether it was
+// changed. A file can be changed with nothing removed, when metadata
+// is edited or overwritten in place, and that must not be reported as
+// clean.
+
+func TestReportStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		seg     scrub.Segment
+		removed int64
+		changed bool
+	}{
+		{"kept", scrub.Segment{Length: 10, Written: 10}, 0, false},
+		{"removed", scrub.Segment{Length: 10}, 10, true},
+		{"shortened", scrub.Segment{Length: 10, Written: 6, Edited: true}, 4, true},
+		{"edited same length", scrub.Segment{Length: 10, Written: 10, Edited: true}, 0, true},
+		{"inserted before", scrub.Segment{Length: 10, Written: 10, Inserted: 1}, 0, true},
+		{"scan data kept", scrub.Segment{Length: 10, Data: 100, Written: 110}, 0, false},
+		{"trailer removed", scrub.Segment{Length: 2, Data: 100, Written: 2}, 100, true},
+		{"trailer zeroed", scrub.Segment{Length: 2, Data: 100, Written: 102, Edited: true}, 0, true},
+	}
+	for _, test := range tests {
+		removed, changed := report(test.seg)
+		if removed != test.removed || changed != test.changed {
+			t.Errorf("%s: got %d, %t; want %d, %t", test.name, removed, changed, test.removed, test.changed)
+		}
+	}
+}
+
+// fakeFormat returns a format whose scrubber passes the file through f.
+func fakeFormat(f func(data []byte) []byte) *scrub.Format {
+	return &scrub.Format{
+		Name:  "fake",
+		Match: func([]byte) bool { return false },
+		Scrub: func(r io.Reader, w io.Writer) error {
+			data, err := ioutil.ReadAll(r)
+			if err != nil {
+				return err
+			}
+			_, err = w.Write(f(data))
+			return err
+		},
+	}
+}
+
+func TestScrubOtherStatus(t *testing.T) {
+	tests := []struct {
+		name    string
+		scrub   func(data []byte) []byte
+		removed int64
+		changed bool
+	}{
+		{"unchanged", func(b []byte) []byte { return b }, 0, false},
+		{"edited same length", bytes.ToUpper, 0, true},
+		{"shortened", func(b []byte) []byte { return b[:4] }, 6, true},
+	}
+	for _, test := range tests {
+		removed, changed, err := scrubOther(fakeFormat(test.scrub), ioutil.Discard, strings.NewReader("metadata.."), nil)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if removed != test.removed || changed != test.changed {
+			t.Errorf("%s: got %d, %t; want %d, %t", test.name, removed, changed, test.removed, test.changed)
+		}
+	}
+	// What follows the data the scrubber reads is removed too.
+	head := &scrub.Format{
+		Name:  "head",
+		Match: func([]byte) bool { return false },
+		Scrub: func(r io.Reader, w io.Writer) error {
+			_, err := io.CopyN(w, r, 4)
+			return err
+		},
+	}
+	removed, changed, err := scrubOther(head, ioutil.Discard, strings.NewReader("datatrailer"), nil)
+	if err != nil || removed != 7 || !changed {
+		t.Errorf("trailer: got %d, %t, %v; want 7, true", removed, changed, err)
+	}
+}
+
+// jpegFile returns a small JPEG stream holding the segments between
+// SOI and the image.
+func jpegFile(segments ...string) string {
+	return "\xFF\xD8" + strings.Join(segments, "") +
+		segment(scrub.SOF, "\x08\x00\x01\x00\x01\x01\x01\x11\x00") +
+		segment(scrub.SOS, "\x01\x01\x00\x00\x3F\x00") + "data\xFF\xD9"
+}
+
+// segment returns a JPEG segment with the marker and payload.
+func segment(marker byte, payload string) string {
+	n := len(payload) + 2
+	return string([]byte{0xFF, marker, byte(n >> 8), byte(n)}) + payload
+}
+
+// cleanString runs clean on a file holding in.
+func cleanString(t *testing.T, in string) (string, int64, bool, error) {
+	name := filepath.Join(t.TempDir(), "in.jpg")
+	if err := ioutil.WriteFile(name, []byte(in), 0644); err != nil {
+		t.Fatal(err)
+	}
+	f, err := os.Open(name)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	var out bytes.Buffer
+	removed, changed, err := clean(&out, f, nil, nil)
+	return out.String(), removed, changed, err
+}
+
+func TestCleanStatus(t *testing.T) {
+	defer func(z bool) { *zero = z }(*zero)
+	comment := segment(scrub.COM, "a comment")
+	tests := []struct {
+		name    string
+		in      string
+		zero    bool
+		removed int64
+		changed bool
+	}{
+		{"clean", jpegFile(), false, 0, false},
+		{"comment", jpegFile(comment), false, int64(len(comment)), true},
+		{"comment zeroed", jpegFile(comment), true, 0, true},
+		{"clean zeroed", jpegFile(), true, 0, false},
+	}
+	for _, test := range tests {
+		*zero = test.zero
+		out, removed, changed, err := cleanString(t, test.in)
+		if err != nil {
+			t.Errorf("%s: %v", test.name, err)
+			continue
+		}
+		if removed != test.removed || changed != test.changed {
+			t.Errorf("%s: got %d, %t; want %d, %t", test.name, removed, changed, test.removed, test.changed)
+		}
+		if test.zero && len(out) != len(test.in) {
+			t.Errorf("%s: -zero changed the length from %d to %d", test.name, len(test.in), len(out))
+		}
+	}
+}
+
+// box returns an MP4 box holding the contents.
+func box(typ string, contents ...string) string {
+	body := strings.Join(contents, "")
+	n := len(body) + 8
+	return string([]byte{byte(n >> 24), byte(n >> 16), byte(n >> 8), byte(n)}) + typ + body
+}
+
+func TestCleanMotion(t *testing.T) {
+	defer func(m string) { *motionFlag = m }(*motionFlag)
+	video := box("ftyp", "isom\x00\x00\x00\x00isom") + box("moov", box("mvhd", "\x00\x00\x00\x00"), box("udta", "secret")) + box("mdat", "frames")
+	scrubbed := box("ftyp", "isom\x00\x00\x00\x00isom") + box("moov", box("mvhd", "\x00\x00\x00\x00")) + box("mdat", "frames")
+	xmp := segment(app1, string(scrub.MotionPhotoXMP(len(video))))
+	in := jpegFile(xmp) + video
+	tests := []struct {
+		motion  string
+		out     string
+		removed int
+	}{
+		{"strip", jpegFile(), len(xmp) + len(video)},
+		{"scrub", jpegFile(segment(app1, string(scrub.MotionPhotoXMP(len(scrubbed))))) + scrubbed, len(xmp) + len(video) - len(scrubbed)},
+	}
+	for _, test := range tests {
+		*motionFlag = test.motion
+		out, removed, changed, err := cleanString(t, in)
+		if err != nil {
+			t.Errorf("%s: %v", test.motion, err)
+			continue
+		}
+		if out != test.out {
+			t.Errorf("%s: got %q; want %q", test.motion, out, test.out)
+		}
+		if removed != int64(test.removed) || !changed {
+			t.Errorf("%s: got %d, %t; want %d, true", test.motion, removed, changed, test.removed)
+		}
+	}
+}
+
+func TestCleanVerify(t *testing.T) {
+	defer func(v, a, f bool) { *verify, *addEOI, *force = v, a, f }(*verify, *addEOI, *force)
+	*verify = true
+	truncated := strings.TrimSuffix(jpegFile(), "\xFF\xD9")
+	tests := []struct {
+		name   string
+		in     string
+		addEOI bool
+		force  bool
+		err    string
+	}{
+		{"clean", jpegFile(), false, false, ""},
+		{"comment", jpegFile(segment(scrub.COM, "a comment")), false, false, ""},
+		{"no EOI", truncated, false, false, ""},
+		{"EOI added", truncated, true, false, ""},
+		// The scrubber recovers, but the digest of the input fails.
+		{"forced", jpegFile("\xFF\xE1\x00\x01"), false, true, "cannot verify"},
+	}
+	for _, test := range tests {
+		*addEOI, *force = test.addEOI, test.force
+		_, _, _, err := cleanString(t, test.in)
+		if err == nil && test.err != "" || err != nil && !strings.HasPrefix(err.Error(), test.err+":") {
+			t.Errorf("%s: got error %v; want %q", test.name, err, test.err)
+		}
+	}
+}
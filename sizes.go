@@ -0,0 +1,96 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"fmt"
+	"io"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// sizeKinds lists the kinds of data reported by -sizes, in order.
+var sizeKinds = []string{
+	"EXIF",
+	"EXIF thumbnail",
+	"JFIF",
+	"JFIF thumbnail",
+	"XMP",
+	"ICC profile",
+	"IPTC",
+	"Adobe",
+	"MPF index",
+	"comments",
+	"other metadata",
+	"trailing data",
+	"image",
+}
+
+// sizes prints to w how many bytes of the JPEG stream read from r
+// each kind of data occupies.
+func sizes(w io.Writer, r io.Reader) error {
+	size := make(map[string]int64)
+	var total int64
+	s := newScanner(r, nil)
+	for s.Scan() {
+		seg := s.Segment()
+		total += int64(seg.Length) + seg.Data
+		p := seg.Payload
+		n := int64(seg.Length)
+		switch m := seg.Marker; {
+		case scrub.KeepImage(m, p):
+			size["image"] += n
+			if m == scrub.EOI {
+				size["trailing data"] += seg.Data
+			} else {
+				size["image"] += seg.Data
+			}
+		case m == app1 && scrub.IsEXIF(p):
+			thumb := thumbnailSize(p)
+			size["EXIF"] += n - thumb
+			size["EXIF thumbnail"] += thumb
+		case m == app0 && scrub.IsJFIF(p):
+			size["JFIF"] += 4 + 14
+			size["JFIF thumbnail"] += n - (4 + 14)
+		case m == app1 && (scrub.IsXMP(p) || scrub.IsExtendedXMP(p)):
+			size["XMP"] += n
+		case m == app2 && scrub.IsICC(p):
+			size["ICC profile"] += n
+		case m == app2 && scrub.IsMPF(p):
+			size["MPF index"] += n
+		case m == app13 && scrub.IsPhotoshop(p):
+			size["IPTC"] += n
+		case m == app14 && scrub.IsAdobe(p):
+			size["Adobe"] += n
+		case m == scrub.COM:
+			size["comments"] += n
+		default:
+			size["other metadata"] += n
+		}
+	}
+	if err := s.Err(); err != nil {
+		return err
+	}
+	for _, k := range sizeKinds {
+		if n := size[k]; n > 0 {
+			fmt.Fprintf(w, "%-16s %10d %5.1f%%\n", k, n, 100*float64(n)/float64(total))
+		}
+	}
+	_, err := fmt.Fprintf(w, "%-16s %10d\n", "total", total)
+	return err
+}
+
+// thumbnailSize returns the size of the thumbnail image in the EXIF payload.
+func thumbnailSize(payload []byte) int64 {
+	fields, _ := scrub.DecodeEXIF(payload)
+	for _, f := range fields {
+		if f.Tag.IFD == scrub.IFD1 && f.Tag.String() == "ThumbnailLength" {
+			if u, ok := f.Value.([]uint32); ok && len(u) == 1 && int64(u[0]) < int64(len(payload)) {
+				return int64(u[0])
+			}
+		}
+	}
+	return 0
+}
@@ -0,0 +1,67 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"encoding/csv"
+	"os"
+	"strconv"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// table, set by -table, writes one row for each file.
+var table *csv.Writer
+
+var tableHeader = []string{"path", "has_exif", "has_gps", "has_xmp", "bytes_removable"}
+
+// newTable returns a writer for the table in the named format, csv or tsv,
+// having written the header row.
+func newTable(format string) *csv.Writer {
+	w := csv.NewWriter(os.Stdout)
+	if format == "tsv" {
+		w.Comma = '\t'
+	}
+	w.Write(tableHeader)
+	return w
+}
+
+// A row summarizes the metadata of a file.
+type row struct {
+	exif, gps, xmp bool
+}
+
+// add notes what the segment holds.
+func (r *row) add(seg scrub.Segment) {
+	p := seg.Payload
+	switch {
+	case seg.Marker == app1 && scrub.IsEXIF(p):
+		r.exif = true
+		fields, _ := scrub.DecodeEXIF(p)
+		for _, f := range fields {
+			r.gps = r.gps || f.Tag.IFD == scrub.GPSIFD
+		}
+	case seg.Marker == app1 && scrub.IsXMP(p):
+		r.xmp = true
+		props, _ := scrub.DecodeXMP(p)
+		for _, prop := range props {
+			r.gps = r.gps || prop.Name == "exif:GPSLatitude"
+		}
+	case seg.Marker == app1 && scrub.IsExtendedXMP(p):
+		r.xmp = true
+	}
+}
+
+func tableFile(f *os.File) (result, error) {
+	var r row
+	removed, changed, err := clean(nil, f, nil, r.add)
+	if err != nil {
+		return result{}, err
+	}
+	b := strconv.FormatBool
+	table.Write([]string{f.Name(), b(r.exif), b(r.gps), b(r.xmp), strconv.FormatInt(removed, 10)})
+	table.Flush()
+	return result{removed, changed}, table.Error()
+}
@@ -0,0 +1,89 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"fmt"
+	"image"
+	_ "image/gif"
+	"image/jpeg"
+	"image/png"
+	"io"
+	"io/ioutil"
+	"os"
+
+	"robpike.io/cmd/scrub/scrub"
+)
+
+// A verifier computes the image digest of the bytes written to it,
+// as they are written, so the input and output can be checked against
+// each other in one pass.
+type verifier struct {
+	pw   *io.PipeWriter
+	done chan bool
+	sum  [sha256.Size]byte
+	err  error
+}
+
+func newVerifier() *verifier {
+	pr, pw := io.Pipe()
+	v := &verifier{pw: pw, done: make(chan bool)}
+	go func() {
+		v.sum, v.err = scrub.ImageDigest(pr)
+		io.Copy(ioutil.Discard, pr) // Don't block the writer.
+		close(v.done)
+	}()
+	return v
+}
+
+func (v *verifier) Write(p []byte) (int, error) {
+	return v.pw.Write(p)
+}
+
+// digest returns the image digest of everything written.
+func (v *verifier) digest() ([sha256.Size]byte, error) {
+	v.pw.Close()
+	<-v.done
+	return v.sum, v.err
+}
+
+// validate checks that the scrubbed data, written for the file f, can
+// still be decoded, if it is in a format the image package reads:
+// JPEG, PNG, or GIF. Only the header is decoded unless full is set.
+// Data that does not decode passes if the original, read from f, does
+// not decode either, since then scrubbing did no harm.
+func validate(f *os.File, data []byte, full bool) error {
+	err := decodes(bytes.NewReader(data), full)
+	if err == nil {
+		return nil
+	}
+	if info, err1 := f.Stat(); err1 == nil && info.Mode().IsRegular() {
+		if decodes(io.NewSectionReader(f, 0, info.Size()), full) != nil {
+			return nil
+		}
+	}
+	return fmt.Errorf("validation failed: output does not decode: %v", err)
+}
+
+// decodes decodes the image read from r, or just its header if full is
+// not set. Images in formats or variants not supported are not errors.
+func decodes(r io.Reader, full bool) error {
+	var err error
+	if full {
+		_, _, err = image.Decode(r)
+	} else {
+		_, _, err = image.DecodeConfig(r)
+	}
+	switch err.(type) {
+	case jpeg.UnsupportedError, png.UnsupportedError:
+		return nil
+	}
+	if err == image.ErrFormat {
+		return nil
+	}
+	return err
+}
@@ -0,0 +1,74 @@
+// Copyright 2015 Rob Pike. All rights reserved.
+// Use of this source code is governed by a BSD-style
+// license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io/fs"
+	"log"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+// A fileState is what is known of a file between looks at it.
+type fileState struct {
+	size    int64
+	modTime time.Time
+	done    bool // Whether the file has been processed in this state.
+}
+
+// watchDir looks at the tree rooted at dir every interval, forever,
+// and scrubs in place each file that is new or has changed since it
+// was scrubbed, once the file has stopped changing, so that files
+// still being written are left alone. Files there at the start are
+// scrubbed too. Errors are reported and the watch goes on.
+//
+// The directory is polled, rather than watched through the system's
+// notifications (inotify, kqueue, ReadDirectoryChangesW), by choice.
+// The standard library has no portable interface to them, and scrub
+// uses nothing else. They are not delivered for many network file
+// systems, where shared upload folders often live. And a file must be
+// seen unchanged across an interval before it is scrubbed in any case,
+// which no notification can tell. Polling a folder once a second costs
+// little.
+func watchDir(dir string, interval time.Duration) {
+	seen := make(map[string]*fileState)
+	st := stats{batch: true}
+	for {
+		now := make(map[string]*fileState)
+		err := filepath.WalkDir(dir, func(name string, d fs.DirEntry, err error) error {
+			if err != nil {
+				log.Print(err)
+				return nil
+			}
+			if d.IsDir() || len(excludes) > 0 && excludes.match(name) {
+				return nil
+			}
+			info, err := d.Info()
+			if err != nil {
+				return nil // Removed since the directory was read.
+			}
+			state := &fileState{size: info.Size(), modTime: info.ModTime()}
+			if old := seen[name]; old != nil && old.size == state.size && old.modTime.Equal(state.modTime) {
+				state = old
+				if !state.done {
+					// Unchanged since the last look, so it is complete.
+					st.run(name, scrubFile)
+					state.done = true
+					if info, err := os.Lstat(name); err == nil {
+						state.size, state.modTime = info.Size(), info.ModTime()
+					}
+				}
+			}
+			now[name] = state
+			return nil
+		})
+		if err != nil {
+			log.Print(err)
+		}
+		seen = now
+		time.Sleep(interval)
+	}
+}